@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+// getAlertHistory lists alert episodes, newest first. Query parameters:
+// source and name (exact match), start and end (unix seconds, episodes
+// overlapping the range), active=true for alerts active now, and limit.
+func (api *Api) getAlertHistory(c *gin.Context) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+
+	f := store.AlertEpisodeFilter{
+		Source: c.Query("source"),
+		Name:   c.Query("name"),
+	}
+	var err error
+	if v := c.Query("start"); v != "" {
+		if f.Start, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start"})
+			return
+		}
+	}
+	if v := c.Query("end"); v != "" {
+		if f.End, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end"})
+			return
+		}
+	}
+	if v := c.Query("active"); v != "" {
+		if f.ActiveOnly, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid active"})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"episodes": api.store.AlertEpisodes(f)})
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+// AnnotationRequest is the body of a create or update. Time and End are unix
+// seconds; End is optional.
+type AnnotationRequest struct {
+	Time int64    `json:"time" binding:"required"`
+	End  int64    `json:"end"`
+	Text string   `json:"text" binding:"required"`
+	Tags []string `json:"tags"`
+}
+
+func (r AnnotationRequest) annotation() store.Annotation {
+	return store.Annotation{Time: r.Time, End: r.End, Text: r.Text, Tags: r.Tags}
+}
+
+// parseAnnotationFilter reads start, end (unix seconds), tag (repeatable) and
+// limit from the query string.
+func parseAnnotationFilter(c *gin.Context) (store.AnnotationFilter, error) {
+	f := store.AnnotationFilter{Tags: c.QueryArray("tag")}
+	var err error
+	if v := c.Query("start"); v != "" {
+		if f.Start, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, errors.New("invalid start")
+		}
+	}
+	if v := c.Query("end"); v != "" {
+		if f.End, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return f, errors.New("invalid end")
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			return f, errors.New("invalid limit")
+		}
+	}
+	return f, nil
+}
+
+// listAnnotations returns annotations overlapping start/end that carry every
+// tag, newest first.
+func (api *Api) listAnnotations(c *gin.Context) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+	f, err := parseAnnotationFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"annotations": api.store.Annotations(f)})
+}
+
+func (api *Api) getAnnotation(c *gin.Context) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+	a, err := api.store.Annotation(c.Param("id"))
+	if err != nil {
+		api.annotationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+func (api *Api) createAnnotation(c *gin.Context) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	a, err := api.store.AddAnnotation(req.annotation())
+	if err != nil {
+		api.annotationError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, a)
+}
+
+func (api *Api) updateAnnotation(c *gin.Context) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+	var req AnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	a, err := api.store.UpdateAnnotation(c.Param("id"), req.annotation())
+	if err != nil {
+		api.annotationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+func (api *Api) deleteAnnotation(c *gin.Context) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+	if err := api.store.DeleteAnnotation(c.Param("id")); err != nil {
+		api.annotationError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// annotationError maps a store error to a response. Validation errors are the
+// caller's; anything else failed to persist.
+func (api *Api) annotationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, store.ErrAnnotationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, store.ErrInvalidAnnotation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		api.logger.Error("Failed to save annotations", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
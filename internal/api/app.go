@@ -12,9 +12,13 @@ import (
 	"github.com/gin-contrib/timeout"
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/ygelfand/power-dash/internal/collector"
 	"github.com/ygelfand/power-dash/internal/config"
+	"github.com/ygelfand/power-dash/internal/exporter"
 	"github.com/ygelfand/power-dash/internal/powerwall"
 	"github.com/ygelfand/power-dash/internal/store"
 	"github.com/ygelfand/power-dash/internal/ui"
@@ -24,7 +28,7 @@ import (
 type Api struct {
 	powerwall        *powerwall.PowerwallGateway
 	proxy            *httputil.ReverseProxy
-	store            *store.Store
+	store            store.Storage
 	collectorManager *collector.Manager
 	options          *config.ProxyOptions
 	dashboards       []config.DashboardConfig
@@ -32,6 +36,8 @@ type Api struct {
 	importStatus     *ImportStatus
 	labelManager     *config.LabelManager
 	promqlEngine     *promql.Engine
+	remoteRead       http.Handler
+	registry         *prometheus.Registry
 	version          string
 }
 
@@ -44,7 +50,7 @@ type ImportStatus struct {
 	Percentage   float64 `json:"percentage"`
 }
 
-func NewApi(p *powerwall.PowerwallGateway, s *store.Store, cm *collector.Manager, opts *config.ProxyOptions, z *zap.Logger, lm *config.LabelManager, version string) *Api {
+func NewApi(p *powerwall.PowerwallGateway, s store.Storage, cm *collector.Manager, opts *config.ProxyOptions, z *zap.Logger, lm *config.LabelManager, version string) *Api {
 	if z == nil {
 		z = zap.NewNop()
 	}
@@ -55,6 +61,28 @@ func NewApi(p *powerwall.PowerwallGateway, s *store.Store, cm *collector.Manager
 		Timeout:    2 * time.Minute,
 	})
 
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "power_dash_build_info",
+			Help:        "Always 1; labeled with the running power-dash version.",
+			ConstLabels: prometheus.Labels{"version": version},
+		}, func() float64 { return 1 }),
+	)
+	if cm != nil {
+		registry.MustRegister(cm.Metrics()...)
+	}
+
+	var remoteRead http.Handler
+	if s != nil {
+		registry.MustRegister(exporter.NewLatestCollector(s, z))
+		if cq, ok := s.(chunkQueryable); ok {
+			remoteRead = newRemoteReadHandler(cq, registry)
+		}
+	}
+
 	return &Api{
 		powerwall:        p,
 		proxy:            newProxy(p),
@@ -66,17 +94,52 @@ func NewApi(p *powerwall.PowerwallGateway, s *store.Store, cm *collector.Manager
 		importStatus:     &ImportStatus{},
 		labelManager:     lm,
 		promqlEngine:     engine,
+		remoteRead:       remoteRead,
+		registry:         registry,
 		version:          version,
 	}
 }
 
+// tsdbStore returns the TSDB-backed store for handlers that need blocks,
+// rollups or snapshots, and answers 501 when the API runs on other storage.
+func (api *Api) tsdbStore(c *gin.Context) (*store.Store, bool) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return nil, false
+	}
+	st, ok := api.store.(*store.Store)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "not supported by this storage backend"})
+		return nil, false
+	}
+	return st, true
+}
+
+// longRunningRoutes stream their response and are exempt from the request
+// timeout, which would otherwise buffer the whole body in memory.
+var longRunningRoutes = map[string]bool{
+	"/api/v1/storage/snapshot": true,
+	"/api/v1/storage/delete":   true,
+	"/api/v1/export":           true,
+	"/api/v1/read":             true,
+	"/api/v1/quality":          true,
+	"/api/v1/prom/api/v1/read": true,
+}
+
 func timeoutMiddleware() gin.HandlerFunc {
-	return timeout.New(
+	withTimeout := timeout.New(
 		timeout.WithTimeout(10*time.Second),
 		timeout.WithResponse(func(c *gin.Context) {
 			c.String(http.StatusRequestTimeout, "timeout")
 		}),
 	)
+	return func(c *gin.Context) {
+		if longRunningRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		withTimeout(c)
+	}
 }
 
 func (api *Api) Handler() http.Handler {
@@ -86,7 +149,7 @@ func (api *Api) Handler() http.Handler {
 	router.Use(gin.Recovery())
 
 	// Enable Gzip compression
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/api/v1/storage/snapshot", "/api/v1/read", "/api/v1/prom/api/v1/read"})))
 
 	if api.logger != nil {
 		router.Use(ginzap.Ginzap(api.logger, time.RFC3339, true))
@@ -103,12 +166,26 @@ func (api *Api) Handler() http.Handler {
 		{
 			v1.POST("/query", api.batchQueryMetrics)
 			v1.POST("/latest", api.latestMetrics)
+			v1.POST("/energy", api.queryEnergy)
+			v1.GET("/export", api.exportData)
+			v1.GET("/quality", api.getQuality)
+			v1.GET("/metrics/catalog", api.getMetricCatalog)
+			v1.GET("/alerts/history", api.getAlertHistory)
+			v1.GET("/selftests", api.getSelfTests)
+			v1.GET("/utility/controls", api.getUtilityControls)
+			v1.GET("/annotations", api.listAnnotations)
+			v1.POST("/annotations", api.createAnnotation)
+			v1.GET("/annotations/:id", api.getAnnotation)
+			v1.PUT("/annotations/:id", api.updateAnnotation)
+			v1.DELETE("/annotations/:id", api.deleteAnnotation)
+			v1.POST("/export", api.exportData)
 			v1.GET("/dashboards", api.getDashboards)
 			v1.GET("/status", api.getStatus)
 			v1.GET("/settings", api.getSettings)
 			v1.POST("/settings", api.saveSettings)
 			v1.GET("/labels", api.getLabels)
 			v1.POST("/labels", api.saveLabels)
+			v1.GET("/collectors", api.getCollectors)
 			v1.POST("/collectors/run", api.forceRunCollectors)
 			v1.POST("/debug/query", api.debugQuery)
 			v1.GET("/debug/bundle", api.downloadTechBundle)
@@ -116,6 +193,9 @@ func (api *Api) Handler() http.Handler {
 			v1.POST("/import/run", api.runImport)
 			v1.GET("/import/status", api.getImportStatus)
 			v1.GET("/config", api.getConfig)
+			v1.POST("/read", api.promRemoteRead)
+			v1.POST("/storage/snapshot", api.snapshotStorage)
+			v1.POST("/storage/delete", api.deleteStorage)
 
 			// Prometheus API
 			prom := v1.Group("/prom/api/v1")
@@ -124,10 +204,27 @@ func (api *Api) Handler() http.Handler {
 				prom.POST("/query", api.promQuery)
 				prom.GET("/query_range", api.promQueryRange)
 				prom.POST("/query_range", api.promQueryRange)
+				prom.POST("/read", api.promRemoteRead)
+				prom.GET("/series", api.promSeries)
+				prom.POST("/series", api.promSeries)
+				prom.GET("/labels", api.promLabels)
+				prom.POST("/labels", api.promLabels)
+				prom.GET("/label/:name/values", api.promLabelValues)
+				prom.GET("/metadata", api.promMetadata)
+				prom.GET("/status/buildinfo", api.promBuildInfo)
+				prom.GET("/format_query", api.promFormatQuery)
+				prom.POST("/format_query", api.promFormatQuery)
 			}
 		}
 	}
 
+	router.GET("/metrics", gin.WrapH(promhttp.HandlerFor(api.registry, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+		// Responses are already compressed by the gzip middleware.
+		DisableCompression: true,
+		ErrorLog:           zap.NewStdLog(api.logger),
+	})))
+
 	router.StaticFS("/assets", http.FS(ui.GetAssetsFS()))
 	router.StaticFS("/images", http.FS(ui.GetImagesFS()))
 
@@ -0,0 +1,14 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/metrics"
+)
+
+// getMetricCatalog lists every metric the store knows with its unit, type,
+// labels and valid range.
+func (api *Api) getMetricCatalog(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"metrics": metrics.All()})
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+type EnergyQueryRequest struct {
+	Sites      []string `json:"sites"`
+	Directions []string `json:"directions"`
+	Start      int64    `json:"start"`
+	End        int64    `json:"end"`
+	Step       int64    `json:"step"`
+	Unit       string   `json:"unit"`
+	Timezone   string   `json:"timezone"`
+}
+
+func (api *Api) queryEnergy(c *gin.Context) {
+	st, ok := api.tsdbStore(c)
+	if !ok {
+		return
+	}
+
+	var req EnergyQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	step, err := store.ParseStep(req.Step, req.Unit, req.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if step.IsZero() || req.End <= req.Start {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start, end and a positive step or unit are required"})
+		return
+	}
+	if len(req.Sites) == 0 {
+		req.Sites = []string{"site", "load", "solar", "battery"}
+	}
+	if len(req.Directions) == 0 {
+		req.Directions = []string{"import", "export"}
+	}
+
+	results := make([]*store.EnergySeries, 0, len(req.Sites)*len(req.Directions))
+	for _, site := range req.Sites {
+		for _, dir := range req.Directions {
+			series, err := st.SelectEnergy(site, dir, req.Start, req.End, step)
+			if err != nil {
+				api.logger.Error("Energy query error", zap.Error(err), zap.String("site", site), zap.String("direction", dir))
+				continue
+			}
+			results = append(results, series)
+		}
+	}
+
+	c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/export"
+	"go.uber.org/zap"
+)
+
+func (api *Api) exportData(c *gin.Context) {
+	st, ok := api.tsdbStore(c)
+	if !ok {
+		return
+	}
+
+	var req export.Request
+	var err error
+	if c.Request.Method == http.MethodGet {
+		err = c.ShouldBindQuery(&req)
+	} else {
+		err = c.ShouldBindJSON(&req)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := req.Parse(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileName := fmt.Sprintf("power-dash-export-%d.%s", time.Now().Unix(), export.Extension(req.Format))
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Header("Content-Type", export.ContentType(req.Format))
+	c.Status(http.StatusOK)
+
+	if err := export.Write(c.Request.Context(), c.Writer, st, req); err != nil {
+		// Headers are already sent, so the client only sees a truncated body.
+		api.logger.Error("Export failed", zap.Error(err), zap.Strings("matchers", req.Matchers))
+	}
+}
@@ -7,11 +7,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/store"
 )
 
 func (api *Api) promQuery(c *gin.Context) {
-	query := c.Query("query")
-	tsStr := c.Query("time")
+	query := c.Request.FormValue("query")
+	tsStr := c.Request.FormValue("time")
 
 	if query == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "errorType": "bad_data", "error": "missing query argument"})
@@ -30,7 +31,7 @@ func (api *Api) promQuery(c *gin.Context) {
 		ts = time.Now()
 	}
 
-	qry, err := api.promqlEngine.NewInstantQuery(context.Background(), api.store.Queryable(), nil, query, ts)
+	qry, err := api.promqlEngine.NewInstantQuery(context.Background(), store.HideRollups(api.store.Queryable()), nil, query, ts)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "errorType": "bad_data", "error": err.Error()})
 		return
@@ -53,10 +54,10 @@ func (api *Api) promQuery(c *gin.Context) {
 }
 
 func (api *Api) promQueryRange(c *gin.Context) {
-	query := c.Query("query")
-	startStr := c.Query("start")
-	endStr := c.Query("end")
-	stepStr := c.Query("step")
+	query := c.Request.FormValue("query")
+	startStr := c.Request.FormValue("start")
+	endStr := c.Request.FormValue("end")
+	stepStr := c.Request.FormValue("step")
 
 	if query == "" || startStr == "" || endStr == "" || stepStr == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "errorType": "bad_data", "error": "missing required parameters"})
@@ -79,7 +80,7 @@ func (api *Api) promQueryRange(c *gin.Context) {
 		return
 	}
 
-	qry, err := api.promqlEngine.NewRangeQuery(context.Background(), api.store.Queryable(), nil, query, start, end, step)
+	qry, err := api.promqlEngine.NewRangeQuery(context.Background(), store.HideRollups(api.store.Queryable()), nil, query, start, end, step)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "errorType": "bad_data", "error": err.Error()})
 		return
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+// Bounds used by Prometheus when start or end are omitted.
+var (
+	promMinTime = time.Unix(math.MinInt64/1000+62135596801, 0).UTC()
+	promMaxTime = time.Unix(math.MaxInt64/1000-62135596801, 999999999).UTC()
+)
+
+type promMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+func promError(c *gin.Context, code int, errType, msg string) {
+	c.JSON(code, gin.H{"status": "error", "errorType": errType, "error": msg})
+}
+
+func promSuccess(c *gin.Context, data any) {
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": data})
+}
+
+// promParams parses the match[], start, end and limit parameters shared by the
+// metadata endpoints, from either the query string or a form body.
+func promParams(c *gin.Context) (matcherSets [][]*labels.Matcher, start, end time.Time, limit int, ok bool) {
+	if err := c.Request.ParseForm(); err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err.Error())
+		return nil, start, end, 0, false
+	}
+
+	for _, m := range c.Request.Form["match[]"] {
+		ms, err := parser.ParseMetricSelector(m)
+		if err != nil {
+			promError(c, http.StatusBadRequest, "bad_data", err.Error())
+			return nil, start, end, 0, false
+		}
+		matcherSets = append(matcherSets, ms)
+	}
+
+	start, end = promMinTime, promMaxTime
+	if v := c.Request.FormValue("start"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			promError(c, http.StatusBadRequest, "bad_data", "invalid start parameter")
+			return nil, start, end, 0, false
+		}
+		start = t
+	}
+	if v := c.Request.FormValue("end"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			promError(c, http.StatusBadRequest, "bad_data", "invalid end parameter")
+			return nil, start, end, 0, false
+		}
+		end = t
+	}
+
+	if v := c.Request.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			promError(c, http.StatusBadRequest, "bad_data", "invalid limit parameter")
+			return nil, start, end, 0, false
+		}
+		limit = n
+	}
+	return matcherSets, start, end, limit, true
+}
+
+// promQuerier opens a querier over [start, end] that hides rollup series, or
+// responds with an error and returns false.
+func (api *Api) promQuerier(c *gin.Context, start, end time.Time) (storage.Querier, bool) {
+	if api.store == nil {
+		promError(c, http.StatusInternalServerError, "internal", "storage not initialized")
+		return nil, false
+	}
+	q, err := store.HideRollups(api.store.Queryable()).Querier(start.UnixMilli(), end.UnixMilli())
+	if err != nil {
+		promError(c, http.StatusInternalServerError, "execution", err.Error())
+		return nil, false
+	}
+	return q, true
+}
+
+func (api *Api) promSeries(c *gin.Context) {
+	matcherSets, start, end, limit, ok := promParams(c)
+	if !ok {
+		return
+	}
+	if len(matcherSets) == 0 {
+		promError(c, http.StatusBadRequest, "bad_data", "no match[] parameter provided")
+		return
+	}
+
+	q, ok := api.promQuerier(c, start, end)
+	if !ok {
+		return
+	}
+	defer q.Close()
+
+	hints := &storage.SelectHints{Start: start.UnixMilli(), End: end.UnixMilli(), Func: "series", Limit: limit}
+	var sets []storage.SeriesSet
+	for _, ms := range matcherSets {
+		sets = append(sets, q.Select(c.Request.Context(), len(matcherSets) > 1, hints, ms...))
+	}
+	set := storage.NewMergeSeriesSet(sets, limit, storage.ChainedSeriesMerge)
+
+	result := []labels.Labels{}
+	for set.Next() {
+		result = append(result, set.At().Labels())
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	if err := set.Err(); err != nil {
+		promError(c, http.StatusInternalServerError, "execution", err.Error())
+		return
+	}
+	promSuccess(c, result)
+}
+
+func (api *Api) promLabels(c *gin.Context) {
+	api.promLabelQuery(c, func(q storage.Querier, hints *storage.LabelHints, ms []*labels.Matcher) ([]string, error) {
+		names, _, err := q.LabelNames(c.Request.Context(), hints, ms...)
+		return names, err
+	})
+}
+
+func (api *Api) promLabelValues(c *gin.Context) {
+	name := c.Param("name")
+	if !model.LabelName(name).IsValid() {
+		promError(c, http.StatusBadRequest, "bad_data", "invalid label name: "+name)
+		return
+	}
+	api.promLabelQuery(c, func(q storage.Querier, hints *storage.LabelHints, ms []*labels.Matcher) ([]string, error) {
+		values, _, err := q.LabelValues(c.Request.Context(), name, hints, ms...)
+		return values, err
+	})
+}
+
+// promLabelQuery runs fn once per match[] set (or once without matchers) and
+// returns the sorted union of the results.
+func (api *Api) promLabelQuery(c *gin.Context, fn func(storage.Querier, *storage.LabelHints, []*labels.Matcher) ([]string, error)) {
+	matcherSets, start, end, limit, ok := promParams(c)
+	if !ok {
+		return
+	}
+
+	q, ok := api.promQuerier(c, start, end)
+	if !ok {
+		return
+	}
+	defer q.Close()
+
+	if len(matcherSets) == 0 {
+		matcherSets = [][]*labels.Matcher{nil}
+	}
+	hints := &storage.LabelHints{Limit: limit}
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, ms := range matcherSets {
+		vals, err := fn(q, hints, ms)
+		if err != nil {
+			promError(c, http.StatusInternalServerError, "execution", err.Error())
+			return
+		}
+		for _, v := range vals {
+			if !seen[v] {
+				seen[v] = true
+				result = append(result, v)
+			}
+		}
+	}
+	sort.Strings(result)
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	promSuccess(c, result)
+}
+
+func (api *Api) promMetadata(c *gin.Context) {
+	metric := c.Request.FormValue("metric")
+	limit := 0
+	if v := c.Request.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			promError(c, http.StatusBadRequest, "bad_data", "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+
+	q, ok := api.promQuerier(c, promMinTime, promMaxTime)
+	if !ok {
+		return
+	}
+	defer q.Close()
+
+	names, _, err := q.LabelValues(c.Request.Context(), labels.MetricName, nil)
+	if err != nil {
+		promError(c, http.StatusInternalServerError, "execution", err.Error())
+		return
+	}
+
+	// Anything written before the registry existed is unknown.
+	result := make(map[string][]promMetadata)
+	for _, name := range names {
+		if metric != "" && name != metric {
+			continue
+		}
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		md := promMetadata{Type: "unknown"}
+		if info, ok := metrics.Lookup(name); ok {
+			md = promMetadata{Type: string(info.Type), Help: info.Description, Unit: info.Unit}
+		}
+		result[name] = []promMetadata{md}
+	}
+	promSuccess(c, result)
+}
+
+func (api *Api) promBuildInfo(c *gin.Context) {
+	promSuccess(c, gin.H{
+		"version":   embeddedPrometheusVersion(),
+		"revision":  api.version,
+		"branch":    "power-dash",
+		"buildUser": "",
+		"buildDate": "",
+		"goVersion": runtime.Version(),
+	})
+}
+
+func (api *Api) promFormatQuery(c *gin.Context) {
+	expr, err := parser.ParseExpr(c.Request.FormValue("query"))
+	if err != nil {
+		promError(c, http.StatusBadRequest, "bad_data", err.Error())
+		return
+	}
+	promSuccess(c, expr.Pretty(0))
+}
+
+// embeddedPrometheusVersion reports the Prometheus release whose query engine
+// is compiled in, so clients such as Grafana enable the matching features.
+// Go module versions map v0.309.1 to 3.9.1 and v0.53.0 to 2.53.0.
+func embeddedPrometheusVersion() string {
+	const fallback = "3.0.0"
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fallback
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path != "github.com/prometheus/prometheus" {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(dep.Version, "v"), ".")
+		if len(parts) < 3 || parts[0] != "0" {
+			return fallback
+		}
+		minor, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fallback
+		}
+		patch := strings.SplitN(parts[2], "-", 2)[0]
+		if minor >= 300 {
+			return "3." + strconv.Itoa(minor-300) + "." + patch
+		}
+		return "2." + strconv.Itoa(minor) + "." + patch
+	}
+	return fallback
+}
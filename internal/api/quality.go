@@ -0,0 +1,70 @@
+package api
+
+import (
+	"cmp"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/collector"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+// getQuality reports gaps, duplicates and flat-lined values. Query parameters:
+// start and end (unix seconds, default the last 7 days), interval and
+// flat_line (durations, defaults from the collection interval and 6h).
+// Metrics of collectors on their own schedule are checked against it.
+func (api *Api) getQuality(c *gin.Context) {
+	st, ok := api.tsdbStore(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	opts := store.QualityOptions{
+		Start:    now.Add(-7 * 24 * time.Hour).Unix(),
+		End:      now.Unix(),
+		Interval: time.Duration(api.options.CollectionInterval) * time.Second,
+	}
+	schedules, err := collector.ConfigSchedules(api.options.Collectors)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if v := c.Query("start"); v != "" {
+		if opts.Start, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start"})
+			return
+		}
+	}
+	if v := c.Query("end"); v != "" {
+		if opts.End, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end"})
+			return
+		}
+	}
+	if v := c.Query("interval"); v != "" {
+		if opts.Interval, err = time.ParseDuration(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid interval"})
+			return
+		}
+	}
+	if v := c.Query("flat_line"); v != "" {
+		if opts.FlatLine, err = time.ParseDuration(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid flat_line"})
+			return
+		}
+	}
+
+	opts.Intervals = collector.MetricIntervals(cmp.Or(opts.Interval, 30*time.Second), schedules)
+
+	report, err := st.Quality(c.Request.Context(), opts)
+	if err != nil {
+		api.logger.Error("Quality report failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -37,7 +37,14 @@ type BatchQueryRequest struct {
 	Start    int64  `json:"start"`
 	End      int64  `json:"end"`
 	Step     int64  `json:"step"`
+	Unit     string `json:"unit"`     // day, week, month or year; overrides Step
+	Timezone string `json:"timezone"` // IANA name, defaults to the site's
 	Function string `json:"function"`
+	// Annotations wraps the response as {"series": ..., "annotations": [...]}
+	// with the annotations overlapping [Start, End], optionally only those
+	// carrying every tag in AnnotationTags.
+	Annotations    bool     `json:"annotations"`
+	AnnotationTags []string `json:"annotation_tags"`
 }
 
 func (api *Api) batchQueryMetrics(c *gin.Context) {
@@ -52,6 +59,12 @@ func (api *Api) batchQueryMetrics(c *gin.Context) {
 		return
 	}
 
+	step, err := store.ParseStep(req.Step, req.Unit, req.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	results := make(map[string][]*store.DataPoint)
 
 	for _, m := range req.Metrics {
@@ -111,7 +124,7 @@ func (api *Api) batchQueryMetrics(c *gin.Context) {
 		}
 
 		for _, t := range targets {
-			points, err := api.store.Select(m.Name, t.Tags, req.Start, req.End, req.Step, req.Function)
+			points, err := api.store.Select(m.Name, t.Tags, req.Start, req.End, step, req.Function)
 			if err != nil {
 				api.logger.Error("Batch query error", zap.Error(err), zap.String("metric", m.Name), zap.Any("tags", t.Tags))
 				continue
@@ -124,6 +137,11 @@ func (api *Api) batchQueryMetrics(c *gin.Context) {
 		}
 	}
 
+	if req.Annotations {
+		annotations := api.store.Annotations(store.AnnotationFilter{Start: req.Start, End: req.End, Tags: req.AnnotationTags})
+		c.JSON(http.StatusOK, gin.H{"series": results, "annotations": annotations})
+		return
+	}
 	c.JSON(http.StatusOK, results)
 }
 
@@ -174,10 +192,11 @@ func (api *Api) getStatus(c *gin.Context) {
 		return
 	}
 
-	compJson := api.powerwall.RunQuery("ComponentsQuery", nil)
-	ctrl, err := api.powerwall.FetchController()
-	statusRaw, _ := api.powerwall.MakeAPIRequest("GET", "status", nil)
-	siteInfoRaw, _ := api.powerwall.MakeAPIRequest("GET", "site_info", nil)
+	ctx := c.Request.Context()
+	compJson := api.powerwall.RunQuery(ctx, "ComponentsQuery", nil)
+	ctrl, err := api.powerwall.FetchController(ctx)
+	statusRaw, _ := api.powerwall.MakeAPIRequest(ctx, "GET", "status", nil)
+	siteInfoRaw, _ := api.powerwall.MakeAPIRequest(ctx, "GET", "site_info", nil)
 	status := gin.H{
 		"components": nil,
 		"live":       nil,
@@ -401,6 +420,17 @@ func (api *Api) forceRunCollectors(c *gin.Context) {
 	c.JSON(http.StatusOK, report)
 }
 
+func (api *Api) getCollectors(c *gin.Context) {
+	if api.collectorManager == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "collector is disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"collectors": api.collectorManager.Health(),
+		"gateway":    api.powerwall.Breaker().Status(),
+	})
+}
+
 func (api *Api) debugQuery(c *gin.Context) {
 	var req struct {
 		Name   string `json:"name"`
@@ -411,7 +441,7 @@ func (api *Api) debugQuery(c *gin.Context) {
 		return
 	}
 
-	res := api.powerwall.RunQuery(req.Name, &req.Params)
+	res := api.powerwall.RunQuery(c.Request.Context(), req.Name, &req.Params)
 	if res == nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "query failed"})
 		return
@@ -433,7 +463,7 @@ func (api *Api) downloadTechBundle(c *gin.Context) {
 
 	// 1. Run all queries
 	for _, qName := range queries.QueryList() {
-		res := api.powerwall.RunQuery(qName, nil)
+		res := api.powerwall.RunQuery(c.Request.Context(), qName, nil)
 		if res != nil {
 			f, _ := zw.Create(fmt.Sprintf("queries/%s.json", qName))
 			var pretty bytes.Buffer
@@ -443,7 +473,7 @@ func (api *Api) downloadTechBundle(c *gin.Context) {
 	}
 
 	// 1b. Fetch System Config
-	sysConfig := api.powerwall.GetConfig()
+	sysConfig := api.powerwall.GetConfig(c.Request.Context())
 	if sysConfig != nil {
 		f, _ := zw.Create("config.json")
 		var pretty bytes.Buffer
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	promconfig "github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+const (
+	// Limits match the Prometheus server defaults.
+	remoteReadSampleLimit      = 50000000
+	remoteReadConcurrencyLimit = 10
+	remoteReadMaxBytesInFrame  = 1048576
+)
+
+// chunkQueryable is implemented by storage that can serve raw chunks.
+type chunkQueryable interface {
+	ChunkQueryable() storage.SampleAndChunkQueryable
+}
+
+// newRemoteReadHandler serves Prometheus remote read (snappy protobuf, both
+// sampled and streamed chunk responses) straight from storage. Rollup series
+// are hidden, as they are from the exporter.
+func newRemoteReadHandler(s chunkQueryable, reg prometheus.Registerer) http.Handler {
+	return remote.NewReadHandler(
+		slog.New(slog.NewJSONHandler(os.Stderr, nil)),
+		reg,
+		store.HideRollupChunks(s.ChunkQueryable()),
+		func() promconfig.Config { return promconfig.DefaultConfig },
+		remoteReadSampleLimit,
+		remoteReadConcurrencyLimit,
+		remoteReadMaxBytesInFrame,
+	)
+}
+
+func (api *Api) promRemoteRead(c *gin.Context) {
+	if api.remoteRead == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+	api.remoteRead.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+// SelfTestEntry is a recorded test run with its comparison to the previous
+// run of the same kind on the same device, if there is one.
+type SelfTestEntry struct {
+	store.SelfTestRun
+	Comparison *store.SelfTestComparison `json:"comparison,omitempty"`
+}
+
+// getSelfTests returns recorded inverter self-test and protection-trip test
+// runs, newest first, filtered by kind, device, start, end (unix seconds) and
+// limit.
+func (api *Api) getSelfTests(c *gin.Context) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+
+	f := store.SelfTestFilter{
+		Kind:   c.Query("kind"),
+		Device: c.Query("device"),
+	}
+	var err error
+	if v := c.Query("start"); v != "" {
+		if f.Start, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start"})
+			return
+		}
+	}
+	if v := c.Query("end"); v != "" {
+		if f.End, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end"})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	runs := api.store.SelfTestRuns(f)
+	entries := make([]SelfTestEntry, 0, len(runs))
+	for _, run := range runs {
+		entry := SelfTestEntry{SelfTestRun: run}
+		prev := api.store.SelfTestRuns(store.SelfTestFilter{Kind: run.Kind, Device: run.Device, End: run.Time - 1, Limit: 1})
+		if len(prev) > 0 {
+			cmp := store.CompareSelfTestRuns(run, prev[0])
+			entry.Comparison = &cmp
+		}
+		entries = append(entries, entry)
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": entries})
+}
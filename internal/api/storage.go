@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/backup"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+func (api *Api) snapshotStorage(c *gin.Context) {
+	st, ok := api.tsdbStore(c)
+	if !ok {
+		return
+	}
+	opts := backup.Options{
+		Version:    api.version,
+		ConfigPath: api.options.ConfigPath,
+	}
+	if api.labelManager != nil {
+		opts.LabelsPath = api.labelManager.Path()
+	}
+
+	fileName := fmt.Sprintf("power-dash-backup-%d.tar.gz", time.Now().Unix())
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Header("Content-Type", "application/gzip")
+
+	if err := backup.Write(c.Writer, st, opts); err != nil {
+		api.logger.Error("Failed to write backup", zap.Error(err))
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	}
+}
+
+// deleteStorage deletes, or with a rewrite rescales, the samples selected by a
+// store.ModifyRequest. With dry_run set it only reports what would change.
+func (api *Api) deleteStorage(c *gin.Context) {
+	st, ok := api.tsdbStore(c)
+	if !ok {
+		return
+	}
+	var req store.ModifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := req.Parse(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := st.Modify(c.Request.Context(), req)
+	if err != nil {
+		api.logger.Error("Storage modification failed", zap.Error(err))
+		if res != nil && res.Samples > 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "partial": res})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -31,7 +31,7 @@ func (api *Api) getConfig(c *gin.Context) {
 	configMutex.RUnlock()
 
 	// Fetch fresh config
-	cfg, err := api.powerwall.FetchConfig()
+	cfg, err := api.powerwall.FetchConfig(c.Request.Context())
 	if err != nil {
 		api.logger.Error("Failed to fetch config", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch system config"})
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+// getUtilityControls returns the IEEE 2030.5 utility control changes within
+// start and end (unix seconds), newest first and capped by limit, along with
+// the periods during which each control and its limits were in effect.
+func (api *Api) getUtilityControls(c *gin.Context) {
+	if api.store == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage not initialized"})
+		return
+	}
+
+	var f store.UtilityControlFilter
+	var err error
+	if v := c.Query("start"); v != "" {
+		if f.Start, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start"})
+			return
+		}
+	}
+	if v := c.Query("end"); v != "" {
+		if f.End, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end"})
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":  api.store.UtilityControlEvents(f),
+		"periods": api.store.UtilityControlPeriods(f.Start, f.End),
+	})
+}
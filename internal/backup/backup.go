@@ -0,0 +1,319 @@
+// Package backup writes and restores tar.gz archives of the power-dash data
+// directory together with its configuration files.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+// FormatVersion is bumped whenever the archive layout changes incompatibly.
+const FormatVersion = 1
+
+const (
+	manifestName = "manifest.json"
+	dataPrefix   = "data/"
+	configName   = "config/power-dash.yaml"
+	labelsName   = "config/power-dash-labels.yaml"
+)
+
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	Version       string    `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Original locations of the archived config files, if any.
+	ConfigPath string `json:"config_path,omitempty"`
+	LabelsPath string `json:"labels_path,omitempty"`
+}
+
+type Options struct {
+	Version    string
+	ConfigPath string
+	LabelsPath string
+}
+
+// Write snapshots st and streams a tar.gz archive of it to w. The snapshot is
+// taken before anything is written, so a failure leaves w untouched.
+func Write(w io.Writer, st *store.Store, opts Options) error {
+	dir, err := st.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := Manifest{
+		FormatVersion: FormatVersion,
+		Version:       opts.Version,
+		CreatedAt:     time.Now().UTC(),
+	}
+	configFiles := make(map[string]string)
+	if _, err := os.Stat(opts.ConfigPath); opts.ConfigPath != "" && err == nil {
+		configFiles[configName] = opts.ConfigPath
+		manifest.ConfigPath = opts.ConfigPath
+	}
+	if _, err := os.Stat(opts.LabelsPath); opts.LabelsPath != "" && err == nil {
+		configFiles[labelsName] = opts.LabelsPath
+		manifest.LabelsPath = opts.LabelsPath
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	mData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0o644, Size: int64(len(mData)), ModTime: manifest.CreatedAt}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(mData); err != nil {
+		return err
+	}
+
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		return addFile(tw, p, dataPrefix+filepath.ToSlash(rel), info)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive data: %w", err)
+	}
+
+	for name, p := range configFiles {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if err := addFile(tw, p, name, info); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", p, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addFile(tw *tar.Writer, src, name string, info os.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+		return tw.WriteHeader(hdr)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+type RestoreOptions struct {
+	DataPath string
+	// ConfigPath and LabelsPath are only written when set.
+	ConfigPath string
+	LabelsPath string
+	Version    string
+	// Force replaces a non-empty data directory and skips the version check.
+	Force bool
+}
+
+// Restore extracts an archive written by Write. The data directory is extracted
+// next to DataPath and swapped in only once the whole archive has been read; any
+// existing directory or config file is kept with a .bak-<timestamp> suffix.
+func Restore(r io.Reader, opts RestoreOptions) (*Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("archive does not start with %s", manifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if err := checkCompatible(&manifest, opts); err != nil {
+		return nil, err
+	}
+
+	if !opts.Force {
+		if entries, err := os.ReadDir(opts.DataPath); err == nil && len(entries) > 0 {
+			return nil, fmt.Errorf("data directory %s is not empty (use --force to replace it)", opts.DataPath)
+		}
+	}
+
+	suffix := time.Now().Format("20060102-150405")
+	staging := strings.TrimRight(opts.DataPath, string(filepath.Separator)) + ".restore-" + suffix
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		return nil, err
+	}
+	configs := make(map[string][]byte)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			_ = os.RemoveAll(staging)
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		name := path.Clean(hdr.Name)
+		if strings.HasPrefix(name, "..") || path.IsAbs(name) {
+			_ = os.RemoveAll(staging)
+			return nil, fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+
+		switch {
+		case strings.HasPrefix(name, dataPrefix):
+			if err := extract(tr, hdr, filepath.Join(staging, filepath.FromSlash(strings.TrimPrefix(name, dataPrefix)))); err != nil {
+				_ = os.RemoveAll(staging)
+				return nil, err
+			}
+		case (name == configName || name == labelsName) && hdr.Typeflag == tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				_ = os.RemoveAll(staging)
+				return nil, err
+			}
+			configs[name] = data
+		}
+	}
+
+	if _, err := os.Stat(opts.DataPath); err == nil {
+		if err := os.Rename(opts.DataPath, opts.DataPath+".bak-"+suffix); err != nil {
+			_ = os.RemoveAll(staging)
+			return nil, fmt.Errorf("failed to move existing data aside: %w", err)
+		}
+	}
+	if err := os.Rename(staging, opts.DataPath); err != nil {
+		return nil, fmt.Errorf("failed to move restored data into place: %w", err)
+	}
+
+	for name, dest := range map[string]string{configName: opts.ConfigPath, labelsName: opts.LabelsPath} {
+		data, ok := configs[name]
+		if dest == "" || !ok {
+			continue
+		}
+		if _, err := os.Stat(dest); err == nil {
+			if err := os.Rename(dest, dest+".bak-"+suffix); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.WriteFile(dest, data, 0o644); err != nil {
+			return nil, err
+		}
+	}
+
+	return &manifest, nil
+}
+
+func extract(tr *tar.Reader, hdr *tar.Header, dest string) error {
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(dest, 0o755)
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return nil
+}
+
+func checkCompatible(m *Manifest, opts RestoreOptions) error {
+	if m.FormatVersion > FormatVersion {
+		return fmt.Errorf("archive format %d is newer than supported format %d", m.FormatVersion, FormatVersion)
+	}
+	if opts.Force {
+		return nil
+	}
+	if cmp, ok := compareVersions(m.Version, opts.Version); ok && cmp > 0 {
+		return fmt.Errorf("archive was written by power-dash %s, which is newer than %s (use --force to restore anyway)", m.Version, opts.Version)
+	}
+	return nil
+}
+
+// compareVersions compares two vMAJOR.MINOR.PATCH strings. ok is false when
+// either is not a release version, such as a commit hash from a dev build.
+func compareVersions(a, b string) (int, bool) {
+	pa, okA := parseVersion(a)
+	pb, okB := parseVersion(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			if pa[i] > pb[i] {
+				return 1, true
+			}
+			return -1, true
+		}
+	}
+	return 0, true
+}
+
+func parseVersion(v string) ([3]int, bool) {
+	var out [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return out, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return out, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
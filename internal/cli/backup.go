@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ygelfand/power-dash/internal/backup"
+	"github.com/ygelfand/power-dash/internal/config"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+func newBackupCmd(logger *zap.Logger) *cobra.Command {
+	var output, server string
+
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Write a backup archive of local storage and configuration",
+		Long: `Snapshot the local storage and write it, together with the config and label
+files, to a tar.gz archive. Use --server to fetch the archive from a running
+power-dash instance instead of opening the data directory directly.`,
+		Annotations:  map[string]string{noPasswordAnnotation: "true"},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output == "" {
+				output = fmt.Sprintf("power-dash-backup-%d.tar.gz", time.Now().Unix())
+			}
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if server != "" {
+				err = fetchBackup(f, server)
+			} else {
+				err = writeLocalBackup(f, logger)
+			}
+			if err != nil {
+				_ = os.Remove(output)
+				return err
+			}
+			fmt.Printf("Backup written to %s\n", output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "archive path (default power-dash-backup-<timestamp>.tar.gz)")
+	cmd.Flags().StringVar(&server, "server", "", "URL of a running power-dash instance to fetch the backup from")
+	return cmd
+}
+
+func newRestoreCmd(logger *zap.Logger) *cobra.Command {
+	var force, withConfig bool
+
+	cmd := &cobra.Command{
+		Use:   "restore <archive>",
+		Short: "Restore local storage from a backup archive",
+		Long: `Restore the data directory from an archive written by 'power-dash backup'.
+power-dash must not be running. The existing data directory is kept next to
+the restored one with a .bak-<timestamp> suffix.`,
+		Args:         cobra.ExactArgs(1),
+		Annotations:  map[string]string{noPasswordAnnotation: "true"},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			opts := backup.RestoreOptions{
+				DataPath: storageDataPath(),
+				Version:  GetPowerDashVersion(),
+				Force:    force,
+			}
+			if withConfig {
+				opts.ConfigPath = viper.ConfigFileUsed()
+				if opts.ConfigPath == "" {
+					return fmt.Errorf("--with-config requires a config file (use --config)")
+				}
+				opts.LabelsPath = config.NewLabelManager(opts.ConfigPath, viper.GetString("label-config"), logger).Path()
+			}
+
+			manifest, err := backup.Restore(f, opts)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Restored backup from %s (power-dash %s) into %s\n",
+				manifest.CreatedAt.Local().Format(time.RFC3339), manifest.Version, opts.DataPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "replace a non-empty data directory and skip the version check")
+	cmd.Flags().BoolVar(&withConfig, "with-config", false, "also restore the config and label files")
+	return cmd
+}
+
+func storageDataPath() string {
+	dataPath := viper.GetString("storage.path")
+	if dataPath == "" {
+		dataPath = "./data"
+	}
+	return dataPath
+}
+
+// openLocalStore opens the storage configured under storage.* directly.
+func openLocalStore(logger *zap.Logger) (*store.Store, error) {
+	storageOpts := config.StorageOptions{
+		DataPath:          storageDataPath(),
+		Retention:         viper.GetString("storage.retention"),
+		PartitionDuration: viper.GetString("storage.partition"),
+		RetentionPolicies: viper.GetStringMapString("storage.retention-policies"),
+	}
+	policies, err := storageOpts.GetRetentionPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage retention policies: %w", err)
+	}
+
+	st, err := store.NewStore(store.Config{
+		DataPath:          storageOpts.DataPath,
+		Retention:         storageOpts.GetRetention(),
+		PartitionDuration: storageOpts.GetPartitionDuration(),
+		RetentionPolicies: policies,
+	}, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local storage: %w", err)
+	}
+	return st, nil
+}
+
+func writeLocalBackup(w io.Writer, logger *zap.Logger) error {
+	st, err := openLocalStore(logger)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	configPath := viper.ConfigFileUsed()
+	return backup.Write(w, st, backup.Options{
+		Version:    GetPowerDashVersion(),
+		ConfigPath: configPath,
+		LabelsPath: config.NewLabelManager(configPath, viper.GetString("label-config"), logger).Path(),
+	})
+}
+
+func fetchBackup(w io.Writer, server string) error {
+	url := strings.TrimRight(server, "/") + "/api/v1/storage/snapshot"
+	resp, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -1,6 +1,7 @@
 package connect
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/spf13/cobra"
@@ -33,7 +34,7 @@ func NewConnectValidateCmd(opts *config.PowerwallOptions, logger *zap.Logger) *c
 
 			for _, mode := range allModes {
 				cmd.Printf("── %s mode ──────────────────────\n", mode)
-				results := pwr.ConnectivityCheck(mode)
+				results := pwr.ConnectivityCheck(context.Background(), mode)
 				for _, r := range results {
 					icon := "✅"
 					detail := ""
@@ -2,6 +2,7 @@ package debug
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 
 	"github.com/spf13/cobra"
@@ -20,7 +21,7 @@ func NewDebugConfigCmd(opts *config.PowerwallOptions, logger *zap.Logger) *cobra
 			if pwr == nil {
 				return
 			}
-			debug := pwr.GetConfig()
+			debug := pwr.GetConfig(context.Background())
 			if debug != nil {
 				var prettyJSON bytes.Buffer
 				err := json.Indent(&prettyJSON, []byte(*debug), "", "\t")
@@ -2,6 +2,7 @@ package debug
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -27,7 +28,7 @@ func NewDebugQueryCmd(opts *config.PowerwallOptions, logger *zap.Logger) *cobra.
 			if pwr == nil {
 				return
 			}
-			debug := pwr.RunQuery(args[0], &params)
+			debug := pwr.RunQuery(context.Background(), args[0], &params)
 			var prettyJSON bytes.Buffer
 			err := json.Indent(&prettyJSON, []byte(*debug), "", "\t")
 			if err != nil {
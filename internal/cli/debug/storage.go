@@ -1,13 +1,21 @@
 package debug
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/ygelfand/power-dash/internal/collector"
+	"github.com/ygelfand/power-dash/internal/config"
 	"github.com/ygelfand/power-dash/internal/store"
 	"go.uber.org/zap"
 )
@@ -138,7 +146,7 @@ func NewDebugStorageCmd(logger *zap.Logger) *cobra.Command {
 				}
 				fmt.Printf("Series: %s\n", strings.Join(lStrs, ","))
 
-				points, err := st.Select(metric, tagMap, start, now, 0, "")
+				points, err := st.Select(metric, tagMap, start, now, store.Step{}, "")
 				if err != nil {
 					fmt.Printf("  Error: %v\n", err)
 					continue
@@ -156,5 +164,417 @@ func NewDebugStorageCmd(logger *zap.Logger) *cobra.Command {
 		},
 	})
 
+	storageCmd.AddCommand(newStorageGapsCmd(logger))
+	storageCmd.AddCommand(newStorageDeleteCmd(logger))
+	storageCmd.AddCommand(newStorageVerifyCmd(logger))
+
 	return storageCmd
 }
+
+// openStore opens the storage configured under storage.* for inspection.
+func openStore(logger *zap.Logger) (*store.Store, error) {
+	return store.NewStore(storeConfig(), logger)
+}
+
+func storeConfig() store.Config {
+	dataPath := viper.GetString("storage.path")
+	if dataPath == "" {
+		dataPath = "./data"
+	}
+	retention, _ := time.ParseDuration(viper.GetString("storage.retention"))
+	partition, err := time.ParseDuration(viper.GetString("storage.partition"))
+	if err != nil || partition <= 0 {
+		partition = 2 * time.Hour
+	}
+	return store.Config{
+		DataPath:          dataPath,
+		Retention:         retention,
+		PartitionDuration: partition,
+	}
+}
+
+func newStorageGapsCmd(logger *zap.Logger) *cobra.Command {
+	var (
+		since, interval, flatLine time.Duration
+		asJSON                    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "gaps",
+		Short: "Report collection gaps, duplicates and flat-lined series",
+		Long: `Scan local storage for gaps in collection marks and individual series,
+duplicated or out-of-order samples and flat-lined values, and list the days
+whose data is complete enough to trust along with ranges worth backfilling.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			st, err := openStore(logger)
+			if err != nil {
+				cmd.PrintErrf("Failed to open storage: %v\n", err)
+				return
+			}
+			defer st.Close()
+
+			if interval == 0 {
+				interval = 30 * time.Second
+				if secs := viper.GetInt("collection-interval"); secs > 0 {
+					interval = time.Duration(secs) * time.Second
+				}
+			}
+			var opts map[string]config.CollectorOptions
+			if err := viper.UnmarshalKey("collectors", &opts); err != nil {
+				cmd.PrintErrf("Invalid collectors config: %v\n", err)
+				return
+			}
+			schedules, err := collector.ConfigSchedules(opts)
+			if err != nil {
+				cmd.PrintErrf("Invalid collectors config: %v\n", err)
+				return
+			}
+			now := time.Now()
+			report, err := st.Quality(context.Background(), store.QualityOptions{
+				Start:     now.Add(-since).Unix(),
+				End:       now.Unix(),
+				Interval:  interval,
+				Intervals: collector.MetricIntervals(interval, schedules),
+				FlatLine:  flatLine,
+			})
+			if err != nil {
+				cmd.PrintErrf("Quality scan failed: %v\n", err)
+				return
+			}
+
+			if asJSON {
+				out, _ := json.MarshalIndent(report, "", "  ")
+				fmt.Println(string(out))
+				return
+			}
+			printQualityReport(report)
+		},
+	}
+	cmd.Flags().DurationVar(&since, "since", 7*24*time.Hour, "how far back to scan")
+	cmd.Flags().DurationVar(&interval, "interval", 0, "expected collection interval (default collection-interval or 30s)")
+	cmd.Flags().DurationVar(&flatLine, "flat-line", 6*time.Hour, "flag live values unchanged for this long")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the report as JSON")
+	return cmd
+}
+
+func printQualityReport(r *store.QualityReport) {
+	ts := func(t int64) string { return time.Unix(t, 0).Format("2006-01-02 15:04:05") }
+	series := func(is store.SeriesIssue) string {
+		var parts []string
+		for k, v := range is.Labels {
+			parts = append(parts, k+"="+v)
+		}
+		sort.Strings(parts)
+		return is.Metric + "{" + strings.Join(parts, ",") + "}"
+	}
+
+	fmt.Printf("Quality report %s to %s (interval %s)\n\n", ts(r.Start), ts(r.End), r.Interval)
+
+	fmt.Printf("Collection gaps: %d\n", len(r.CollectionGaps))
+	for _, g := range r.CollectionGaps {
+		fmt.Printf("  %s  ->  %s  (%s)\n", ts(g.Start), ts(g.End), g.Duration)
+	}
+
+	sections := []struct {
+		title  string
+		issues []store.SeriesIssue
+	}{
+		{"Series gaps", r.SeriesGaps},
+		{"Duplicate samples", r.Duplicates},
+		{"Out-of-order counter samples", r.OutOfOrder},
+		{"Flat-lined values", r.FlatLines},
+	}
+	for _, sec := range sections {
+		fmt.Printf("\n%s: %d\n", sec.title, len(sec.issues))
+		for _, is := range sec.issues {
+			extra := ""
+			if is.Samples > 0 {
+				extra = fmt.Sprintf(", %d samples", is.Samples)
+			} else if is.Value != 0 {
+				extra = fmt.Sprintf(", value %g", is.Value)
+			}
+			fmt.Printf("  % -50s %s  ->  %s  (%s%s)\n", series(is), ts(is.Start), ts(is.End), is.Duration, extra)
+		}
+	}
+
+	fmt.Printf("\nSuggested backfill ranges: %d\n", len(r.Backfill))
+	for _, g := range r.Backfill {
+		fmt.Printf("  %s  ->  %s  (%s)\n", time.Unix(g.Start, 0).Format(time.RFC3339), time.Unix(g.End, 0).Format(time.RFC3339), g.Duration)
+	}
+
+	fmt.Printf("\n% -12s % -10s % -10s %s\n", "DAY", "COVERAGE", "GAPS", "TRUSTED")
+	for _, d := range r.Days {
+		trusted := "yes"
+		if !d.Trusted {
+			trusted = "no"
+			if d.CounterDrop {
+				trusted = "no (counter drop)"
+			}
+		}
+		fmt.Printf("% -12s % -10s % -10s %s\n", d.Date, fmt.Sprintf("%.1f%%", d.Coverage*100), (time.Duration(d.GapSeconds) * time.Second).String(), trusted)
+	}
+}
+
+func newStorageDeleteCmd(logger *zap.Logger) *cobra.Command {
+	var (
+		req        store.ModifyRequest
+		rw         store.Rewrite
+		start, end string
+		server     string
+		asJSON     bool
+	)
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete or rewrite samples of matching series over a time range",
+		Long: `Delete the samples of the series selected by --match between --start and
+--end, or with --scale, --offset or --negate rewrite them in place. Rollups
+covering the range are recomputed. Use --dry-run to see how many samples
+would be affected. Times accept RFC3339 or unix seconds.
+
+A running power-dash holds the storage lock; use --server to go through it.`,
+		Example: `  power-dash debug storage delete --match battery_soe_percent --start 2024-03-01T00:00:00Z --end 2024-03-08T00:00:00Z --scale 0.01 --dry-run
+  power-dash debug storage delete --match 'power_watts{site="solar"}' --start 1709251200 --end 1709856000 --server http://localhost:8080`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if req.Start, err = parseStorageTime(start); err != nil {
+				return fmt.Errorf("invalid --start: %w", err)
+			}
+			if req.End, err = parseStorageTime(end); err != nil {
+				return fmt.Errorf("invalid --end: %w", err)
+			}
+			if cmd.Flags().Changed("scale") || cmd.Flags().Changed("offset") || cmd.Flags().Changed("negate") {
+				req.Rewrite = &rw
+			}
+			if _, err := req.Parse(); err != nil {
+				return err
+			}
+
+			var res *store.ModifyResult
+			if server != "" {
+				res, err = postStorageDelete(server, req)
+			} else {
+				st, openErr := openStore(logger)
+				if openErr != nil {
+					return fmt.Errorf("failed to open storage: %w", openErr)
+				}
+				defer st.Close()
+				res, err = st.Modify(context.Background(), req)
+			}
+			if err != nil {
+				if res != nil && res.Samples > 0 {
+					fmt.Println("Changed before the failure:")
+					printModifyResult(res)
+				}
+				return err
+			}
+
+			if asJSON {
+				out, _ := json.MarshalIndent(res, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+			printModifyResult(res)
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVarP(&req.Matchers, "match", "m", nil, "series selector, may be repeated (e.g. 'battery_soe_percent')")
+	cmd.Flags().StringVar(&start, "start", "", "start time (required)")
+	cmd.Flags().StringVar(&end, "end", "", "end time (required)")
+	cmd.Flags().Float64Var(&rw.Scale, "scale", 1, "rewrite: multiply values by this factor")
+	cmd.Flags().Float64Var(&rw.Offset, "offset", 0, "rewrite: add this to values after scaling")
+	cmd.Flags().BoolVar(&rw.Negate, "negate", false, "rewrite: flip the sign of values")
+	cmd.Flags().BoolVar(&req.DryRun, "dry-run", false, "only report the samples that would be affected")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the result as JSON")
+	cmd.Flags().StringVar(&server, "server", "", "URL of a running power-dash instance to modify")
+	_ = cmd.MarkFlagRequired("start")
+	_ = cmd.MarkFlagRequired("end")
+	return cmd
+}
+
+func parseStorageTime(s string) (int64, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func postStorageDelete(server string, req store.ModifyRequest) (*store.ModifyResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimRight(server, "/") + "/api/v1/storage/delete"
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var res store.ModifyResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func printModifyResult(res *store.ModifyResult) {
+	verb := "Deleted"
+	if res.Rewrite != nil {
+		verb = "Rewrote"
+	}
+	if res.DryRun {
+		verb = "Would affect"
+	}
+	fmt.Printf("%s %d samples in %d series\n", verb, res.Samples, len(res.Series))
+	for _, ms := range res.Series {
+		var parts []string
+		for k, v := range ms.Labels {
+			parts = append(parts, k+"="+v)
+		}
+		sort.Strings(parts)
+		fmt.Printf("  % -60s %d\n", ms.Metric+"{"+strings.Join(parts, ",")+"}", ms.Samples)
+	}
+}
+
+func newStorageVerifyCmd(logger *zap.Logger) *cobra.Command {
+	var (
+		opts   store.VerifyOptions
+		repair bool
+		asJSON bool
+	)
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check storage integrity and optionally repair it",
+		Long: `Read every block's index and chunks, replay the write-ahead logs, and report
+corrupt blocks, overlapping blocks left by out-of-order imports and metrics
+with unusually many series or label values. Nothing is written unless
+--repair is given.
+
+With --repair, the readable series of corrupt blocks are rewritten into new
+blocks and the corrupt blocks are moved to <storage.path>/quarantine. The
+storage is then opened, which truncates a corrupt write-ahead log at the
+first bad record, and overlapping blocks are compacted. Stop power-dash
+before repairing.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := storeConfig()
+			ctx := context.Background()
+			report, err := store.Verify(ctx, cfg.DataPath, opts)
+			if err != nil {
+				return fmt.Errorf("failed to verify storage: %w", err)
+			}
+
+			var res *store.RepairResult
+			if repair {
+				res, err = store.Repair(ctx, cfg, report, logger)
+				if err != nil {
+					return fmt.Errorf("repair failed: %w", err)
+				}
+			}
+
+			if asJSON {
+				out, _ := json.MarshalIndent(struct {
+					*store.VerifyReport
+					Repair *store.RepairResult `json:"repair,omitempty"`
+				}{report, res}, "", "  ")
+				fmt.Println(string(out))
+			} else {
+				printVerifyReport(report)
+				if res != nil {
+					printRepairResult(res)
+				}
+			}
+			if !report.Healthy && res == nil {
+				return fmt.Errorf("storage has errors; run with --repair to fix them")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&repair, "repair", false, "quarantine corrupt blocks, salvage their readable series and compact overlaps")
+	cmd.Flags().IntVar(&opts.MaxSeries, "max-series", 1000, "flag metrics with more series than this")
+	cmd.Flags().IntVar(&opts.MaxLabelValues, "max-label-values", 200, "flag labels with more distinct values than this")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the report as JSON")
+	return cmd
+}
+
+func printVerifyReport(r *store.VerifyReport) {
+	ts := func(t int64) string { return time.Unix(t, 0).Format("2006-01-02 15:04") }
+	status := func(bad bool) string {
+		if bad {
+			return "CORRUPT"
+		}
+		return "ok"
+	}
+
+	fmt.Printf("Storage verification (%s)\n\n", r.DataPath)
+
+	fmt.Printf("Blocks: %d, corrupt: %d\n", len(r.Blocks), len(r.CorruptBlocks()))
+	fmt.Printf("  % -28s % -35s % 8s % 10s % 12s  %s\n", "ULID", "RANGE", "SERIES", "CHUNKS", "SAMPLES", "STATUS")
+	for _, bc := range r.Blocks {
+		st := status(bc.Corrupt)
+		if bc.Superseded {
+			st += " (superseded)"
+		}
+		span := "unknown"
+		if bc.End > 0 {
+			span = ts(bc.Start) + " -> " + ts(bc.End)
+		}
+		fmt.Printf("  % -28s % -35s % 8d % 10d % 12d  %s\n", bc.ULID, span, bc.Series, bc.Chunks, bc.Samples, st)
+		for _, e := range bc.Errors {
+			fmt.Printf("      %s\n", e)
+		}
+		if more := bc.ErrorCount - len(bc.Errors); more > 0 {
+			fmt.Printf("      ... %d more errors\n", more)
+		}
+	}
+
+	for _, wc := range []*store.WALCheck{r.WAL, r.WBL} {
+		if wc == nil {
+			continue
+		}
+		fmt.Printf("\nLog %s: %s\n", wc.Dir, status(wc.Error != ""))
+		if wc.Checkpoint != "" {
+			fmt.Printf("  Checkpoint: %s\n", wc.Checkpoint)
+		}
+		fmt.Printf("  Segments: %d, records: %d, series: %d, samples: %d\n", wc.Segments, wc.Records, wc.Series, wc.Samples)
+		if wc.Error != "" {
+			fmt.Printf("  Error at segment %d offset %d: %s\n", wc.Segment, wc.Offset, wc.Error)
+		}
+	}
+
+	fmt.Printf("\nOverlapping blocks: %d\n", len(r.Overlaps))
+	for _, o := range r.Overlaps {
+		fmt.Printf("  %s -> %s  %s\n", ts(o.Start), ts(o.End), strings.Join(o.Blocks, ", "))
+	}
+
+	fmt.Printf("\nSeries: %d, cardinality outliers: %d\n", r.Series, len(r.Outliers))
+	for _, o := range r.Outliers {
+		name := o.Metric
+		if !o.Registered {
+			name += " (unregistered)"
+		}
+		if o.Label != "" {
+			fmt.Printf("  % -50s label %s has %d values across %d series\n", name, o.Label, o.Values, o.Series)
+		} else {
+			fmt.Printf("  % -50s %d series\n", name, o.Series)
+		}
+	}
+
+	if r.Healthy {
+		fmt.Println("\nStorage is healthy.")
+	}
+}
+
+func printRepairResult(res *store.RepairResult) {
+	fmt.Printf("\nRepair:\n")
+	for _, id := range res.Quarantined {
+		fmt.Printf("  Quarantined %s\n", id)
+	}
+	for _, sb := range res.Salvaged {
+		fmt.Printf("  Salvaged %d samples in %d series from %s into %s\n", sb.Samples, sb.Series, sb.From, sb.ULID)
+	}
+	fmt.Printf("  Storage opened and compacted: %d blocks\n", res.Blocks)
+}
@@ -2,6 +2,7 @@ package debug
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 
 	"github.com/spf13/cobra"
@@ -25,7 +26,7 @@ func NewDebugValidateCmd(opts *config.PowerwallOptions, logger *zap.Logger) *cob
 			var prettyJSON bytes.Buffer
 			for _, q := range queries.QueryList() {
 				cmd.Println(q)
-				debug := pwr.RunQuery(q, nil)
+				debug := pwr.RunQuery(context.Background(), q, nil)
 				if debug == nil {
 					logger.Info("Query returned no data", zap.String("query", q))
 					continue
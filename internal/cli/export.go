@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ygelfand/power-dash/internal/export"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+func newExportCmd(logger *zap.Logger) *cobra.Command {
+	var (
+		req                   export.Request
+		start, end, step, out string
+		server                string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export stored series as CSV, JSON Lines or Influx line protocol",
+		Long: `Export the series selected by one or more --match selectors. Times accept
+RFC3339, unix seconds or a duration before now (e.g. 7d, 2mo). Without --step,
+raw samples are exported; with --step they are aggregated with --function.
+A step of day, week, month or year follows the calendar in --timezone.`,
+		Example: `  power-dash export --match 'power_watts{site="solar"}' --start 30d --step 1h -o solar.csv
+  power-dash export --match battery_soe_percent --start 2024-01-01T00:00:00Z --format ndjson`,
+		Annotations:  map[string]string{noPasswordAnnotation: "true"},
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			now := time.Now()
+			if req.Start, err = parseExportTime(start, now); err != nil {
+				return fmt.Errorf("invalid --start: %w", err)
+			}
+			if req.End, err = parseExportTime(end, now); err != nil {
+				return fmt.Errorf("invalid --end: %w", err)
+			}
+			switch step {
+			case "":
+			case store.UnitDay, store.UnitWeek, store.UnitMonth, store.UnitYear:
+				req.Unit = step
+			default:
+				d, err := parseSince(step)
+				if err != nil {
+					return fmt.Errorf("invalid --step: %w", err)
+				}
+				req.Step = int64(d / time.Second)
+			}
+			if _, err := req.Parse(); err != nil {
+				return err
+			}
+
+			var w io.Writer = os.Stdout
+			if out != "" {
+				f, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+
+			if server != "" {
+				return fetchExport(w, server, req)
+			}
+			st, err := openLocalStore(logger)
+			if err != nil {
+				return err
+			}
+			defer st.Close()
+			return export.Write(context.Background(), w, st, req)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&req.Matchers, "match", "m", nil, "series selector, may be repeated (e.g. 'power_watts{site=\"solar\"}')")
+	cmd.Flags().StringVar(&start, "start", "24h", "start time")
+	cmd.Flags().StringVar(&end, "end", "", "end time (default now)")
+	cmd.Flags().StringVar(&step, "step", "", "aggregation step (e.g. 5m, 1h, day, month)")
+	cmd.Flags().StringVar(&req.Timezone, "timezone", "", "IANA timezone for step buckets (default the site's)")
+	cmd.Flags().StringVar(&req.Function, "function", "avg", "aggregation function: avg, sum, min, max, delta or integral")
+	cmd.Flags().StringVarP(&req.Format, "format", "f", export.FormatCSV, "output format: csv, ndjson or influx")
+	cmd.Flags().StringVarP(&out, "output", "o", "", "output file (default stdout)")
+	cmd.Flags().StringVar(&server, "server", "", "URL of a running power-dash instance to export from")
+	return cmd
+}
+
+func parseExportTime(s string, now time.Time) (int64, error) {
+	if s == "" {
+		return now.Unix(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Unix(), nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	d, err := parseSince(s)
+	if err != nil {
+		return 0, err
+	}
+	return now.Add(-d).Unix(), nil
+}
+
+func fetchExport(w io.Writer, server string, req export.Request) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimRight(server, "/") + "/api/v1/export"
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -71,6 +71,11 @@ func runImport(cmd *cobra.Command, args []string) {
 		DataPath:          dataPath,
 		Retention:         viper.GetString("storage.retention"),
 		PartitionDuration: viper.GetString("storage.partition"),
+		RetentionPolicies: viper.GetStringMapString("storage.retention-policies"),
+	}
+	policies, err := storageOpts.GetRetentionPolicies()
+	if err != nil {
+		log.Fatalf("Invalid storage retention policies: %v", err)
 	}
 
 	zapLogger, _ := zap.NewDevelopment()
@@ -78,6 +83,7 @@ func runImport(cmd *cobra.Command, args []string) {
 		DataPath:          storageOpts.DataPath,
 		Retention:         storageOpts.GetRetention(),
 		PartitionDuration: storageOpts.GetPartitionDuration(),
+		RetentionPolicies: policies,
 	}, zapLogger)
 	if err != nil {
 		log.Fatalf("Failed to open local storage: %v", err)
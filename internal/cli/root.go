@@ -21,6 +21,9 @@ var (
 
 var o = &config.PowerwallOptions{}
 
+// noPasswordAnnotation marks commands that never talk to the gateway.
+const noPasswordAnnotation = "power-dash/no-password"
+
 var logger, logLevel = utils.NewAtomicLogger()
 
 var rootCmd = &cobra.Command{
@@ -37,7 +40,7 @@ var rootCmd = &cobra.Command{
 		o.KeyPath = viper.GetString("key-path")
 		o.DIN = viper.GetString("din")
 
-		if o.Password == "" && cmd.Use != "version" {
+		if o.Password == "" && cmd.Use != "version" && cmd.Annotations[noPasswordAnnotation] == "" {
 			return fmt.Errorf("password is required (via flag, env POWER_DASH_PASSWORD, or config file)")
 		}
 		return nil
@@ -73,6 +76,9 @@ func init() {
 	rootCmd.AddCommand(newRunCmd(o))
 	rootCmd.AddCommand(newDebugCmd(o, logger))
 	rootCmd.AddCommand(newConnectCmd(o, logger))
+	rootCmd.AddCommand(newBackupCmd(logger))
+	rootCmd.AddCommand(newRestoreCmd(logger))
+	rootCmd.AddCommand(newExportCmd(logger))
 	rootCmd.AddCommand(versionCmd)
 	versionCmd.InheritedFlags().SetAnnotation("password", cobra.BashCompOneRequiredFlag, []string{"false"})
 }
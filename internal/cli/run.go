@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/ygelfand/power-dash/internal/collector"
 	"github.com/ygelfand/power-dash/internal/config"
 	"github.com/ygelfand/power-dash/internal/powerwall"
+	"github.com/ygelfand/power-dash/internal/remotewrite"
 	"github.com/ygelfand/power-dash/internal/store"
 	"github.com/ygelfand/power-dash/internal/utils"
 	"go.uber.org/zap"
@@ -58,15 +60,68 @@ func newRunCmd(opts *config.PowerwallOptions) *cobra.Command {
 				os.Exit(1)
 			}
 
-			st, err := store.NewStore(store.Config{
-				DataPath:          o.Storage.DataPath,
-				Retention:         o.Storage.GetRetention(),
-				PartitionDuration: o.Storage.GetPartitionDuration(),
-			}, logger)
+			policies, err := o.Storage.GetRetentionPolicies()
 			if err != nil {
-				logger.Error("Failed to initialize storage", zap.Error(err))
+				logger.Error("Invalid storage retention policies", zap.Error(err))
 				os.Exit(1)
 			}
+
+			var sinks []store.Sink
+			if rw := o.RemoteWrite; rw.URL != "" {
+				queuePath := rw.QueuePath
+				if queuePath == "" {
+					queuePath = filepath.Join(o.Storage.DataPath, "remote-write")
+				}
+				flushInterval, err := rw.GetFlushInterval()
+				if err != nil {
+					logger.Error("Invalid remote write settings", zap.Error(err))
+					os.Exit(1)
+				}
+				timeout, err := rw.GetTimeout()
+				if err != nil {
+					logger.Error("Invalid remote write settings", zap.Error(err))
+					os.Exit(1)
+				}
+				writer, err := remotewrite.New(remotewrite.Config{
+					URL:           rw.URL,
+					Username:      rw.Username,
+					Password:      rw.Password,
+					BearerToken:   rw.BearerToken,
+					Headers:       rw.Headers,
+					ExtraLabels:   rw.ExtraLabels,
+					QueueDir:      queuePath,
+					FlushInterval: flushInterval,
+					Timeout:       timeout,
+					MaxQueueFiles: rw.MaxQueueFiles,
+				}, logger)
+				if err != nil {
+					logger.Error("Failed to initialize remote write", zap.Error(err))
+					os.Exit(1)
+				}
+				writer.Start()
+				defer writer.Stop()
+				sinks = append(sinks, writer)
+			}
+
+			var st store.Storage
+			if o.Storage.Memory {
+				logger.Warn("Using in-memory storage; collected data is lost on exit")
+				st = store.NewMemStore(logger, sinks...)
+			} else {
+				tsdb, err := store.NewStore(store.Config{
+					DataPath:          o.Storage.DataPath,
+					Retention:         o.Storage.GetRetention(),
+					PartitionDuration: o.Storage.GetPartitionDuration(),
+					RetentionPolicies: policies,
+					Sinks:             sinks,
+				}, logger)
+				if err != nil {
+					logger.Error("Failed to initialize storage", zap.Error(err))
+					os.Exit(1)
+				}
+				tsdb.StartRollups(5 * time.Minute)
+				st = tsdb
+			}
 			defer st.Close()
 
 			var cm *collector.Manager
@@ -75,12 +130,25 @@ func newRunCmd(opts *config.PowerwallOptions) *cobra.Command {
 				if o.CollectionInterval > 0 {
 					collectionInterval = time.Duration(o.CollectionInterval) * time.Second
 				}
-				cm = collector.NewManager(st, collectionInterval, logger)
-				cm.Register(collector.NewDeviceCollector(pwr))
+				schedules, err := collector.ConfigSchedules(o.Collectors)
+				if err != nil {
+					logger.Error("Invalid collector schedules", zap.Error(err))
+					os.Exit(1)
+				}
+				cm = collector.NewManager(st, collectionInterval, schedules, logger)
+				cm.SetBreaker(pwr.Breaker())
+				cm.SetConcurrency(int(o.CollectorConcurrency))
+				cm.Register(collector.NewDeviceCollector(pwr, logger))
+				cm.Register(collector.NewComponentsCollector(pwr))
+				cm.Register(collector.NewWallboxCollector(pwr, logger))
+				cm.Register(collector.NewSelfTestCollector(pwr, logger))
+				cm.Register(collector.NewIEEE20305Collector(pwr, logger))
 				cm.Register(collector.NewGridCollector(pwr))
 				cm.Register(collector.NewAggregatesCollector(pwr))
 				cm.Register(collector.NewSoeCollector(pwr))
-				cm.Register(collector.NewConfigCollector(pwr, logger))
+				cfgCollector := collector.NewConfigCollector(pwr, logger)
+				cm.Register(cfgCollector)
+				cm.Register(collector.NewPriceCollector(cfgCollector))
 				cm.Start()
 				defer cm.Stop()
 			} else {
@@ -124,6 +192,7 @@ func newRunCmd(opts *config.PowerwallOptions) *cobra.Command {
 	defaults := config.NewDefaultProxyOptions()
 	runCmd.Flags().StringVarP(&o.ListenOn, "listen", "l", defaults.ListenOn, "host:port to listen on")
 	runCmd.Flags().Uint32Var(&o.CollectionInterval, "collection-interval", defaults.CollectionInterval, "data collection frequency in seconds")
+	runCmd.Flags().Uint32Var(&o.CollectorConcurrency, "collector-concurrency", collector.DefaultConcurrency, "number of collectors that may run at once")
 	runCmd.Flags().BoolVar(&o.AutoRefresh, "auto-refresh", defaults.AutoRefresh, "enable auto-refresh on startup")
 	runCmd.Flags().StringVar(&o.DefaultTheme, "default-theme", defaults.DefaultTheme, "default UI theme (light, dark, auto)")
 	runCmd.Flags().StringVar(&o.LogLevel, "log-level", defaults.LogLevel, "log level (debug, info, warn, error)")
@@ -131,11 +200,13 @@ func newRunCmd(opts *config.PowerwallOptions) *cobra.Command {
 	runCmd.Flags().StringVar(&o.Storage.DataPath, "storage-path", defaults.Storage.DataPath, "path to storage directory")
 	runCmd.Flags().StringVar(&o.Storage.Retention, "storage-retention", defaults.Storage.Retention, "data retention period (e.g. 7d, 168h, 0s for infinity)")
 	runCmd.Flags().StringVar(&o.Storage.PartitionDuration, "storage-partition", defaults.Storage.PartitionDuration, "partition duration (e.g. 2h)")
+	runCmd.Flags().BoolVar(&o.Storage.Memory, "storage-memory", false, "keep data in memory only (for demos and tests)")
 	runCmd.Flags().StringVar(&o.LabelConfigPath, "label-config", "", "path to label configuration file")
 
 	viper.BindPFlag("storage.path", runCmd.Flags().Lookup("storage-path"))
 	viper.BindPFlag("storage.retention", runCmd.Flags().Lookup("storage-retention"))
 	viper.BindPFlag("storage.partition", runCmd.Flags().Lookup("storage-partition"))
+	viper.BindPFlag("storage.memory", runCmd.Flags().Lookup("storage-memory"))
 	viper.BindPFlag("label-config", runCmd.Flags().Lookup("label-config"))
 
 	return runCmd
@@ -24,8 +24,8 @@ func (c *AggregatesCollector) Name() string {
 	return "AggregatesCollector"
 }
 
-func (c *AggregatesCollector) Collect(ctx context.Context, s *store.Store) (string, error) {
-	data, err := c.pwr.MakeAPIRequest("GET", "meters/aggregates", nil)
+func (c *AggregatesCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	data, err := c.pwr.MakeAPIRequest(ctx, "GET", "meters/aggregates", nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to get aggregates: %w", err)
 	}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+// alertEpisodeGap is how long an alert may go unobserved, e.g. while
+// power-dash is down, before it counts as a new episode.
+const alertEpisodeGap = 10 * time.Minute
+
+type openAlert struct {
+	source, name string
+	lastSeen     time.Time
+}
+
+// alertTracker turns the alerts active on each collection into episodes in
+// the store, opening one when an alert appears and closing it when it clears.
+// Each tracker owns the alert sources its collector reports.
+type alertTracker struct {
+	logger *zap.Logger
+	owns   func(source string) bool
+	mu     sync.Mutex
+	open   map[string]*openAlert
+}
+
+func newAlertTracker(logger *zap.Logger, owns func(source string) bool) *alertTracker {
+	return &alertTracker{logger: logger, owns: owns}
+}
+
+// load picks up episodes left open by a previous run. It must run before the
+// current alerts are inserted so their last sample predates this cycle.
+func (t *alertTracker) load(s store.Storage) {
+	t.open = make(map[string]*openAlert)
+	for _, ep := range s.AlertEpisodes(store.AlertEpisodeFilter{ActiveOnly: true}) {
+		if !t.owns(ep.Source) {
+			continue
+		}
+		lastSeen := time.Unix(ep.Start, 0)
+		p, err := s.GetLastPoint(metrics.ActiveAlert, map[string]string{"source": ep.Source, "name": ep.Name})
+		if err == nil && p != nil && p.Timestamp > ep.Start {
+			lastSeen = time.Unix(p.Timestamp, 0)
+		}
+		t.open[ep.Source+"/"+ep.Name] = &openAlert{source: ep.Source, name: ep.Name, lastSeen: lastSeen}
+	}
+}
+
+func (t *alertTracker) update(s store.Storage, now time.Time, alerts []store.Alert) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.open == nil {
+		t.load(s)
+	}
+
+	active := make(map[string]bool, len(alerts))
+	for _, a := range alerts {
+		key := a.Source + "/" + a.Name
+		if active[key] {
+			continue
+		}
+		active[key] = true
+		if o, ok := t.open[key]; ok {
+			if now.Sub(o.lastSeen) <= alertEpisodeGap {
+				o.lastSeen = now
+				continue
+			}
+			// Nobody watched in between, so the old episode ends when it was last seen.
+			t.close(s, o, o.lastSeen)
+		}
+		if err := s.OpenAlertEpisode(a.Source, a.Name, now); err != nil {
+			t.logger.Warn("Failed to record alert episode", zap.String("source", a.Source), zap.String("alert", a.Name), zap.Error(err))
+			continue
+		}
+		t.open[key] = &openAlert{source: a.Source, name: a.Name, lastSeen: now}
+		t.logger.Info("Alert raised", zap.String("source", a.Source), zap.String("alert", a.Name))
+	}
+
+	for key, o := range t.open {
+		if active[key] {
+			continue
+		}
+		end := now
+		if now.Sub(o.lastSeen) > alertEpisodeGap {
+			end = o.lastSeen
+		}
+		t.close(s, o, end)
+	}
+}
+
+func (t *alertTracker) close(s store.Storage, o *openAlert, end time.Time) {
+	delete(t.open, o.source+"/"+o.name)
+	if err := s.CloseAlertEpisode(o.source, o.name, end); err != nil {
+		t.logger.Warn("Failed to close alert episode", zap.String("source", o.source), zap.String("alert", o.name), zap.Error(err))
+		return
+	}
+	t.logger.Info("Alert cleared", zap.String("source", o.source), zap.String("alert", o.name))
+}
@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/powerwall"
+	"github.com/ygelfand/power-dash/internal/store"
+	"github.com/ygelfand/power-dash/internal/utils"
+)
+
+// pchStrings are the PV inputs of a Powerwall 3.
+var pchStrings = []string{"A", "B", "C", "D", "E", "F"}
+
+// pvacStrings are the PV inputs DeviceCollector already stores from the PVAC
+// under the same index and string labels.
+var pvacStrings = map[string]bool{"A": true, "B": true, "C": true, "D": true}
+
+// ComponentsCollector stores the Powerwall 3 signals of ComponentsQuery: PV
+// strings E and F, which the PVAC does not report, AC output and battery DC
+// power of every PCH, and the DC-DC, PV and rapid-shutdown states. It stores
+// nothing on systems without a PW3.
+type ComponentsCollector struct {
+	pwr *powerwall.PowerwallGateway
+}
+
+func NewComponentsCollector(pwr *powerwall.PowerwallGateway) *ComponentsCollector {
+	return &ComponentsCollector{pwr: pwr}
+}
+
+func (c *ComponentsCollector) Name() string {
+	return "ComponentsCollector"
+}
+
+func (c *ComponentsCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	comps, err := c.pwr.FetchComponents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch components: %w", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	ts := now.Unix()
+	states := 0
+	state := func(component string, idx int, comp powerwall.Component, signal string) {
+		v := comp.Text(signal)
+		if v == "" {
+			return
+		}
+		lbls := []store.Label{
+			{Name: "component", Value: component},
+			{Name: "index", Value: fmt.Sprint(idx)},
+			{Name: "signal", Value: signal},
+			{Name: "state", Value: v},
+		}
+		if s.Insert(metrics.ComponentState, lbls, 1, ts) == nil {
+			states++
+		}
+	}
+
+	var solar []store.SolarReading
+	var inverters []store.InverterReading
+	for i, pch := range comps.Components.Pch {
+		for _, id := range pchStrings {
+			state("pch", i, pch, "PCH_PvState_"+id)
+			if pvacStrings[id] {
+				continue
+			}
+			voltage, current := pch.Value("PCH_PvVoltage"+id), pch.Value("PCH_PvCurrent"+id)
+			if voltage == nil && current == nil {
+				continue
+			}
+			r := store.SolarReading{Timestamp: now, InverterIndex: i, StringID: id, Voltage: voltage, Current: current}
+			if voltage != nil && current != nil {
+				r.Power = utils.ToPtr(*voltage * *current)
+			}
+			solar = append(solar, r)
+		}
+
+		inverters = append(inverters, store.InverterReading{
+			Timestamp:     now,
+			InverterIndex: i,
+			Type:          "pw3",
+			Power:         pch.Value("PCH_AcRealPowerAB"),
+			Frequency:     pch.Value("PCH_AcFrequency"),
+			Voltage1:      pch.Value("PCH_AcVoltageAN"),
+			Voltage2:      pch.Value("PCH_AcVoltageBN"),
+		})
+
+		if p := pch.Value("PCH_BatteryPower"); p != nil {
+			_ = s.Insert(metrics.BatteryDCPowerWatts, []store.Label{{Name: "index", Value: fmt.Sprint(i)}}, *p, ts)
+		}
+
+		state("pch", i, pch, "PCH_State")
+		state("pch", i, pch, "PCH_DcdcState_A")
+		state("pch", i, pch, "PCH_DcdcState_B")
+	}
+	_ = s.InsertSolarReadings(solar)
+	_ = s.InsertInverterReadings(inverters)
+
+	for i, pws := range comps.Components.Pws {
+		state("pws", i, pws, "PWS_RSD_State")
+	}
+
+	return fmt.Sprintf("Processed %d PW3 units, %d solar strings, %d states", len(comps.Components.Pch), len(solar), states), nil
+}
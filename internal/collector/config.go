@@ -3,6 +3,7 @@ package collector
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ygelfand/power-dash/internal/powerwall"
@@ -10,9 +11,11 @@ import (
 	"go.uber.org/zap"
 )
 
+// ConfigCollector refreshes the gateway config, hourly by default, and keeps
+// it for PriceCollector.
 type ConfigCollector struct {
 	pwr           *powerwall.PowerwallGateway
-	lastFetch     time.Time
+	mu            sync.RWMutex
 	currentConfig *powerwall.ConfigResponse
 	logger        *zap.Logger
 }
@@ -28,37 +31,35 @@ func (c *ConfigCollector) Name() string {
 	return "ConfigCollector"
 }
 
-func (c *ConfigCollector) Collect(ctx context.Context, s *store.Store) (string, error) {
-	now := time.Now()
-
-	// Fetch config if needed (every hour)
-	if c.currentConfig == nil || time.Since(c.lastFetch) > 1*time.Hour {
-		cfg, err := c.pwr.FetchConfig()
-		if err != nil {
-			return "", fmt.Errorf("failed to fetch config: %w", err)
+func (c *ConfigCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	cfg, err := c.pwr.FetchConfig(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch config: %w", err)
+	}
+	c.mu.Lock()
+	c.currentConfig = cfg
+	c.mu.Unlock()
+
+	c.logger.Info("Updated system config", zap.String("vin", cfg.Vin))
+	if tz := cfg.SiteInfo.Timezone; tz != "" {
+		if loc, err := time.LoadLocation(tz); err != nil {
+			c.logger.Warn("Ignoring unknown site timezone", zap.String("timezone", tz), zap.Error(err))
+		} else {
+			s.SetLocation(loc)
 		}
-		c.currentConfig = cfg
-		c.lastFetch = now
-		c.logger.Info("Updated system config", zap.String("vin", cfg.Vin))
 	}
+	return fmt.Sprintf("Updated config for %s", cfg.Vin), nil
+}
 
+// currentRate returns the energy rate and tariff period at t. It returns an
+// empty period if no config or tariff is loaded yet, or no rate applies.
+func (c *ConfigCollector) currentRate(t time.Time) (float64, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	if c.currentConfig == nil || c.currentConfig.SiteInfo.TariffContent.Code == "" {
-		return "No tariff config available", nil
-	}
-
-	rate, periodName := c.getCurrentRate(now, c.currentConfig)
-	if periodName == "" {
-		return "No active rate found", nil
+		return 0, ""
 	}
-
-	// Insert Price Metric
-	// Store expects seconds for Insert()
-	err := s.Insert("energy_price_usd", []store.Label{{Name: "period", Value: periodName}}, rate, now.Unix())
-	if err != nil {
-		return "", fmt.Errorf("failed to insert price metric: %w", err)
-	}
-
-	return fmt.Sprintf("Recorded rate $%.4f (%s)", rate, periodName), nil
+	return c.getCurrentRate(t, c.currentConfig)
 }
 
 func (c *ConfigCollector) getCurrentRate(t time.Time, cfg *powerwall.ConfigResponse) (float64, string) {
@@ -9,22 +9,26 @@ import (
 	"github.com/ygelfand/power-dash/internal/powerwall"
 	"github.com/ygelfand/power-dash/internal/store"
 	"github.com/ygelfand/power-dash/internal/utils"
+	"go.uber.org/zap"
 )
 
 type DeviceCollector struct {
-	pwr *powerwall.PowerwallGateway
+	pwr    *powerwall.PowerwallGateway
+	alerts *alertTracker
 }
 
-func NewDeviceCollector(pwr *powerwall.PowerwallGateway) *DeviceCollector {
-	return &DeviceCollector{pwr: pwr}
+func NewDeviceCollector(pwr *powerwall.PowerwallGateway, logger *zap.Logger) *DeviceCollector {
+	return &DeviceCollector{pwr: pwr, alerts: newAlertTracker(logger, func(source string) bool {
+		return !strings.HasPrefix(source, wallConnectorSource)
+	})}
 }
 
 func (c *DeviceCollector) Name() string {
 	return "DeviceCollector"
 }
 
-func (c *DeviceCollector) Collect(ctx context.Context, s *store.Store) (string, error) {
-	ctrl, err := c.pwr.FetchController()
+func (c *DeviceCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	ctrl, err := c.pwr.FetchController(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch controller: %w", err)
 	}
@@ -183,6 +187,7 @@ func (c *DeviceCollector) Collect(ctx context.Context, s *store.Store) (string,
 		}
 		addAlerts(fmt.Sprintf("msa_%d", i), names)
 	}
+	c.alerts.update(s, now, alerts)
 	_ = s.InsertAlerts(alerts)
 
 	var neurioMeters []store.MeterReading
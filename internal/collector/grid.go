@@ -23,8 +23,8 @@ func (c *GridCollector) Name() string {
 	return "GridCollector"
 }
 
-func (c *GridCollector) Collect(ctx context.Context, s *store.Store) (string, error) {
-	status, err := c.pwr.MakeAPIRequest("GET", "system_status/grid_status", nil)
+func (c *GridCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	status, err := c.pwr.MakeAPIRequest(ctx, "GET", "system_status/grid_status", nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to get grid status: %w", err)
 	}
@@ -0,0 +1,111 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// healthWindow is the number of recent runs the success rate covers.
+const healthWindow = 100
+
+// Health summarizes the recent runs of one collector.
+type Health struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Interval string `json:"interval"`
+	Runs     int64  `json:"runs"`
+	Failures int64  `json:"failures"`
+	// SuccessRate is the share of successful runs among the last
+	// healthWindow, or 0 before the first run.
+	SuccessRate         float64    `json:"success_rate"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastRun             *time.Time `json:"last_run,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	// FailingSince is when the current run of consecutive failures began.
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	Healthy      bool       `json:"healthy"`
+}
+
+// health tracks run outcomes of a collector.
+type health struct {
+	mu           sync.Mutex
+	outcomes     [healthWindow]bool
+	n            int
+	runs         int64
+	failures     int64
+	consecutive  int
+	lastRun      time.Time
+	lastSuccess  time.Time
+	lastError    string
+	lastErrorAt  time.Time
+	failingSince time.Time
+	lastDuration time.Duration
+}
+
+// record adds a run and returns the time of the last failed run, zero if
+// none has failed yet.
+func (h *health) record(at time.Time, elapsed time.Duration, err error) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.outcomes[h.runs%healthWindow] = err == nil
+	h.n = min(h.n+1, healthWindow)
+	h.runs++
+	h.lastRun = at
+	h.lastDuration = elapsed
+	if err == nil {
+		h.lastSuccess = at
+		h.consecutive = 0
+		h.failingSince = time.Time{}
+		return h.lastErrorAt
+	}
+	h.failures++
+	h.lastError = err.Error()
+	h.lastErrorAt = at
+	if h.consecutive == 0 {
+		h.failingSince = at
+	}
+	h.consecutive++
+	return h.lastErrorAt
+}
+
+func (h *health) snapshot(name string, sched Schedule) Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	out := Health{
+		Name:                name,
+		Enabled:             !sched.Disabled,
+		Interval:            sched.Interval.String(),
+		Runs:                h.runs,
+		Failures:            h.failures,
+		ConsecutiveFailures: h.consecutive,
+		LastError:           h.lastError,
+		Healthy:             h.consecutive == 0,
+	}
+	if h.n > 0 {
+		ok := 0
+		for _, o := range h.outcomes[:h.n] {
+			if o {
+				ok++
+			}
+		}
+		out.SuccessRate = float64(ok) / float64(h.n)
+		out.LastDuration = h.lastDuration.String()
+	}
+	out.LastRun = timePtr(h.lastRun)
+	out.LastSuccess = timePtr(h.lastSuccess)
+	out.LastErrorAt = timePtr(h.lastErrorAt)
+	out.FailingSince = timePtr(h.failingSince)
+	return out
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,132 @@
+package collector
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/powerwall"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+// Kinds of utility controls.
+const (
+	utilityControlDefault = "default"
+	utilityControlActive  = "active"
+)
+
+// IEEE20305Collector stores the IEEE 2030.5 DER controls the utility has set
+// on the gateway and how often it polls the utility's resources, and records
+// an event with the control's mRID whenever one is issued, changed or
+// withdrawn. Changes are detected against the last stored events, so they
+// survive restarts.
+type IEEE20305Collector struct {
+	pwr    *powerwall.PowerwallGateway
+	logger *zap.Logger
+}
+
+func NewIEEE20305Collector(pwr *powerwall.PowerwallGateway, logger *zap.Logger) *IEEE20305Collector {
+	return &IEEE20305Collector{pwr: pwr, logger: logger}
+}
+
+func (c *IEEE20305Collector) Name() string {
+	return "IEEE20305Collector"
+}
+
+func (c *IEEE20305Collector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	resp, err := c.pwr.FetchIEEE20305(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch IEEE 2030.5 state: %w", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	ts := now.Unix()
+	seen := make(map[string]store.UtilityControlEvent)
+	observe := func(control string, idx int, der powerwall.DERControl) {
+		ctl := utilityControl(der)
+		ev := store.UtilityControlEvent{Time: ts, Control: control, Index: idx, MRID: ctl.MRID, Current: &ctl}
+		seen[ev.Key()] = ev
+
+		lbls := []store.Label{{Name: "control", Value: control}, {Name: "index", Value: fmt.Sprint(idx)}}
+		limit := func(mode string, v *float64) {
+			if v != nil {
+				_ = s.Insert(metrics.UtilityControlLimitW, append(lbls, store.Label{Name: "mode", Value: mode}), *v, ts)
+			}
+		}
+		limit("max_lim", ctl.MaxLimW)
+		limit("imp_lim", ctl.ImpLimW)
+		limit("exp_lim", ctl.ExpLimW)
+		limit("gen_lim", ctl.GenLimW)
+		limit("load_lim", ctl.LoadLimW)
+		if ctl.Energize != nil {
+			v := 0.0
+			if *ctl.Energize {
+				v = 1
+			}
+			_ = s.Insert(metrics.UtilityControlEnergize, lbls, v, ts)
+		}
+	}
+	controls := resp.IEEE20305.Controls
+	if controls.DefaultControl != nil {
+		observe(utilityControlDefault, 0, *controls.DefaultControl)
+	}
+	for i, der := range controls.ActiveControls {
+		observe(utilityControlActive, i, der)
+	}
+
+	for _, res := range resp.IEEE20305.PolledResources {
+		lbls := []store.Label{{Name: "resource", Value: cmp.Or(res.Name, res.URL)}}
+		if res.PollRateSeconds != nil {
+			_ = s.Insert(metrics.UtilityPollRateSeconds, lbls, *res.PollRateSeconds, ts)
+		}
+		if res.LastPolledTimestamp != 0 {
+			_ = s.Insert(metrics.UtilityPollAgeSeconds, lbls, float64(ts-int64(res.LastPolledTimestamp)), ts)
+		}
+	}
+
+	prev := s.CurrentUtilityControls()
+	var events []store.UtilityControlEvent
+	for key, ev := range seen {
+		if p, ok := prev[key]; ok {
+			if p.Current.Equal(ev.Current) {
+				continue
+			}
+			ev.Previous = p.Current
+		}
+		events = append(events, ev)
+	}
+	for key, p := range prev {
+		if _, ok := seen[key]; !ok {
+			events = append(events, store.UtilityControlEvent{Time: ts, Control: p.Control, Index: p.Index, MRID: p.MRID, Previous: p.Current})
+		}
+	}
+	for _, ev := range events {
+		if err := s.AddUtilityControlEvent(ev); err != nil {
+			return "", fmt.Errorf("failed to store utility control event: %w", err)
+		}
+		c.logger.Info("Utility control changed",
+			zap.String("control", ev.Control),
+			zap.Int("index", ev.Index),
+			zap.String("mrid", ev.MRID),
+			zap.Bool("withdrawn", ev.Current == nil),
+		)
+	}
+
+	return fmt.Sprintf("Processed %d utility controls, %d changed", len(seen), len(events)), nil
+}
+
+func utilityControl(der powerwall.DERControl) store.UtilityControl {
+	return store.UtilityControl{
+		MRID:     der.MRID,
+		SetGradW: der.SetGradW,
+		Energize: der.OpModEnergize,
+		MaxLimW:  der.OpModMaxLimW,
+		ImpLimW:  der.OpModImpLimW,
+		ExpLimW:  der.OpModExpLimW,
+		GenLimW:  der.OpModGenLimW,
+		LoadLimW: der.OpModLoadLimW,
+	}
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+var (
+	meterMetrics = []string{
+		metrics.PowerWatts, metrics.PowerReactiveVAR, metrics.PowerApparentVA, metrics.VoltageVolts,
+		metrics.CurrentAmps, metrics.FrequencyHertz, metrics.EnergyWh,
+	}
+	inverterMetrics = []string{metrics.InverterPowerWatts, metrics.InverterFrequencyHertz, metrics.InverterVoltageVolts}
+	solarMetrics    = []string{metrics.SolarVoltageVolts, metrics.SolarCurrentAmps, metrics.SolarPowerWatts}
+)
+
+// collectorMetrics lists the metrics each collector writes, by ScheduleKey.
+var collectorMetrics = map[string][]string{
+	"device": concat(meterMetrics, inverterMetrics, solarMetrics, []string{
+		metrics.BatteryEnergyWh, metrics.BatterySOEPercent, metrics.TemperatureCelsius, metrics.FanSpeedRPM,
+	}),
+	"components": concat(inverterMetrics, solarMetrics, []string{metrics.BatteryDCPowerWatts, metrics.ComponentState}),
+	"wallbox": {
+		metrics.EVChargingPowerWatts, metrics.EVSessionEnergyWh, metrics.EVVehicleConnectedBool, metrics.PowerWatts,
+	},
+	"grid":       {metrics.GridStatusCode, metrics.GridServicesActiveBool},
+	"aggregates": meterMetrics,
+	"soe":        {metrics.BatterySOEPercent, metrics.BatteryEnergyWh},
+	"price":      {metrics.EnergyPriceUSD},
+	"ieee20305":  {metrics.UtilityControlLimitW, metrics.UtilityControlEnergize, metrics.UtilityPollRateSeconds, metrics.UtilityPollAgeSeconds},
+	"selftest":   nil,
+	"config":     nil,
+}
+
+// runMetrics are written by the manager after each run of any collector.
+var runMetrics = []string{metrics.CollectorDurationSeconds, metrics.CollectorSuccess, metrics.CollectorLastError}
+
+// MetricIntervals returns how often each collected metric is written when
+// collectors run every interval unless schedules, keyed by ScheduleKey, or
+// DefaultSchedules say otherwise. Disabled collectors are left out.
+func MetricIntervals(interval time.Duration, schedules map[string]Schedule) map[string]store.MetricInterval {
+	out := make(map[string]store.MetricInterval)
+	add := func(metric string, every time.Duration) {
+		mi, ok := out[metric]
+		if !ok {
+			out[metric] = store.MetricInterval{Min: every, Max: every}
+			return
+		}
+		out[metric] = store.MetricInterval{Min: min(mi.Min, every), Max: max(mi.Max, every)}
+	}
+	for key, names := range collectorMetrics {
+		sched := resolveSchedule(key, interval, schedules)
+		if sched.Disabled {
+			continue
+		}
+		for _, name := range names {
+			add(name, sched.Interval)
+		}
+		for _, name := range runMetrics {
+			add(name, sched.Interval)
+		}
+	}
+	return out
+}
+
+func concat(lists ...[]string) []string {
+	var out []string
+	for _, l := range lists {
+		out = append(out, l...)
+	}
+	return out
+}
@@ -2,66 +2,229 @@ package collector
 
 import (
 	"context"
+	"math/rand/v2"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/powerwall"
 	"github.com/ygelfand/power-dash/internal/store"
 	"go.uber.org/zap"
 )
 
+// collectTimeout bounds a single collector run unless its schedule sets a
+// Timeout.
+const collectTimeout = 20 * time.Second
+
+// DefaultConcurrency is the number of collectors allowed to run at once.
+const DefaultConcurrency = 3
+
 type Manager struct {
-	store        *store.Store
-	collectors   []Collector
-	interval     time.Duration
-	logger       *zap.Logger
-	stopCh       chan struct{}
-	isCollecting atomic.Bool
+	store      store.Storage
+	collectors []*scheduled
+	interval   time.Duration
+	schedules  map[string]Schedule
+	logger     *zap.Logger
+	breaker    *powerwall.Breaker
+	// slots bounds the number of collectors running at once.
+	slots  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	// lastRun is the unix time in nanoseconds of the last scheduled run since
+	// the previous collection mark, or zero.
+	lastRun atomic.Int64
+	metrics managerMetrics
+}
+
+// scheduled is a registered collector with its resolved schedule.
+type scheduled struct {
+	Collector
+	schedule Schedule
+	// mu serializes runs of the collector.
+	mu     sync.Mutex
+	health health
 }
 
-func NewManager(store *store.Store, interval time.Duration, logger *zap.Logger) *Manager {
+type managerMetrics struct {
+	runs        *prometheus.CounterVec
+	duration    *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
+	cycles      prometheus.Counter
+}
+
+func newManagerMetrics() managerMetrics {
+	return managerMetrics{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "power_dash_collector_runs_total",
+			Help: "Collector runs by result.",
+		}, []string{"collector", "result"}),
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "power_dash_collector_duration_seconds",
+			Help: "Duration of the last run of each collector.",
+		}, []string{"collector"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "power_dash_collector_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful run of each collector.",
+		}, []string{"collector"}),
+		cycles: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "power_dash_collection_cycles_total",
+			Help: "Collection marks written, one per interval in which collectors ran.",
+		}),
+	}
+}
+
+// NewManager returns a manager that runs collectors every interval unless
+// schedules, keyed by ScheduleKey, or DefaultSchedules say otherwise.
+func NewManager(store store.Storage, interval time.Duration, schedules map[string]Schedule, logger *zap.Logger) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Manager{
-		store:    store,
-		interval: interval,
-		logger:   logger,
-		stopCh:   make(chan struct{}),
+		store:     store,
+		interval:  interval,
+		schedules: schedules,
+		logger:    logger,
+		slots:     make(chan struct{}, DefaultConcurrency),
+		ctx:       ctx,
+		cancel:    cancel,
+		metrics:   newManagerMetrics(),
+	}
+}
+
+// SetConcurrency sets how many collectors may run at once. It must be called
+// before Start.
+func (m *Manager) SetConcurrency(n int) {
+	if n > 0 {
+		m.slots = make(chan struct{}, n)
 	}
 }
 
+// Metrics returns the collectors describing the manager's own activity.
+func (m *Manager) Metrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.metrics.runs,
+		m.metrics.duration,
+		m.metrics.lastSuccess,
+		m.metrics.cycles,
+	}
+}
+
+// SetBreaker makes scheduled runs wait while b reports the gateway down, and
+// records its state as gateway_up.
+func (m *Manager) SetBreaker(b *powerwall.Breaker) {
+	m.breaker = b
+}
+
 func (m *Manager) Register(c Collector) {
-	m.collectors = append(m.collectors, c)
+	sched := resolveSchedule(ScheduleKey(c.Name()), m.interval, m.schedules)
+	m.collectors = append(m.collectors, &scheduled{Collector: c, schedule: sched})
 }
 
+// Start runs each enabled collector on its own ticker, and writes a
+// collection mark every interval in which any of them ran.
 func (m *Manager) Start() {
-	delay := m.getStartupDelay()
-
-	go func() {
-		if delay > 0 {
-			m.logger.Info("Delaying first collection based on last poll", zap.Duration("delay", delay))
-			select {
-			case <-time.After(delay):
-			case <-m.stopCh:
-				return
-			}
+	known := make(map[string]bool, len(m.collectors))
+	for _, sc := range m.collectors {
+		known[ScheduleKey(sc.Name())] = true
+	}
+	for key := range m.schedules {
+		if !known[key] {
+			m.logger.Warn("Ignoring schedule for unknown collector", zap.String("collector", key))
+		}
+	}
+
+	sinceLast := m.sinceLastMark()
+	for _, sc := range m.collectors {
+		if sc.schedule.Disabled {
+			m.logger.Info("Collector is disabled", zap.String("collector", sc.Name()))
+			continue
 		}
+		m.logger.Info("Scheduling collector",
+			zap.String("collector", sc.Name()),
+			zap.Duration("interval", sc.schedule.Interval),
+			zap.Duration("jitter", sc.schedule.Jitter),
+			zap.Duration("timeout", sc.schedule.Timeout),
+		)
+		go m.loop(sc, startupDelay(min(sc.schedule.Interval, m.interval), sinceLast))
+	}
+	go m.markLoop(startupDelay(m.interval, sinceLast))
+}
 
-		// Initial run or immediate run if delay was 0
-		m.runCollection()
+// loop runs sc on its schedule until the manager stops.
+func (m *Manager) loop(sc *scheduled, delay time.Duration) {
+	if delay > 0 {
+		m.logger.Info("Delaying first collection based on last poll", zap.String("collector", sc.Name()), zap.Duration("delay", delay))
+	}
+	if !m.sleep(delay + sc.jitter()) {
+		return
+	}
+	m.runScheduled(sc)
 
-		ticker := time.NewTicker(m.interval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				m.runCollection()
-			case <-m.stopCh:
+	ticker := time.NewTicker(sc.schedule.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !m.sleep(sc.jitter()) {
 				return
 			}
+			m.runScheduled(sc)
+		case <-m.ctx.Done():
+			return
 		}
-	}()
+	}
+}
+
+func (m *Manager) markLoop(delay time.Duration) {
+	if !m.sleep(delay) {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.recordGatewayUp()
+			if m.lastRun.Swap(0) == 0 {
+				continue
+			}
+			_ = m.store.InsertCollectionMark(time.Now())
+			m.metrics.cycles.Inc()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// sleep waits for d and reports whether the manager is still running.
+func (m *Manager) sleep(d time.Duration) bool {
+	if d <= 0 {
+		select {
+		case <-m.ctx.Done():
+			return false
+		default:
+			return true
+		}
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+func (sc *scheduled) jitter() time.Duration {
+	if sc.schedule.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(sc.schedule.Jitter)
 }
 
 func (m *Manager) Stop() {
-	close(m.stopCh)
+	m.cancel()
 }
 
 type CollectionResult struct {
@@ -80,87 +243,173 @@ type RunReport struct {
 
 func (m *Manager) ForceRun(ctx context.Context) RunReport {
 	start := time.Now()
-	report := RunReport{
-		Timestamp: start,
-		Results:   make([]CollectionResult, 0, len(m.collectors)),
-	}
+	report := RunReport{Timestamp: start}
 
-	for _, c := range m.collectors {
-		res := m.runOne(ctx, c)
-		report.Results = append(report.Results, res)
+	var enabled []*scheduled
+	for _, sc := range m.collectors {
+		if !sc.schedule.Disabled {
+			enabled = append(enabled, sc)
+		}
+	}
+	report.Results = make([]CollectionResult, len(enabled))
+	var wg sync.WaitGroup
+	for i, sc := range enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Results[i] = m.runOne(ctx, sc)
+		}()
 	}
+	wg.Wait()
 
 	report.Duration = time.Since(start).String()
 	return report
 }
 
 func (m *Manager) ForceRunOne(ctx context.Context, name string) *CollectionResult {
-	for _, c := range m.collectors {
-		if c.Name() == name {
-			res := m.runOne(ctx, c)
+	for _, sc := range m.collectors {
+		if sc.Name() == name {
+			res := m.runOne(ctx, sc)
 			return &res
 		}
 	}
 	return nil
 }
 
-func (m *Manager) runOne(ctx context.Context, c Collector) CollectionResult {
+func (m *Manager) runOne(ctx context.Context, sc *scheduled) CollectionResult {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return m.collect(ctx, sc)
+}
+
+// recordGatewayUp stores whether the gateway link is up, so gaps during a
+// gateway outage can be told apart from grid outages.
+func (m *Manager) recordGatewayUp() {
+	if m.breaker == nil {
+		return
+	}
+	up := 0.0
+	if m.breaker.Up() {
+		up = 1
+	}
+	if err := m.store.Insert(metrics.GatewayUp, nil, up, time.Now().Unix()); err != nil {
+		m.logger.Warn("Failed to record gateway state", zap.Error(err))
+	}
+}
+
+// runScheduled runs sc unless its previous run is still in progress or the
+// gateway breaker is open.
+func (m *Manager) runScheduled(sc *scheduled) {
+	if !m.breaker.Ready() {
+		m.logger.Debug("Gateway unavailable, skipping", zap.String("collector", sc.Name()))
+		return
+	}
+	if !sc.mu.TryLock() {
+		m.logger.Warn("Previous run still in progress, skipping", zap.String("collector", sc.Name()))
+		return
+	}
+	defer sc.mu.Unlock()
+
+	m.logger.Debug("Running collector", zap.String("collector", sc.Name()))
+	res := m.collect(m.ctx, sc)
+	if m.ctx.Err() != nil {
+		return
+	}
+	if !res.Success {
+		m.logger.Error("Error collecting metrics", zap.String("error", res.Error), zap.String("collector", sc.Name()))
+	}
+	m.lastRun.Store(time.Now().UnixNano())
+}
+
+// collect waits for a free slot and runs sc once under its timeout; sc.mu
+// must be held.
+func (m *Manager) collect(ctx context.Context, c *scheduled) CollectionResult {
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		return CollectionResult{Name: c.Name(), Error: ctx.Err().Error(), Duration: "0s"}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.schedule.Timeout)
+	defer cancel()
+
 	cStart := time.Now()
 	msg, err := c.Collect(ctx, m.store)
+	elapsed := time.Since(cStart)
 	res := CollectionResult{
 		Name:     c.Name(),
 		Success:  err == nil,
 		Message:  msg,
-		Duration: time.Since(cStart).String(),
+		Duration: elapsed.String(),
 	}
+
+	m.metrics.duration.WithLabelValues(c.Name()).Set(elapsed.Seconds())
 	if err != nil {
 		res.Error = err.Error()
+		m.metrics.runs.WithLabelValues(c.Name(), "error").Inc()
+	} else {
+		m.metrics.runs.WithLabelValues(c.Name(), "success").Inc()
+		m.metrics.lastSuccess.WithLabelValues(c.Name()).Set(float64(time.Now().Unix()))
 	}
+	lastError := c.health.record(cStart, elapsed, err)
+	m.recordRun(c.Name(), cStart, elapsed, err, lastError)
 	return res
 }
 
-func (m *Manager) getStartupDelay() time.Duration {
-	last, err := m.store.GetLastTimestamp("power_dash_collection_mark")
+// recordRun stores the outcome of a collector run as self-monitoring series.
+// lastError is when the collector last failed, written once it has failed
+// at all; the error text itself is only kept in Health, as it would create a
+// new series per message.
+func (m *Manager) recordRun(name string, at time.Time, elapsed time.Duration, err error, lastError time.Time) {
+	ts := at.Unix()
+	lbls := []store.Label{{Name: "collector", Value: name}}
+	success := 1.0
 	if err != nil {
-		m.logger.Warn("Could not determine last poll time", zap.Error(err))
-		return 0
+		success = 0
 	}
-
-	if last == 0 {
-		return 0
+	if e := m.store.Insert(metrics.CollectorDurationSeconds, lbls, elapsed.Seconds(), ts); e != nil {
+		m.logger.Warn("Failed to record collector duration", zap.String("collector", name), zap.Error(e))
 	}
-
-	elapsed := time.Since(time.Unix(last, 0))
-	if elapsed >= m.interval {
-		return 0
+	if e := m.store.Insert(metrics.CollectorSuccess, lbls, success, ts); e != nil {
+		m.logger.Warn("Failed to record collector success", zap.String("collector", name), zap.Error(e))
 	}
-
-	return m.interval - elapsed
-}
-
-func (m *Manager) runCollection() {
-	if !m.isCollecting.CompareAndSwap(false, true) {
-		m.logger.Warn("Previous collection cycle still in progress, skipping")
+	if lastError.IsZero() {
 		return
 	}
-	defer m.isCollecting.Store(false)
-
-	m.logger.Debug("Collection cycle started")
-	start := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
+	if e := m.store.Insert(metrics.CollectorLastError, lbls, float64(lastError.Unix()), ts); e != nil {
+		m.logger.Warn("Failed to record collector last error", zap.String("collector", name), zap.Error(e))
+	}
+}
 
-	for _, c := range m.collectors {
-		m.logger.Debug("Running collector", zap.String("collector", c.Name()))
-		_, err := c.Collect(ctx, m.store)
-		if err != nil {
-			m.logger.Error("Error collecting metrics", zap.Error(err), zap.String("collector", c.Name()))
-			continue
-		}
+// Health returns the health of every registered collector.
+func (m *Manager) Health() []Health {
+	out := make([]Health, 0, len(m.collectors))
+	for _, sc := range m.collectors {
+		out = append(out, sc.health.snapshot(sc.Name(), sc.schedule))
 	}
+	return out
+}
 
-	// Record collection mark
-	_ = m.store.InsertCollectionMark(start)
+// sinceLastMark returns how long ago the last collection mark was written,
+// or zero if there is none.
+func (m *Manager) sinceLastMark() time.Duration {
+	last, err := m.store.GetLastTimestamp(metrics.CollectionMark)
+	if err != nil {
+		m.logger.Warn("Could not determine last poll time", zap.Error(err))
+		return 0
+	}
+	if last == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(last, 0))
+}
 
-	m.logger.Info("Collection cycle completed", zap.Duration("duration", time.Since(start)))
+// startupDelay postpones the first run after a restart until interval has
+// passed since the last poll.
+func startupDelay(interval, sinceLast time.Duration) time.Duration {
+	if sinceLast <= 0 || sinceLast >= interval {
+		return 0
+	}
+	return interval - sinceLast
 }
@@ -0,0 +1,41 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+// PriceCollector records the current energy price from the tariff that
+// ConfigCollector last fetched. It runs on the default interval so the price
+// series stays fresh while the config itself is only refreshed hourly.
+type PriceCollector struct {
+	config *ConfigCollector
+}
+
+func NewPriceCollector(config *ConfigCollector) *PriceCollector {
+	return &PriceCollector{config: config}
+}
+
+func (c *PriceCollector) Name() string {
+	return "PriceCollector"
+}
+
+func (c *PriceCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	now := time.Now()
+	rate, periodName := c.config.currentRate(now)
+	if periodName == "" {
+		return "No active rate found", nil
+	}
+
+	// Store expects seconds for Insert()
+	err := s.Insert(metrics.EnergyPriceUSD, []store.Label{{Name: "period", Value: periodName}}, rate, now.Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to insert price metric: %w", err)
+	}
+
+	return fmt.Sprintf("Recorded rate $%.4f (%s)", rate, periodName), nil
+}
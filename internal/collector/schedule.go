@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/config"
+)
+
+// Schedule controls how often a collector runs. A zero Interval uses the
+// manager's default interval. Each run starts after a random delay of up to
+// Jitter, so collectors on the same interval don't hit the gateway at once,
+// and is cancelled after Timeout.
+type Schedule struct {
+	Interval time.Duration
+	Jitter   time.Duration
+	Timeout  time.Duration
+	Disabled bool
+}
+
+// DefaultSchedules holds the schedules of collectors that should not run on
+// the default interval, by ScheduleKey.
+var DefaultSchedules = map[string]Schedule{
+	"config":   {Interval: time.Hour},
+	"selftest": {Interval: 15 * time.Minute},
+}
+
+// ScheduleKey returns the name a collector is configured under: its Name in
+// lower case without the "Collector" suffix, e.g. "soe" for SoeCollector.
+func ScheduleKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "Collector"))
+}
+
+// resolveSchedule returns the schedule of the collector named key: every
+// interval, unless DefaultSchedules or schedules say otherwise.
+func resolveSchedule(key string, interval time.Duration, schedules map[string]Schedule) Schedule {
+	sched := Schedule{Interval: interval, Timeout: collectTimeout}
+	if d, ok := DefaultSchedules[key]; ok {
+		sched = sched.override(d)
+	}
+	if o, ok := schedules[key]; ok {
+		sched = sched.override(o)
+	}
+	return sched
+}
+
+// ConfigSchedules converts the collectors config section into manager
+// schedules keyed by ScheduleKey.
+func ConfigSchedules(opts map[string]config.CollectorOptions) (map[string]Schedule, error) {
+	schedules := make(map[string]Schedule, len(opts))
+	for name, c := range opts {
+		interval, err := c.GetInterval()
+		if err != nil {
+			return nil, fmt.Errorf("collector %q: %w", name, err)
+		}
+		jitter, err := c.GetJitter()
+		if err != nil {
+			return nil, fmt.Errorf("collector %q: %w", name, err)
+		}
+		timeout, err := c.GetTimeout()
+		if err != nil {
+			return nil, fmt.Errorf("collector %q: %w", name, err)
+		}
+		schedules[ScheduleKey(name)] = Schedule{
+			Interval: interval,
+			Jitter:   jitter,
+			Timeout:  timeout,
+			Disabled: !c.IsEnabled(),
+		}
+	}
+	return schedules, nil
+}
+
+// override returns s with the non-zero fields of o applied.
+func (s Schedule) override(o Schedule) Schedule {
+	if o.Interval > 0 {
+		s.Interval = o.Interval
+	}
+	if o.Jitter > 0 {
+		s.Jitter = o.Jitter
+	}
+	if o.Timeout > 0 {
+		s.Timeout = o.Timeout
+	}
+	s.Disabled = s.Disabled || o.Disabled
+	return s
+}
@@ -0,0 +1,143 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ygelfand/power-dash/internal/powerwall"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+// SelfTestCollector records every newly completed inverter self-test and
+// protection-trip test run. A run counts as new once no test is running and
+// its latest test timestamp is newer than the last stored run.
+type SelfTestCollector struct {
+	pwr    *powerwall.PowerwallGateway
+	logger *zap.Logger
+}
+
+func NewSelfTestCollector(pwr *powerwall.PowerwallGateway, logger *zap.Logger) *SelfTestCollector {
+	return &SelfTestCollector{pwr: pwr, logger: logger}
+}
+
+func (c *SelfTestCollector) Name() string {
+	return "SelfTestCollector"
+}
+
+func (c *SelfTestCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	var runs []store.SelfTestRun
+
+	tests, err := c.pwr.FetchSelfTests(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch self-tests: %w", err)
+	}
+	if st := tests.EsCan.InverterSelfTests; !st.IsRunning && !st.IsCanceled {
+		for _, pinv := range st.PinvSelfTestsResults {
+			runs = append(runs, inverterSelfTestRun(pinv.Din, pinv.Overall, pinv.TestResults))
+		}
+	}
+
+	trips, err := c.pwr.FetchProtectionTrips(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch protection-trip tests: %w", err)
+	}
+	if pt := trips.Control.ProtectionTripTests; !pt.IsRunning && len(pt.Results) > 0 {
+		runs = append(runs, protectionTripRun(pt.Results))
+	}
+
+	added := 0
+	for _, run := range runs {
+		if run.Time == 0 || len(run.Results) == 0 {
+			continue
+		}
+		last := s.SelfTestRuns(store.SelfTestFilter{Kind: run.Kind, Device: run.Device, Limit: 1})
+		if len(last) > 0 && last[0].Time >= run.Time {
+			continue
+		}
+		if _, err := s.AddSelfTestRun(run); err != nil {
+			return "", fmt.Errorf("failed to store self-test run: %w", err)
+		}
+		c.logger.Info("Recorded self-test run",
+			zap.String("kind", run.Kind),
+			zap.String("device", run.Device),
+			zap.Bool("passed", run.Passed),
+		)
+		added++
+	}
+	return fmt.Sprintf("Recorded %d new test runs", added), nil
+}
+
+func inverterSelfTestRun(din string, overall powerwall.InverterSelfTest, tests []powerwall.InverterSelfTest) store.SelfTestRun {
+	run := store.SelfTestRun{Kind: store.SelfTestInverter, Device: din, Passed: testPassed(overall.Status)}
+	for _, t := range tests {
+		m := map[string]store.Measurement{}
+		put := func(name string, v *float64) {
+			if v != nil {
+				m[name] = store.Measurement{Value: *v}
+			}
+		}
+		put("set_magnitude", t.SetMagnitude)
+		put("set_time", t.SetTime)
+		put("trip_magnitude", t.TripMagnitude)
+		put("trip_time", t.TripTime)
+		put("accuracy_magnitude", t.AccuracyMagnitude)
+		put("accuracy_time", t.AccuracyTime)
+		put("current_magnitude", t.CurrentMagnitude)
+		run.Results = append(run.Results, store.SelfTestResult{
+			Test:         t.Test,
+			Status:       t.Status,
+			Passed:       testPassed(t.Status),
+			Summary:      t.Summary,
+			LastError:    t.LastError,
+			Time:         int64(t.Timestamp),
+			Measurements: m,
+		})
+		run.Time = max(run.Time, int64(t.Timestamp))
+	}
+	run.Time = max(run.Time, int64(overall.Timestamp))
+	return run
+}
+
+func protectionTripRun(tests []powerwall.ProtectionTripTest) store.SelfTestRun {
+	run := store.SelfTestRun{Kind: store.SelfTestProtectionTrip, Passed: true}
+	for _, t := range tests {
+		m := map[string]store.Measurement{}
+		put := func(name string, q *powerwall.Quantity) {
+			if q != nil {
+				m[name] = store.Measurement{Value: q.Value, Unit: q.Unit}
+			}
+		}
+		put("threshold_start_value", t.ThresholdStartValue)
+		put("threshold_set_trip_time", t.ThresholdSetTripTime)
+		put("trip_threshold", t.TripThreshold)
+		put("trip_time", t.TripTime)
+		put("trip_time_pass_criterion", t.TripTimePassCriterion)
+		put("trip_threshold_accuracy", t.TripThresholdAccuracy)
+		put("trip_time_accuracy", t.TripTimeAccuracy)
+		put("measurement_at_trip", t.MeasurementAtTrip)
+		put("measurement_accuracy", t.MeasurementAccuracy)
+		put("measurement_time_accuracy", t.MeasurementTimeAccuracy)
+		put("deviation", t.Deviation)
+		put("ramp_step_size", t.RampStepSize)
+		put("deviation_pass_criterion", t.DeviationPassCriterion)
+		passed := testPassed(t.Status)
+		run.Passed = run.Passed && passed
+		run.Results = append(run.Results, store.SelfTestResult{
+			Test:         t.TestType,
+			Status:       t.Status,
+			Passed:       passed,
+			Time:         int64(t.Timestamp),
+			Measurements: m,
+		})
+		run.Time = max(run.Time, int64(t.Timestamp))
+	}
+	return run
+}
+
+// testPassed reads a gateway test status such as "PASSED" or "TEST_PASS".
+func testPassed(status string) bool {
+	s := strings.ToUpper(status)
+	return strings.Contains(s, "PASS") && !strings.Contains(s, "FAIL")
+}
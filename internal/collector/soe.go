@@ -23,8 +23,8 @@ func (c *SoeCollector) Name() string {
 	return "SoeCollector"
 }
 
-func (c *SoeCollector) Collect(ctx context.Context, s *store.Store) (string, error) {
-	data, err := c.pwr.MakeAPIRequest("GET", "system_status/soe", nil)
+func (c *SoeCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	data, err := c.pwr.MakeAPIRequest(ctx, "GET", "system_status/soe", nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to get soe: %w", err)
 	}
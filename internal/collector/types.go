@@ -8,7 +8,7 @@ import (
 
 type Collector interface {
 	Name() string
-	Collect(ctx context.Context, s *store.Store) (string, error)
+	Collect(ctx context.Context, s store.Storage) (string, error)
 }
 
 var gridmap = map[string]float64{
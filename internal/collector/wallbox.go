@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/powerwall"
+	"github.com/ygelfand/power-dash/internal/store"
+	"github.com/ygelfand/power-dash/internal/utils"
+	"go.uber.org/zap"
+)
+
+// wallConnectorSource prefixes the alert source of Wall Connectors, e.g.
+// "wc_wasp_0".
+const wallConnectorSource = "wc_"
+
+// WallboxCollector stores charging power, session energy, vehicle state and
+// alerts of the Wall Connectors attached to the gateway, and their combined
+// power as the virtual meter site "ev".
+type WallboxCollector struct {
+	pwr    *powerwall.PowerwallGateway
+	alerts *alertTracker
+}
+
+func NewWallboxCollector(pwr *powerwall.PowerwallGateway, logger *zap.Logger) *WallboxCollector {
+	return &WallboxCollector{pwr: pwr, alerts: newAlertTracker(logger, func(source string) bool {
+		return strings.HasPrefix(source, wallConnectorSource)
+	})}
+}
+
+func (c *WallboxCollector) Name() string {
+	return "WallboxCollector"
+}
+
+func (c *WallboxCollector) Collect(ctx context.Context, s store.Storage) (string, error) {
+	resp, err := c.pwr.FetchWallbox(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch wall connectors: %w", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	ts := now.Unix()
+	connectors := resp.Connectors()
+
+	var total float64
+	reported := false
+	var alerts []store.Alert
+	for _, wc := range connectors {
+		lbls := []store.Label{{Name: "index", Value: fmt.Sprint(wc.Index)}, {Name: "type", Value: wc.Family}}
+		if p := wc.ChargePower(); p != nil {
+			_ = s.Insert(metrics.EVChargingPowerWatts, lbls, *p, ts)
+			total += *p
+			reported = true
+		}
+		if e := wc.SessionEnergy(); e != nil {
+			_ = s.Insert(metrics.EVSessionEnergyWh, lbls, *e, ts)
+		}
+		if connected, ok := wc.VehicleConnected(); ok {
+			v := 0.0
+			if connected {
+				v = 1
+			}
+			_ = s.Insert(metrics.EVVehicleConnectedBool, lbls, v, ts)
+		}
+		source := fmt.Sprintf("%s%s_%d", wallConnectorSource, wc.Family, wc.Index)
+		for _, a := range wc.ActiveAlerts {
+			alerts = append(alerts, store.Alert{Timestamp: now, Source: source, Name: a.Name})
+		}
+	}
+	c.alerts.update(s, now, alerts)
+	_ = s.InsertAlerts(alerts)
+
+	if reported {
+		_ = s.InsertMeterReadings([]store.MeterReading{{Timestamp: now, Site: "ev", Power: utils.ToPtr(total)}})
+	}
+
+	return fmt.Sprintf("Processed %d wall connectors, %.0f W charging", len(connectors), total), nil
+}
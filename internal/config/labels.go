@@ -82,6 +82,10 @@ func (lm *LabelManager) Save(newConfig LabelConfig) error {
 	return nil
 }
 
+func (lm *LabelManager) Path() string {
+	return lm.path
+}
+
 func (lm *LabelManager) IsWritable() bool {
 	// Check if file exists
 	_, err := os.Stat(lm.path)
@@ -1,6 +1,9 @@
 package config
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type ConnectionMode string
 
@@ -22,6 +25,11 @@ type StorageOptions struct {
 	DataPath          string `mapstructure:"path" yaml:"path,omitempty" json:"path,omitempty"`
 	Retention         string `mapstructure:"retention" yaml:"retention,omitempty" json:"retention,omitempty"`
 	PartitionDuration string `mapstructure:"partition" yaml:"partition,omitempty" json:"partition,omitempty"`
+	// RetentionPolicies keeps "raw" samples and each rollup tier ("5m", "1h", "1d")
+	// for its own duration. Unset or 0s keeps them until Retention applies.
+	RetentionPolicies map[string]string `mapstructure:"retention-policies" yaml:"retention-policies,omitempty" json:"retention-policies,omitempty"`
+	// Memory keeps samples in memory only, for demos and tests.
+	Memory bool `mapstructure:"memory" yaml:"memory,omitempty" json:"memory,omitempty"`
 }
 
 func (s StorageOptions) GetRetention() time.Duration {
@@ -32,6 +40,18 @@ func (s StorageOptions) GetRetention() time.Duration {
 	return d
 }
 
+func (s StorageOptions) GetRetentionPolicies() (map[string]time.Duration, error) {
+	policies := make(map[string]time.Duration, len(s.RetentionPolicies))
+	for name, v := range s.RetentionPolicies {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention for %q: %w", name, err)
+		}
+		policies[name] = d
+	}
+	return policies, nil
+}
+
 func (s StorageOptions) GetPartitionDuration() time.Duration {
 	if s.PartitionDuration == "" {
 		return 2 * time.Hour // Default block size
@@ -43,6 +63,109 @@ func (s StorageOptions) GetPartitionDuration() time.Duration {
 	return d
 }
 
+// RemoteWriteOptions configures forwarding of collected samples to a
+// Prometheus remote-write endpoint. It is disabled while URL is empty.
+type RemoteWriteOptions struct {
+	URL         string            `mapstructure:"url" yaml:"url,omitempty" json:"url,omitempty"`
+	Username    string            `mapstructure:"username" yaml:"username,omitempty" json:"username,omitempty"`
+	Password    string            `mapstructure:"password" yaml:"password,omitempty" json:"password,omitempty"`
+	BearerToken string            `mapstructure:"bearer-token" yaml:"bearer-token,omitempty" json:"bearer-token,omitempty"`
+	Headers     map[string]string `mapstructure:"headers" yaml:"headers,omitempty" json:"headers,omitempty"`
+	ExtraLabels map[string]string `mapstructure:"extra-labels" yaml:"extra-labels,omitempty" json:"extra-labels,omitempty"`
+	// QueuePath defaults to remote-write/ under the storage path.
+	QueuePath     string `mapstructure:"queue-path" yaml:"queue-path,omitempty" json:"queue-path,omitempty"`
+	FlushInterval string `mapstructure:"flush-interval" yaml:"flush-interval,omitempty" json:"flush-interval,omitempty"`
+	Timeout       string `mapstructure:"timeout" yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	MaxQueueFiles int    `mapstructure:"max-queue-files" yaml:"max-queue-files,omitempty" json:"max-queue-files,omitempty"`
+}
+
+func (r RemoteWriteOptions) GetFlushInterval() (time.Duration, error) {
+	if r.FlushInterval == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(r.FlushInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid flush-interval: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("flush-interval must be positive")
+	}
+	return d, nil
+}
+
+func (r RemoteWriteOptions) GetTimeout() (time.Duration, error) {
+	if r.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(r.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("timeout must be positive")
+	}
+	return d, nil
+}
+
+// CollectorOptions schedules one collector. Interval, Jitter and Timeout are
+// durations such as "5s"; an unset Interval keeps the collector's default,
+// normally collection-interval. Each run is delayed by a random part of
+// Jitter and cancelled after Timeout, 20s by default.
+type CollectorOptions struct {
+	Interval string `mapstructure:"interval" yaml:"interval,omitempty" json:"interval,omitempty"`
+	Jitter   string `mapstructure:"jitter" yaml:"jitter,omitempty" json:"jitter,omitempty"`
+	Timeout  string `mapstructure:"timeout" yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Enabled  *bool  `mapstructure:"enabled" yaml:"enabled,omitempty" json:"enabled,omitempty"`
+}
+
+func (c CollectorOptions) GetInterval() (time.Duration, error) {
+	if c.Interval == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval: %w", err)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("interval must be at least 1s")
+	}
+	return d, nil
+}
+
+func (c CollectorOptions) GetJitter() (time.Duration, error) {
+	if c.Jitter == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.Jitter)
+	if err != nil {
+		return 0, fmt.Errorf("invalid jitter: %w", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("jitter must not be negative")
+	}
+	return d, nil
+}
+
+func (c CollectorOptions) GetTimeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout: %w", err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("timeout must be positive")
+	}
+	return d, nil
+}
+
+// IsEnabled reports whether the collector should run; collectors are enabled
+// unless set otherwise.
+func (c CollectorOptions) IsEnabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
 type ProxyOptions struct {
 	ConfigPath         string `mapstructure:"-" yaml:"-" json:"-"`
 	PowerwallOptions   `mapstructure:",squash" yaml:",inline"`
@@ -51,11 +174,17 @@ type ProxyOptions struct {
 	DefaultTheme       string `mapstructure:"default-theme" yaml:"default-theme,omitempty" json:"default-theme,omitempty"`
 	LogLevel           string `mapstructure:"log-level" yaml:"log-level,omitempty" json:"log-level,omitempty"`
 	DisableCollector   bool   `mapstructure:"no-collector" yaml:"no-collector,omitempty" json:"no-collector,omitempty"`
+	// CollectorConcurrency bounds how many collectors run at once.
+	CollectorConcurrency uint32 `mapstructure:"collector-concurrency" yaml:"collector-concurrency,omitempty" json:"collector-concurrency,omitempty"`
+
+	ListenOn        string             `mapstructure:"listen" yaml:"listen,omitempty" json:"listen,omitempty"`
+	Storage         StorageOptions     `mapstructure:"storage" yaml:"storage,omitempty" json:"storage,omitempty"`
+	RemoteWrite     RemoteWriteOptions `mapstructure:"remote-write" yaml:"remote-write,omitempty" json:"remote-write,omitempty"`
+	Dashboards      []DashboardConfig  `mapstructure:"dashboards" yaml:"dashboards,omitempty" json:"dashboards,omitempty"`
+	LabelConfigPath string             `mapstructure:"label-config" yaml:"label-config,omitempty" json:"label-config,omitempty"`
 
-	ListenOn        string            `mapstructure:"listen" yaml:"listen,omitempty" json:"listen,omitempty"`
-	Storage         StorageOptions    `mapstructure:"storage" yaml:"storage,omitempty" json:"storage,omitempty"`
-	Dashboards      []DashboardConfig `mapstructure:"dashboards" yaml:"dashboards,omitempty" json:"dashboards,omitempty"`
-	LabelConfigPath string            `mapstructure:"label-config" yaml:"label-config,omitempty" json:"label-config,omitempty"`
+	// Collectors overrides the schedule of collectors by name, e.g. "soe".
+	Collectors map[string]CollectorOptions `mapstructure:"collectors" yaml:"collectors,omitempty" json:"collectors,omitempty"`
 }
 
 func NewDefaultProxyOptions() ProxyOptions {
@@ -0,0 +1,298 @@
+// Package export streams stored series as CSV, JSON Lines or InfluxDB line
+// protocol.
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/store"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatInflux = "influx"
+)
+
+// Request selects what to export. Matchers are PromQL series selectors such as
+// power_watts{site="solar"}. Start and End are unix seconds; Step, Unit,
+// Timezone and Function aggregate the same way as a BatchQueryRequest, and
+// without a Step or Unit raw samples are exported.
+type Request struct {
+	Matchers []string `json:"matchers" form:"match"`
+	Start    int64    `json:"start" form:"start"`
+	End      int64    `json:"end" form:"end"`
+	Step     int64    `json:"step" form:"step"`
+	Unit     string   `json:"unit,omitempty" form:"unit"`
+	Timezone string   `json:"timezone,omitempty" form:"timezone"`
+	Function string   `json:"function" form:"function"`
+	Format   string   `json:"format" form:"format"`
+}
+
+// Parse validates r, fills in defaults and returns its parsed matchers.
+func (r *Request) Parse() ([][]*labels.Matcher, error) {
+	if r.Format == "" {
+		r.Format = FormatCSV
+	}
+	if ContentType(r.Format) == "" {
+		return nil, fmt.Errorf("unknown format %q (expected csv, ndjson or influx)", r.Format)
+	}
+	if len(r.Matchers) == 0 {
+		return nil, fmt.Errorf("at least one matcher is required")
+	}
+	if r.End == 0 {
+		r.End = time.Now().Unix()
+	}
+	if r.Start > r.End {
+		return nil, fmt.Errorf("start must not be after end")
+	}
+	if _, err := store.ParseStep(r.Step, r.Unit, r.Timezone); err != nil {
+		return nil, err
+	}
+
+	sets := make([][]*labels.Matcher, 0, len(r.Matchers))
+	for _, m := range r.Matchers {
+		ms, err := parser.ParseMetricSelector(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %w", m, err)
+		}
+		for _, lm := range ms {
+			if lm.Name == labels.MetricName && lm.Type == labels.MatchEqual {
+				if _, ok := metrics.Lookup(lm.Value); !ok {
+					return nil, fmt.Errorf("unknown metric %q", lm.Value)
+				}
+			}
+		}
+		sets = append(sets, ms)
+	}
+	return sets, nil
+}
+
+// ContentType returns the MIME type of format, or "" if it is unknown.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatInflux:
+		return "text/plain"
+	}
+	return ""
+}
+
+// Extension returns the usual file extension for format.
+func Extension(format string) string {
+	switch format {
+	case FormatNDJSON:
+		return "ndjson"
+	case FormatInflux:
+		return "lp"
+	}
+	return "csv"
+}
+
+// Write streams the series selected by req to w. Nothing is written when req
+// is invalid or matches nothing before the first series is read.
+func Write(ctx context.Context, w io.Writer, st *store.Store, req Request) error {
+	matcherSets, err := req.Parse()
+	if err != nil {
+		return err
+	}
+	step, err := store.ParseStep(req.Step, req.Unit, req.Timezone)
+	if err != nil {
+		return err
+	}
+	series, err := st.MatchSeries(ctx, matcherSets, req.Start, req.End)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	var enc encoder
+	switch req.Format {
+	case FormatNDJSON:
+		enc = &ndjsonEncoder{w: bw}
+	case FormatInflux:
+		enc = &influxEncoder{w: bw}
+	default:
+		enc = newCSVEncoder(bw, series)
+	}
+
+	err = st.Export(ctx, series, req.Start, req.End, step, req.Function, func(sp store.SeriesPoints) error {
+		if err := enc.encode(sp); err != nil {
+			return err
+		}
+		// Hand each batch to the client instead of growing the buffer.
+		return bw.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+type encoder interface {
+	encode(sp store.SeriesPoints) error
+	close() error
+}
+
+// csvEncoder writes one row per point with a column for every label name
+// found in the exported series.
+type csvEncoder struct {
+	w          *csv.Writer
+	labelNames []string
+	header     bool
+}
+
+func newCSVEncoder(w io.Writer, series []labels.Labels) *csvEncoder {
+	names := make(map[string]bool)
+	for _, lset := range series {
+		lset.Range(func(l labels.Label) {
+			if l.Name != labels.MetricName {
+				names[l.Name] = true
+			}
+		})
+	}
+	enc := &csvEncoder{w: csv.NewWriter(w)}
+	for name := range names {
+		enc.labelNames = append(enc.labelNames, name)
+	}
+	sort.Strings(enc.labelNames)
+	return enc
+}
+
+func (e *csvEncoder) writeHeader() error {
+	e.header = true
+	row := append([]string{"time", "metric"}, e.labelNames...)
+	return e.w.Write(append(row, "value"))
+}
+
+func (e *csvEncoder) encode(sp store.SeriesPoints) error {
+	if !e.header {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(e.labelNames)+3)
+	row[1] = sp.Metric
+	for i, name := range e.labelNames {
+		row[i+2] = labelValue(sp.Labels, name)
+	}
+	for _, p := range sp.Points {
+		row[0] = time.Unix(p.Timestamp, 0).UTC().Format(time.RFC3339)
+		row[len(row)-1] = strconv.FormatFloat(p.Value, 'f', -1, 64)
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) close() error {
+	if !e.header {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonLine struct {
+	Time   string            `json:"time"`
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+type ndjsonEncoder struct {
+	w io.Writer
+}
+
+func (e *ndjsonEncoder) encode(sp store.SeriesPoints) error {
+	enc := json.NewEncoder(e.w)
+	line := ndjsonLine{Metric: sp.Metric, Labels: make(map[string]string, len(sp.Labels))}
+	for _, l := range sp.Labels {
+		line.Labels[l.Name] = l.Value
+	}
+	for _, p := range sp.Points {
+		// JSON has no representation for NaN or infinities.
+		if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+			continue
+		}
+		line.Time = time.Unix(p.Timestamp, 0).UTC().Format(time.RFC3339)
+		line.Value = p.Value
+		if err := enc.Encode(&line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *ndjsonEncoder) close() error { return nil }
+
+// influxEncoder writes line protocol with the metric as measurement, labels
+// as tags and a single "value" field.
+type influxEncoder struct {
+	w io.Writer
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func (e *influxEncoder) encode(sp store.SeriesPoints) error {
+	var prefix strings.Builder
+	prefix.WriteString(measurementEscaper.Replace(sp.Metric))
+	for _, l := range sp.Labels {
+		if l.Value == "" {
+			continue
+		}
+		prefix.WriteByte(',')
+		prefix.WriteString(tagEscaper.Replace(l.Name))
+		prefix.WriteByte('=')
+		prefix.WriteString(tagEscaper.Replace(l.Value))
+	}
+	prefix.WriteString(" value=")
+
+	for _, p := range sp.Points {
+		if math.IsNaN(p.Value) || math.IsInf(p.Value, 0) {
+			continue
+		}
+		line := prefix.String() + strconv.FormatFloat(p.Value, 'f', -1, 64) + " " + strconv.FormatInt(p.Timestamp*int64(time.Second), 10) + "\n"
+		if _, err := io.WriteString(e.w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *influxEncoder) close() error { return nil }
+
+func labelValue(lbls []store.Label, name string) string {
+	for _, l := range lbls {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
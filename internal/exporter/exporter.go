@@ -0,0 +1,127 @@
+// Package exporter publishes the latest stored readings in the Prometheus
+// exposition format so an external Prometheus can scrape power-dash like any
+// other exporter.
+package exporter
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+// staleAfter drops series that have not been written recently, such as
+// alerts that cleared or devices that went away.
+const staleAfter = 10 * time.Minute
+
+// LatestCollector exposes the most recent sample of every stored series.
+type LatestCollector struct {
+	store  store.Storage
+	logger *zap.Logger
+}
+
+func NewLatestCollector(st store.Storage, logger *zap.Logger) *LatestCollector {
+	return &LatestCollector{store: st, logger: logger}
+}
+
+// Describe sends nothing: the metric set depends on the data, which makes
+// this an unchecked collector.
+func (c *LatestCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *LatestCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	q, err := c.store.Queryable().Querier(now.Add(-staleAfter).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		c.logger.Error("Failed to query latest values for /metrics", zap.Error(err))
+		return
+	}
+	defer q.Close()
+
+	type latest struct {
+		lset labels.Labels
+		v    float64
+	}
+	var series []latest
+	labelNames := make(map[string]map[string]bool)
+
+	ss := q.Select(context.Background(), false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for ss.Next() {
+		lset := ss.At().Labels()
+		name := lset.Get(labels.MetricName)
+		if store.IsRollupMetric(name) {
+			continue
+		}
+		v, found := math.NaN(), false
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			_, v = it.At()
+			found = true
+		}
+		if !found {
+			continue
+		}
+		series = append(series, latest{lset: lset, v: v})
+		if labelNames[name] == nil {
+			labelNames[name] = make(map[string]bool)
+		}
+		lset.Range(func(l labels.Label) {
+			if l.Name != labels.MetricName {
+				labelNames[name][l.Name] = true
+			}
+		})
+	}
+	if err := ss.Err(); err != nil {
+		c.logger.Error("Failed to read latest values for /metrics", zap.Error(err))
+		return
+	}
+
+	// Every series of a metric family must carry the same label names, so
+	// labels a series lacks are exported as empty, which Prometheus treats as
+	// absent.
+	descs := make(map[string]*prometheus.Desc)
+	names := make(map[string][]string)
+	for _, s := range series {
+		name := s.lset.Get(labels.MetricName)
+		desc, ok := descs[name]
+		if !ok {
+			for l := range labelNames[name] {
+				names[name] = append(names[name], l)
+			}
+			desc = prometheus.NewDesc(name, helpFor(name), names[name], nil)
+			descs[name] = desc
+		}
+		values := make([]string, len(names[name]))
+		for i, l := range names[name] {
+			values[i] = s.lset.Get(l)
+		}
+		m, err := prometheus.NewConstMetric(desc, valueType(name), s.v, values...)
+		if err != nil {
+			c.logger.Warn("Skipping series on /metrics", zap.String("series", s.lset.String()), zap.Error(err))
+			continue
+		}
+		ch <- m
+	}
+}
+
+func helpFor(name string) string {
+	if info, ok := metrics.Lookup(name); ok {
+		if info.Unit != "" {
+			return info.Description + " Unit: " + info.Unit + "."
+		}
+		return info.Description
+	}
+	return "Latest stored value of " + name + "."
+}
+
+func valueType(name string) prometheus.ValueType {
+	if info, ok := metrics.Lookup(name); ok && info.Type == metrics.Counter {
+		return prometheus.CounterValue
+	}
+	return prometheus.GaugeValue
+}
@@ -26,11 +26,20 @@ type Config struct {
 
 type Importer struct {
 	config Config
-	store  *store.Store
+	store  store.Storage
 	logger *zap.Logger
 }
 
-func NewImporter(cfg Config, s *store.Store, l *zap.Logger) *Importer {
+// maintainer is implemented by storage that persists imported samples to
+// blocks and keeps rollups, which need refreshing after each chunk.
+type maintainer interface {
+	Checkpoint() error
+	BackfillRollups(ctx context.Context, start, end time.Time) error
+	Flush() error
+	CompactOOO() error
+}
+
+func NewImporter(cfg Config, s store.Storage, l *zap.Logger) *Importer {
 	if len(cfg.Measurements) == 0 {
 		cfg.Measurements = []string{"http", "alerts", "soe", "vitals", "pwfans", "pwtemps"}
 	}
@@ -140,15 +149,25 @@ func (imp *Importer) RunImport(ctx context.Context, start, end time.Time, progre
 		if len(allSeries) > 0 {
 			imp.logger.Info("Importing data chunk", zap.Int("series_count", len(allSeries)), zap.Time("start", chunkStart), zap.Time("end", chunkEnd))
 			processSeries(imp.store, allSeries, imp.logger)
-			_ = imp.store.Checkpoint()
+			if m, ok := imp.store.(maintainer); ok {
+				_ = m.Checkpoint()
+				if err := m.BackfillRollups(ctx, chunkStart, chunkEnd); err != nil {
+					imp.logger.Error("Failed to backfill rollups", zap.Error(err), zap.Time("start", chunkStart))
+				}
+			}
 		} else {
 			imp.logger.Debug("No data found in chunk", zap.Time("start", chunkStart), zap.Time("end", chunkEnd))
 		}
 	}
 
+	m, ok := imp.store.(maintainer)
+	if !ok {
+		imp.logger.Info("Import complete")
+		return nil
+	}
 	imp.logger.Info("Import complete, flushing storage")
-	_ = imp.store.Flush()
-	return imp.store.CompactOOO()
+	_ = m.Flush()
+	return m.CompactOOO()
 }
 
 // Internal helper logic moved from CLI
@@ -195,7 +214,7 @@ func isStatusField(col string) bool {
 		strings.Contains(lower, "alert")
 }
 
-func processSeries(st *store.Store, allSeries []seriesInfo, logger *zap.Logger) {
+func processSeries(st store.Storage, allSeries []seriesInfo, logger *zap.Logger) {
 	var meters []store.MeterReading
 	var batteries []store.BatteryReading
 	var system []store.SystemStatus
@@ -0,0 +1,161 @@
+// Package metrics is the registry of every metric power-dash stores: its unit,
+// type, description, the labels it carries and the values it may take.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+type Type string
+
+const (
+	Gauge   Type = "gauge"
+	Counter Type = "counter"
+)
+
+// Metric names written by the store.
+const (
+	PowerWatts             = "power_watts"
+	PowerReactiveVAR       = "power_reactive_var"
+	PowerApparentVA        = "power_apparent_va"
+	VoltageVolts           = "voltage_volts"
+	CurrentAmps            = "current_amps"
+	FrequencyHertz         = "frequency_hertz"
+	EnergyWh               = "energy_wh"
+	InverterPowerWatts     = "inverter_power_watts"
+	InverterFrequencyHertz = "inverter_frequency_hertz"
+	InverterVoltageVolts   = "inverter_voltage_volts"
+	SolarVoltageVolts      = "solar_voltage_volts"
+	SolarCurrentAmps       = "solar_current_amps"
+	SolarPowerWatts        = "solar_power_watts"
+	BatterySOEPercent      = "battery_soe_percent"
+	BatteryEnergyWh        = "battery_energy_wh"
+	BatteryDCPowerWatts    = "battery_dc_power_watts"
+	GridStatusCode         = "grid_status_code"
+	GridServicesActiveBool = "grid_services_active_bool"
+	TemperatureCelsius     = "temperature_celsius"
+	FanSpeedRPM            = "fan_speed_rpm"
+	ActiveAlert            = "active_alert"
+	EVChargingPowerWatts   = "ev_charging_power_watts"
+	EVSessionEnergyWh      = "ev_session_energy_wh"
+	EVVehicleConnectedBool = "ev_vehicle_connected_bool"
+	ComponentState         = "component_state"
+	UtilityControlLimitW   = "utility_control_limit_watts"
+	UtilityControlEnergize = "utility_control_energize_bool"
+	UtilityPollRateSeconds = "utility_poll_rate_seconds"
+	UtilityPollAgeSeconds  = "utility_poll_age_seconds"
+	EnergyPriceUSD         = "energy_price_usd"
+	CollectionMark         = "collection_mark"
+	GatewayUp              = "gateway_up"
+
+	CollectorDurationSeconds = "collector_duration_seconds"
+	CollectorSuccess         = "collector_success"
+	CollectorLastError       = "collector_last_error"
+)
+
+// Info describes a metric. Min and Max bound the values accepted by the
+// store; nil leaves that side open.
+type Info struct {
+	Name        string   `json:"name"`
+	Unit        string   `json:"unit"`
+	Type        Type     `json:"type"`
+	Description string   `json:"description"`
+	Labels      []string `json:"labels"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+}
+
+func bound(v float64) *float64 { return &v }
+
+var registry = map[string]Info{}
+
+func register(infos ...Info) {
+	for _, info := range infos {
+		if info.Labels == nil {
+			info.Labels = []string{}
+		}
+		registry[info.Name] = info
+	}
+}
+
+func init() {
+	register(
+		Info{Name: PowerWatts, Unit: "W", Type: Gauge, Description: "Real power per meter site and phase.", Labels: []string{"site", "phase"}},
+		Info{Name: PowerReactiveVAR, Unit: "var", Type: Gauge, Description: "Reactive power per meter site and phase.", Labels: []string{"site", "phase"}},
+		Info{Name: PowerApparentVA, Unit: "VA", Type: Gauge, Description: "Apparent power per meter site and phase.", Labels: []string{"site", "phase"}},
+		Info{Name: VoltageVolts, Unit: "V", Type: Gauge, Description: "Voltage per meter site and phase.", Labels: []string{"site", "phase"}, Min: bound(0)},
+		Info{Name: CurrentAmps, Unit: "A", Type: Gauge, Description: "Current per meter site and phase.", Labels: []string{"site", "phase"}},
+		Info{Name: FrequencyHertz, Unit: "Hz", Type: Gauge, Description: "Line frequency per meter site.", Labels: []string{"site", "phase"}, Min: bound(0), Max: bound(100)},
+		Info{Name: EnergyWh, Unit: "Wh", Type: Counter, Description: "Lifetime energy per meter site and direction.", Labels: []string{"site", "direction"}, Min: bound(0)},
+		Info{Name: InverterPowerWatts, Unit: "W", Type: Gauge, Description: "Inverter output power.", Labels: []string{"index", "type"}},
+		Info{Name: InverterFrequencyHertz, Unit: "Hz", Type: Gauge, Description: "Inverter output frequency.", Labels: []string{"index", "type"}, Min: bound(0), Max: bound(100)},
+		Info{Name: InverterVoltageVolts, Unit: "V", Type: Gauge, Description: "Inverter output voltage per phase.", Labels: []string{"index", "type", "phase"}, Min: bound(0)},
+		Info{Name: SolarVoltageVolts, Unit: "V", Type: Gauge, Description: "PV string voltage.", Labels: []string{"index", "string"}},
+		Info{Name: SolarCurrentAmps, Unit: "A", Type: Gauge, Description: "PV string current.", Labels: []string{"index", "string"}},
+		Info{Name: SolarPowerWatts, Unit: "W", Type: Gauge, Description: "PV string power.", Labels: []string{"index", "string"}},
+		Info{Name: BatterySOEPercent, Unit: "%", Type: Gauge, Description: "Battery state of energy.", Min: bound(0), Max: bound(100)},
+		Info{Name: BatteryEnergyWh, Unit: "Wh", Type: Gauge, Description: "Battery pack remaining energy and capacity.", Labels: []string{"index", "type"}, Min: bound(0)},
+		Info{Name: BatteryDCPowerWatts, Unit: "W", Type: Gauge, Description: "Powerwall 3 battery DC power.", Labels: []string{"index"}},
+		Info{Name: GridStatusCode, Unit: "", Type: Gauge, Description: "Grid connection status code reported by the gateway."},
+		Info{Name: GridServicesActiveBool, Unit: "", Type: Gauge, Description: "Whether grid services are active (1) or not (0).", Min: bound(0), Max: bound(1)},
+		Info{Name: TemperatureCelsius, Unit: "°C", Type: Gauge, Description: "Ambient temperature per device.", Labels: []string{"index"}, Min: bound(-100), Max: bound(200)},
+		Info{Name: FanSpeedRPM, Unit: "rpm", Type: Gauge, Description: "Actual and target fan speed per device.", Labels: []string{"index", "type"}, Min: bound(0)},
+		Info{Name: ActiveAlert, Unit: "", Type: Gauge, Description: "Set to 1 while a gateway alert is active.", Labels: []string{"source", "name"}},
+		Info{Name: EVChargingPowerWatts, Unit: "W", Type: Gauge, Description: "Power a Wall Connector delivers to the vehicle.", Labels: []string{"index", "type"}, Min: bound(0)},
+		Info{Name: EVSessionEnergyWh, Unit: "Wh", Type: Gauge, Description: "Energy delivered in the current Wall Connector session.", Labels: []string{"index", "type"}, Min: bound(0)},
+		Info{Name: EVVehicleConnectedBool, Unit: "", Type: Gauge, Description: "Whether a vehicle is plugged into the Wall Connector (1) or not (0).", Labels: []string{"index", "type"}, Min: bound(0), Max: bound(1)},
+		Info{Name: ComponentState, Unit: "", Type: Gauge, Description: "Set to 1 for the current state of a Powerwall 3 component signal.", Labels: []string{"component", "index", "signal", "state"}},
+		Info{Name: UtilityControlLimitW, Unit: "W", Type: Gauge, Description: "Limit set by an IEEE 2030.5 utility control for each operating mode.", Labels: []string{"control", "index", "mode"}},
+		Info{Name: UtilityControlEnergize, Unit: "", Type: Gauge, Description: "Whether an IEEE 2030.5 utility control allows the system to energize (1) or not (0).", Labels: []string{"control", "index"}, Min: bound(0), Max: bound(1)},
+		Info{Name: UtilityPollRateSeconds, Unit: "s", Type: Gauge, Description: "How often the gateway polls each IEEE 2030.5 resource of the utility.", Labels: []string{"resource"}, Min: bound(0)},
+		Info{Name: UtilityPollAgeSeconds, Unit: "s", Type: Gauge, Description: "Time since the gateway last polled each IEEE 2030.5 resource of the utility.", Labels: []string{"resource"}},
+		Info{Name: EnergyPriceUSD, Unit: "USD/kWh", Type: Gauge, Description: "Configured energy price per tariff period.", Labels: []string{"period"}},
+		Info{Name: CollectionMark, Unit: "", Type: Gauge, Description: "Written once per completed collection cycle."},
+		Info{Name: GatewayUp, Unit: "", Type: Gauge, Description: "Whether the gateway is reachable (1) or not (0).", Min: bound(0), Max: bound(1)},
+		Info{Name: CollectorDurationSeconds, Unit: "s", Type: Gauge, Description: "Duration of each collector run.", Labels: []string{"collector"}, Min: bound(0)},
+		Info{Name: CollectorSuccess, Unit: "", Type: Gauge, Description: "Whether a collector run succeeded (1) or failed (0).", Labels: []string{"collector"}, Min: bound(0), Max: bound(1)},
+		Info{Name: CollectorLastError, Unit: "s", Type: Gauge, Description: "Unix time of the last failed collector run.", Labels: []string{"collector"}, Min: bound(0)},
+	)
+}
+
+// Lookup returns the registered metric called name.
+func Lookup(name string) (Info, bool) {
+	info, ok := registry[name]
+	return info, ok
+}
+
+// All returns every registered metric sorted by name.
+func All() []Info {
+	infos := make([]Info, 0, len(registry))
+	for _, info := range registry {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// HasLabel reports whether name is one of the labels the metric carries.
+func (i Info) HasLabel(name string) bool {
+	for _, l := range i.Labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckValue returns an error when v lies outside the metric's valid range.
+func (i Info) CheckValue(v float64) error {
+	if math.IsNaN(v) {
+		return fmt.Errorf("%s: value is NaN", i.Name)
+	}
+	if i.Min != nil && v < *i.Min {
+		return fmt.Errorf("%s: value %g below minimum %g", i.Name, v, *i.Min)
+	}
+	if i.Max != nil && v > *i.Max {
+		return fmt.Errorf("%s: value %g above maximum %g", i.Name, v, *i.Max)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package powerwall
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -28,21 +29,23 @@ type stop struct {
 	error
 }
 
-func (p *PowerwallGateway) MakeAPIRequest(method, path string, body io.Reader) ([]byte, error) {
-	req, err := http.NewRequest(method, p.Endpoint.JoinPath("api", path).String(), body)
+func (p *PowerwallGateway) MakeAPIRequest(ctx context.Context, method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.Endpoint.JoinPath("api", path).String(), body)
 	if err != nil {
 		return nil, err
 	}
 	var resp *http.Response
 	err = retry(5, 15*time.Millisecond, func() error {
-
-		if p.authToken != "" {
+		token, _ := p.auth()
+		if token != "" {
+			// Replace the cookie of an earlier attempt.
+			req.Header.Del("Cookie")
 			req.AddCookie(&http.Cookie{
 				Name:  "AuthCookie",
-				Value: p.authToken,
+				Value: token,
 			})
 		} else {
-			err = p.refreshAuthToken()
+			err = p.refreshAuthToken(ctx, token)
 			if err != nil {
 				// auth failed
 				return stop{err}
@@ -51,12 +54,16 @@ func (p *PowerwallGateway) MakeAPIRequest(method, path string, body io.Reader) (
 		}
 
 		req.Header.Set("Content-type", "application/json")
-		resp, err = p.httpClient.Do(req)
+		resp, err = p.do(req)
+		if errors.Is(err, ErrGatewayUnavailable) || ctx.Err() != nil {
+			return stop{err}
+		}
 		if err != nil {
 			return err
 		}
 		if resp.StatusCode == 401 || resp.StatusCode == 403 {
-			err = p.refreshAuthToken()
+			resp.Body.Close()
+			err = p.refreshAuthToken(ctx, token)
 			if err != nil {
 				// auth failed
 				return stop{err}
@@ -78,36 +85,51 @@ func (p *PowerwallGateway) MakeAPIRequest(method, path string, body io.Reader) (
 }
 
 func (p *PowerwallGateway) GetAuthHeaders() []*http.Cookie {
-	if p.authToken == "" {
-		p.refreshAuthToken()
+	if token, _ := p.auth(); token == "" {
+		p.refreshAuthToken(context.Background(), "")
 	}
+	token, userRecord := p.auth()
 	return []*http.Cookie{
 		&http.Cookie{
 			Name:  "AuthCookie",
-			Value: p.authToken,
+			Value: token,
 			Path:  "/",
 		},
 		&http.Cookie{
 			Name:  "UserRecord",
-			Value: p.userRecord,
+			Value: userRecord,
 			Path:  "/",
 		}}
 }
-func (p *PowerwallGateway) refreshAuthToken() error {
-	if !p.authSem.TryAcquire(1) {
-		p.logger.Debug("auth refresh skipped")
-		return errors.New("auth already in progress")
+
+// auth returns the current login token and user record.
+func (p *PowerwallGateway) auth() (token, userRecord string) {
+	p.authMu.RLock()
+	defer p.authMu.RUnlock()
+	return p.authToken, p.userRecord
+}
+
+// refreshAuthToken logs in to replace stale, the token a request was rejected
+// with. Callers that find a login already running wait for it and reuse its
+// token instead of logging in again.
+func (p *PowerwallGateway) refreshAuthToken(ctx context.Context, stale string) error {
+	if err := p.authSem.Acquire(ctx, 1); err != nil {
+		return err
 	}
 	defer p.authSem.Release(1)
+	if token, _ := p.auth(); token != "" && token != stale {
+		p.logger.Debug("Reusing refreshed auth token")
+		return nil
+	}
 	p.logger.Debug("Refreshing auth token")
 	auth := map[string]string{"username": "customer", "email": "foo@example.test", "password": p.password[len(p.password)-5:]}
 	jsonAuth, _ := json.Marshal(auth)
-	req, err := http.NewRequest("POST", p.Endpoint.JoinPath("api/login/Basic").String(), bytes.NewBuffer(jsonAuth))
+	req, err := http.NewRequestWithContext(ctx, "POST", p.Endpoint.JoinPath("api/login/Basic").String(), bytes.NewBuffer(jsonAuth))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-type", "application/json")
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return err
 	}
@@ -124,6 +146,8 @@ func (p *PowerwallGateway) refreshAuthToken() error {
 	if err != nil {
 		return err
 	}
+	p.authMu.Lock()
+	defer p.authMu.Unlock()
 	p.authToken = loginResp.Token
 	for _, c := range resp.Cookies() {
 		if c.Name == "UserRecord" {
@@ -0,0 +1,222 @@
+package powerwall
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrGatewayUnavailable is returned instead of contacting the gateway while
+// the breaker is open.
+var ErrGatewayUnavailable = errors.New("gateway unavailable")
+
+const (
+	// breakerThreshold is the number of consecutive connection failures that
+	// open the breaker.
+	breakerThreshold  = 3
+	breakerMinBackoff = 5 * time.Second
+	breakerMaxBackoff = 5 * time.Minute
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// Breaker tracks whether the gateway is reachable. After breakerThreshold
+// consecutive connection failures it opens and rejects requests for a
+// backoff that doubles after every failed probe. Once the backoff has
+// passed, a single probe request is let through: success closes the
+// breaker, failure opens it again.
+//
+// Only transport errors count as failures; any HTTP response, including an
+// error status, means the link is up.
+type Breaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	backoff   time.Duration
+	openUntil time.Time
+	probing   bool
+	logger    *zap.Logger
+}
+
+// BreakerStatus is a point-in-time view of a Breaker.
+type BreakerStatus struct {
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	RetryAt             *time.Time   `json:"retry_at,omitempty"`
+}
+
+func NewBreaker(logger *zap.Logger) *Breaker {
+	return &Breaker{state: BreakerClosed, logger: logger}
+}
+
+// Allow returns ErrGatewayUnavailable if a request must not be sent now.
+// When it lets a probe through on an open breaker, the caller must report
+// the outcome with Success or Failure.
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return ErrGatewayUnavailable
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrGatewayUnavailable
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Ready reports whether a request would currently be let through, without
+// claiming a probe.
+func (b *Breaker) Ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return !time.Now().Before(b.openUntil)
+	case BreakerHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// Up reports whether the breaker is closed.
+func (b *Breaker) Up() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == BreakerClosed
+}
+
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.backoff = 0
+	b.probing = false
+	b.setState(BreakerClosed)
+}
+
+func (b *Breaker) Failure(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch b.state {
+	case BreakerHalfOpen:
+		b.probing = false
+		b.backoff = min(b.backoff*2, breakerMaxBackoff)
+		b.open(err)
+	case BreakerClosed:
+		if b.failures >= breakerThreshold {
+			b.backoff = breakerMinBackoff
+			b.open(err)
+		}
+	}
+}
+
+// Release ends a request that was abandoned by its caller, such as on a
+// context deadline, without counting it for or against the gateway.
+func (b *Breaker) Release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	if b == nil {
+		return BreakerStatus{State: BreakerClosed}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st := BreakerStatus{State: b.state, ConsecutiveFailures: b.failures}
+	if b.state == BreakerOpen {
+		t := b.openUntil
+		st.RetryAt = &t
+	}
+	return st
+}
+
+func (b *Breaker) open(err error) {
+	b.openUntil = time.Now().Add(b.backoff)
+	prev := b.state
+	b.state = BreakerOpen
+	b.logger.Warn("Gateway unreachable, pausing requests",
+		zap.String("from", string(prev)),
+		zap.Duration("backoff", b.backoff),
+		zap.Error(err),
+	)
+}
+
+func (b *Breaker) setState(s BreakerState) {
+	if b.state == s {
+		return
+	}
+	prev := b.state
+	b.state = s
+	fields := []zap.Field{zap.String("from", string(prev))}
+	if s == BreakerClosed {
+		b.logger.Info("Gateway reachable again", fields...)
+	} else {
+		b.logger.Info("Probing gateway", fields...)
+	}
+}
+
+// do sends req through the breaker.
+func (p *PowerwallGateway) do(req *http.Request) (*http.Response, error) {
+	if err := p.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		if req.Context().Err() != nil {
+			p.breaker.Release()
+		} else {
+			p.breaker.Failure(err)
+		}
+		return nil, err
+	}
+	p.breaker.Success()
+	return resp, nil
+}
+
+// Breaker returns the gateway's connection breaker.
+func (p *PowerwallGateway) Breaker() *Breaker {
+	return p.breaker
+}
@@ -0,0 +1,80 @@
+package powerwall
+
+// ComponentsResponse is the result of ComponentsQuery. On Powerwall 3
+// systems pch holds one entry per PW3 power conversion board and pws one per
+// PW3 system controller; both are empty on older systems.
+type ComponentsResponse struct {
+	Pw3Can struct {
+		FirmwareUpdate struct {
+			IsUpdating bool `json:"isUpdating"`
+			Progress   struct {
+				Updating            bool    `json:"updating"`
+				NumSteps            int     `json:"numSteps"`
+				CurrentStep         int     `json:"currentStep"`
+				CurrentStepProgress float64 `json:"currentStepProgress"`
+				Progress            float64 `json:"progress"`
+			} `json:"progress"`
+		} `json:"firmwareUpdate"`
+	} `json:"pw3Can"`
+	Components struct {
+		Pws []Component `json:"pws,omitempty"`
+		Pch []Component `json:"pch,omitempty"`
+		Bms []Component `json:"bms,omitempty"`
+		Hvp []Component `json:"hvp,omitempty"`
+	} `json:"components"`
+}
+
+type Component struct {
+	Din          string            `json:"din,omitempty"`
+	Signals      []ComponentSignal `json:"signals"`
+	ActiveAlerts []struct {
+		Name string `json:"name,omitempty"`
+	} `json:"activeAlerts,omitempty"`
+}
+
+type ComponentSignal struct {
+	Name      string   `json:"name"`
+	Value     *float64 `json:"value"`
+	TextValue *string  `json:"textValue"`
+	BoolValue any      `json:"boolValue"`
+	Timestamp string   `json:"timestamp"`
+}
+
+// Signal returns the signal called name.
+func (c Component) Signal(name string) (ComponentSignal, bool) {
+	for _, s := range c.Signals {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return ComponentSignal{}, false
+}
+
+// Value returns the numeric value of the signal called name, or nil if it is
+// missing or has none.
+func (c Component) Value(name string) *float64 {
+	s, ok := c.Signal(name)
+	if !ok {
+		return nil
+	}
+	return s.Value
+}
+
+// Text returns the textual value of the signal called name, falling back to
+// its boolean value, or "" if it has neither.
+func (c Component) Text(name string) string {
+	s, ok := c.Signal(name)
+	if !ok {
+		return ""
+	}
+	if s.TextValue != nil {
+		return *s.TextValue
+	}
+	if b, ok := s.BoolValue.(bool); ok {
+		if b {
+			return "true"
+		}
+		return "false"
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package powerwall
+
+// IEEE20305Response is the result of IE2030Query: the gateway's IEEE 2030.5
+// client state, including the utility's default DER control and the
+// controls currently in effect.
+type IEEE20305Response struct {
+	IEEE20305 struct {
+		LongFormDeviceID string `json:"longFormDeviceID"`
+		PolledResources  []struct {
+			URL                 string    `json:"url"`
+			Name                string    `json:"name"`
+			PollRateSeconds     *float64  `json:"pollRateSeconds"`
+			LastPolledTimestamp Timestamp `json:"lastPolledTimestamp"`
+		} `json:"polledResources"`
+		Controls struct {
+			DefaultControl *DERControl  `json:"defaultControl"`
+			ActiveControls []DERControl `json:"activeControls"`
+		} `json:"controls"`
+		Registration struct {
+			DateTimeRegistered Timestamp `json:"dateTimeRegistered"`
+			Pin                string    `json:"pin"`
+		} `json:"registration"`
+	} `json:"ieee20305"`
+}
+
+// DERControl is a set of IEEE 2030.5 DER operating modes. Limits are in
+// watts; nil means the mode is not set. MRID is empty when the gateway does
+// not report one.
+type DERControl struct {
+	MRID          string   `json:"mRID,omitempty"`
+	SetGradW      *float64 `json:"setGradW,omitempty"`
+	OpModEnergize *bool    `json:"opModEnergize,omitempty"`
+	OpModMaxLimW  *float64 `json:"opModMaxLimW,omitempty"`
+	OpModImpLimW  *float64 `json:"opModImpLimW,omitempty"`
+	OpModExpLimW  *float64 `json:"opModExpLimW,omitempty"`
+	OpModGenLimW  *float64 `json:"opModGenLimW,omitempty"`
+	OpModLoadLimW *float64 `json:"opModLoadLimW,omitempty"`
+}
@@ -28,6 +28,7 @@ func NewPowerwallGateway(opts *config.PowerwallOptions, logger *zap.Logger) *Pow
 		Endpoint:       u,
 		logger:         logger,
 		connectionMode: opts.ConnectionMode,
+		breaker:        NewBreaker(logger),
 	}
 
 	if opts.ConnectionMode == config.ConnectionModeLan {
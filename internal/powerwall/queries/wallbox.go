@@ -1,8 +1,21 @@
 package queries
 
+// wallboxComponentsQuery's pch group selects the Powerwall 3 units, which are
+// read by ComponentsQuery instead; the signed query requires its filter, so it
+// is kept but asks for no signals.
 var wallboxComponentsQuery = &SignedQuery{
 	Name:      "WallboxComponentsQuery",
 	SigKey:    2,
 	Signature: `MIGHAkEWuEQaXGKJ7/6Y0YGjt+oyQgvz4NxVkwiYSEaNJ0hPgZXRubJbNZn1/t9pNjL4qk6//CF7gonekaFvLc9w8ccTOAJCASDV8S/929xABr3PgJ/bnKU25O4vWyUyXqqYbsNmApa9wkn0ul9ExWayQe73rmyokml8gmMZIN2oe6yeV9hLPFHS`,
-	Query:     `query WallboxComponentsQuery($rootSignalNames:[String!]$sodaComponentsFilter:ComponentFilter$sodaSignalNames:[String!]$waspComponentsFilter:ComponentFilter$waspSignalNames:[String!]$stipComponentsFilter:ComponentFilter$stipSignalNames:[String!]$pchComponentsFilter:ComponentFilter$pchSignalNames:[String!]){components{root{signals(names:$rootSignalNames){name value textValue boolValue}}soda:components(filter:$sodaComponentsFilter){din signals(names:$sodaSignalNames){name value textValue boolValue timestamp}activeAlerts{name}}wasp:components(filter:$waspComponentsFilter){din signals(names:$waspSignalNames){name value textValue boolValue timestamp}activeAlerts{name}}stip:components(filter:$stipComponentsFilter){din signals(names:$stipSignalNames){name value textValue boolValue timestamp}activeAlerts{name}}pch:components(filter:$pchComponentsFilter){din signals(names:$pchSignalNames){name value textValue boolValue timestamp}activeAlerts{name}}}}`,
+	DefaultParams: PointerTo(`{"rootSignalNames": [],
+  "sodaComponentsFilter": {"types": ["SODA"]},
+  "sodaSignalNames": ["SODA_State", "SODA_VehicleConnected", "SODA_ChargePower", "SODA_SessionEnergy"],
+  "waspComponentsFilter": {"types": ["WASP"]},
+  "waspSignalNames": ["WASP_State", "WASP_VehicleConnected", "WASP_ChargePower", "WASP_SessionEnergy"],
+  "stipComponentsFilter": {"types": ["STIP"]},
+  "stipSignalNames": ["STIP_State", "STIP_VehicleConnected", "STIP_ChargePower", "STIP_SessionEnergy"],
+  "pchComponentsFilter": {"types": ["PW3SAF"]},
+  "pchSignalNames": []
+}`),
+	Query: `query WallboxComponentsQuery($rootSignalNames:[String!]$sodaComponentsFilter:ComponentFilter$sodaSignalNames:[String!]$waspComponentsFilter:ComponentFilter$waspSignalNames:[String!]$stipComponentsFilter:ComponentFilter$stipSignalNames:[String!]$pchComponentsFilter:ComponentFilter$pchSignalNames:[String!]){components{root{signals(names:$rootSignalNames){name value textValue boolValue}}soda:components(filter:$sodaComponentsFilter){din signals(names:$sodaSignalNames){name value textValue boolValue timestamp}activeAlerts{name}}wasp:components(filter:$waspComponentsFilter){din signals(names:$waspSignalNames){name value textValue boolValue timestamp}activeAlerts{name}}stip:components(filter:$stipComponentsFilter){din signals(names:$stipSignalNames){name value textValue boolValue timestamp}activeAlerts{name}}pch:components(filter:$pchComponentsFilter){din signals(names:$pchSignalNames){name value textValue boolValue timestamp}activeAlerts{name}}}}`,
 }
@@ -0,0 +1,110 @@
+package powerwall
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// SelfTestResponse is the result of SelfTestQuery: the inverter self-test
+// results of every PINV.
+type SelfTestResponse struct {
+	EsCan struct {
+		InverterSelfTests struct {
+			IsRunning            bool `json:"isRunning"`
+			IsCanceled           bool `json:"isCanceled"`
+			PinvSelfTestsResults []struct {
+				Din         string             `json:"din"`
+				Overall     InverterSelfTest   `json:"overall"`
+				TestResults []InverterSelfTest `json:"testResults"`
+			} `json:"pinvSelfTestsResults"`
+		} `json:"inverterSelfTests"`
+	} `json:"esCan"`
+}
+
+// InverterSelfTest is one test of an inverter self-test run.
+type InverterSelfTest struct {
+	Status            string    `json:"status"`
+	Test              string    `json:"test"`
+	Summary           string    `json:"summary"`
+	SetMagnitude      *float64  `json:"setMagnitude"`
+	SetTime           *float64  `json:"setTime"`
+	TripMagnitude     *float64  `json:"tripMagnitude"`
+	TripTime          *float64  `json:"tripTime"`
+	AccuracyMagnitude *float64  `json:"accuracyMagnitude"`
+	AccuracyTime      *float64  `json:"accuracyTime"`
+	CurrentMagnitude  *float64  `json:"currentMagnitude"`
+	Timestamp         Timestamp `json:"timestamp"`
+	LastError         string    `json:"lastError"`
+}
+
+// ProtectionTripResponse is the result of ProtectionTripQuery.
+type ProtectionTripResponse struct {
+	Control struct {
+		ProtectionTripTests struct {
+			IsRunning bool                 `json:"isRunning"`
+			Results   []ProtectionTripTest `json:"results"`
+		} `json:"protectionTripTests"`
+	} `json:"control"`
+}
+
+// ProtectionTripTest is one test of a protection-trip test run.
+type ProtectionTripTest struct {
+	TestType                string    `json:"testType"`
+	Status                  string    `json:"status"`
+	Timestamp               Timestamp `json:"timestamp"`
+	ThresholdStartValue     *Quantity `json:"thresholdStartValue"`
+	ThresholdSetTripTime    *Quantity `json:"thresholdSetTripTime"`
+	TripThreshold           *Quantity `json:"tripThreshold"`
+	TripTime                *Quantity `json:"tripTime"`
+	TripTimePassCriterion   *Quantity `json:"tripTimePassCriterion"`
+	TripThresholdAccuracy   *Quantity `json:"tripThresholdAccuracy"`
+	TripTimeAccuracy        *Quantity `json:"tripTimeAccuracy"`
+	MeasurementAtTrip       *Quantity `json:"measurementAtTrip"`
+	MeasurementAccuracy     *Quantity `json:"measurementAccuracy"`
+	MeasurementTimeAccuracy *Quantity `json:"measurementTimeAccuracy"`
+	Deviation               *Quantity `json:"deviation"`
+	RampStepSize            *Quantity `json:"rampStepSize"`
+	DeviationPassCriterion  *Quantity `json:"deviationPassCriterion"`
+}
+
+// Quantity is a measured or configured value with its unit.
+type Quantity struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// Timestamp reads the gateway's test timestamps, which arrive as RFC 3339
+// strings or as unix seconds or milliseconds. It holds unix seconds, or zero
+// if the test has not run.
+type Timestamp int64
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*t = Timestamp(normalizeUnix(int64(v)))
+	case string:
+		if v == "" {
+			*t = 0
+		} else if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			*t = Timestamp(normalizeUnix(n))
+		} else if ts, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			*t = Timestamp(ts.Unix())
+		}
+	default:
+		*t = 0
+	}
+	return nil
+}
+
+// normalizeUnix converts millisecond timestamps to seconds.
+func normalizeUnix(n int64) int64 {
+	if n > 1e12 {
+		return n / 1000
+	}
+	return n
+}
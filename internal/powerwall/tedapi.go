@@ -2,6 +2,7 @@ package powerwall
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
@@ -21,7 +22,7 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-func (p *PowerwallGateway) GetConfig() *string {
+func (p *PowerwallGateway) GetConfig(ctx context.Context) *string {
 	pm := &ParentMessage{
 		Message: &MessageEnvelope{
 			Payload: &MessageEnvelope_Filestore{
@@ -51,7 +52,7 @@ func (p *PowerwallGateway) GetConfig() *string {
 		},
 	}
 	reqbody, err := proto.Marshal(pm)
-	_, resp, err := p.makeTedRequest(bytes.NewBuffer(reqbody))
+	_, resp, err := p.makeTedRequest(ctx, bytes.NewBuffer(reqbody))
 	if err != nil {
 		p.logger.Error("Failed to get config", zap.Error(err))
 		return nil
@@ -73,7 +74,7 @@ func (p *PowerwallGateway) GetConfig() *string {
 	return &res
 }
 
-func (p *PowerwallGateway) RunQuery(query string, params *string) *string {
+func (p *PowerwallGateway) RunQuery(ctx context.Context, query string, params *string) *string {
 	var reqbody string
 	queryObj := queries.GetQuery(query)
 	if queryObj == nil {
@@ -128,7 +129,7 @@ func (p *PowerwallGateway) RunQuery(query string, params *string) *string {
 		p.logger.Error("Failed to marshal query message", zap.Error(err))
 		return nil
 	}
-	_, resp, err := p.makeTedRequest(bytes.NewBuffer(body))
+	_, resp, err := p.makeTedRequest(ctx, bytes.NewBuffer(body))
 	if err != nil {
 		p.logger.Error("Failed to run query", zap.Error(err), zap.String("query", query))
 		return nil
@@ -157,7 +158,7 @@ type CheckResult struct {
 // ConnectivityCheck runs a series of probes for the given connection mode and
 // returns one CheckResult per check. Checks run in order; later checks are
 // skipped when earlier ones fail.
-func (p *PowerwallGateway) ConnectivityCheck(mode config.ConnectionMode) []CheckResult {
+func (p *PowerwallGateway) ConnectivityCheck(ctx context.Context, mode config.ConnectionMode) []CheckResult {
 	prev := p.connectionMode
 	p.connectionMode = mode
 	defer func() { p.connectionMode = prev }()
@@ -187,7 +188,7 @@ func (p *PowerwallGateway) ConnectivityCheck(mode config.ConnectionMode) []Check
 		Tail: &Tail{Value: 1},
 	}
 	pmBytes, _ := proto.Marshal(minPM)
-	status, _, authErr := p.makeTedRequest(bytes.NewBuffer(pmBytes))
+	status, _, authErr := p.makeTedRequest(ctx, bytes.NewBuffer(pmBytes))
 	switch {
 	case authErr != nil:
 		results = append(results, CheckResult{Name: "auth", OK: false, Message: authErr.Error()})
@@ -202,7 +203,7 @@ func (p *PowerwallGateway) ConnectivityCheck(mode config.ConnectionMode) []Check
 	}
 
 	// 3. Config pull — exercises the full protobuf request/response pipeline.
-	cfg := p.GetConfig()
+	cfg := p.GetConfig(ctx)
 	if cfg == nil || *cfg == "" {
 		results = append(results, CheckResult{Name: "config", OK: false, Message: "no config returned"})
 	} else {
@@ -231,7 +232,7 @@ func (p *PowerwallGateway) getDin() *string {
 	return &res
 }
 
-func (p *PowerwallGateway) makeTedRequest(body io.Reader) (int, []byte, error) {
+func (p *PowerwallGateway) makeTedRequest(ctx context.Context, body io.Reader) (int, []byte, error) {
 	var reqBody io.Reader = body
 	path := "v1"
 	if p.connectionMode == config.ConnectionModeLan {
@@ -243,7 +244,7 @@ func (p *PowerwallGateway) makeTedRequest(body io.Reader) (int, []byte, error) {
 		reqBody = wrapped
 	}
 
-	req, err := http.NewRequest("POST", p.Endpoint.JoinPath("tedapi", path).String(), reqBody)
+	req, err := http.NewRequestWithContext(ctx, "POST", p.Endpoint.JoinPath("tedapi", path).String(), reqBody)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -251,7 +252,7 @@ func (p *PowerwallGateway) makeTedRequest(body io.Reader) (int, []byte, error) {
 	if p.connectionMode != config.ConnectionModeLan {
 		req.SetBasicAuth("Tesla_Energy_Device", p.password)
 	}
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(req)
 	if err != nil {
 		return 0, nil, err
 	}
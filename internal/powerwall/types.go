@@ -4,6 +4,7 @@ import (
 	"crypto/rsa"
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/ygelfand/power-dash/internal/config"
 	"go.uber.org/zap"
@@ -13,6 +14,7 @@ import (
 type PowerwallGateway struct {
 	Endpoint       *url.URL
 	password       string
+	authMu         sync.RWMutex // guards authToken and userRecord
 	authToken      string
 	userRecord     string
 	httpClient     *http.Client
@@ -22,6 +24,7 @@ type PowerwallGateway struct {
 	logger         *zap.Logger
 	connectionMode config.ConnectionMode
 	privateKey     *rsa.PrivateKey
+	breaker        *Breaker
 }
 
 type loginResponse struct {
@@ -1,12 +1,13 @@
 package powerwall
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 )
 
-func (p *PowerwallGateway) FetchController() (*DeviceControllerResponse, error) {
-	res := p.RunQuery("DeviceControllerQuery", nil)
+func (p *PowerwallGateway) FetchController(ctx context.Context) (*DeviceControllerResponse, error) {
+	res := p.RunQuery(ctx, "DeviceControllerQuery", nil)
 	if res == nil {
 		return nil, fmt.Errorf("failed to run query")
 	}
@@ -18,8 +19,73 @@ func (p *PowerwallGateway) FetchController() (*DeviceControllerResponse, error)
 	return &controller, nil
 }
 
-func (p *PowerwallGateway) FetchConfig() (*ConfigResponse, error) {
-	res := p.GetConfig()
+func (p *PowerwallGateway) FetchComponents(ctx context.Context) (*ComponentsResponse, error) {
+	res := p.RunQuery(ctx, "ComponentsQuery", nil)
+	if res == nil {
+		return nil, fmt.Errorf("failed to run query")
+	}
+	var components ComponentsResponse
+	err := json.Unmarshal([]byte(*res), &components)
+	if err != nil {
+		return nil, err
+	}
+	return &components, nil
+}
+
+func (p *PowerwallGateway) FetchWallbox(ctx context.Context) (*WallboxResponse, error) {
+	res := p.RunQuery(ctx, "WallboxComponentsQuery", nil)
+	if res == nil {
+		return nil, fmt.Errorf("failed to run query")
+	}
+	var wallbox WallboxResponse
+	err := json.Unmarshal([]byte(*res), &wallbox)
+	if err != nil {
+		return nil, err
+	}
+	return &wallbox, nil
+}
+
+func (p *PowerwallGateway) FetchSelfTests(ctx context.Context) (*SelfTestResponse, error) {
+	res := p.RunQuery(ctx, "SelfTestQuery", nil)
+	if res == nil {
+		return nil, fmt.Errorf("failed to run query")
+	}
+	var tests SelfTestResponse
+	err := json.Unmarshal([]byte(*res), &tests)
+	if err != nil {
+		return nil, err
+	}
+	return &tests, nil
+}
+
+func (p *PowerwallGateway) FetchProtectionTrips(ctx context.Context) (*ProtectionTripResponse, error) {
+	res := p.RunQuery(ctx, "ProtectionTripQuery", nil)
+	if res == nil {
+		return nil, fmt.Errorf("failed to run query")
+	}
+	var trips ProtectionTripResponse
+	err := json.Unmarshal([]byte(*res), &trips)
+	if err != nil {
+		return nil, err
+	}
+	return &trips, nil
+}
+
+func (p *PowerwallGateway) FetchIEEE20305(ctx context.Context) (*IEEE20305Response, error) {
+	res := p.RunQuery(ctx, "IE2030Query", nil)
+	if res == nil {
+		return nil, fmt.Errorf("failed to run query")
+	}
+	var state IEEE20305Response
+	err := json.Unmarshal([]byte(*res), &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (p *PowerwallGateway) FetchConfig(ctx context.Context) (*ConfigResponse, error) {
+	res := p.GetConfig(ctx)
 	if res == nil {
 		return nil, fmt.Errorf("failed to run GetConfig query")
 	}
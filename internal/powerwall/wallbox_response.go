@@ -0,0 +1,72 @@
+package powerwall
+
+// WallboxResponse is the result of WallboxComponentsQuery. Each Wall
+// Connector family reports the same signals under its own prefix, e.g.
+// WASP_ChargePower. The query's pch group holds the Powerwall 3 units, not
+// connectors, so it is not decoded.
+type WallboxResponse struct {
+	Components struct {
+		Root struct {
+			Signals []ComponentSignal `json:"signals"`
+		} `json:"root"`
+		Soda []Component `json:"soda,omitempty"`
+		Wasp []Component `json:"wasp,omitempty"`
+		Stip []Component `json:"stip,omitempty"`
+	} `json:"components"`
+}
+
+// WallConnector is one Wall Connector found in a WallboxResponse.
+type WallConnector struct {
+	// Family is the component group it was reported under: soda, wasp or
+	// stip.
+	Family string
+	// Index counts connectors within Family.
+	Index int
+	Component
+	prefix string
+}
+
+// Connectors returns every Wall Connector in the response.
+func (r *WallboxResponse) Connectors() []WallConnector {
+	var out []WallConnector
+	add := func(family, prefix string, comps []Component) {
+		for i, c := range comps {
+			out = append(out, WallConnector{Family: family, Index: i, Component: c, prefix: prefix})
+		}
+	}
+	add("soda", "SODA_", r.Components.Soda)
+	add("wasp", "WASP_", r.Components.Wasp)
+	add("stip", "STIP_", r.Components.Stip)
+	return out
+}
+
+// ChargePower returns the power delivered to the vehicle in watts.
+func (w WallConnector) ChargePower() *float64 {
+	return w.Value(w.prefix + "ChargePower")
+}
+
+// SessionEnergy returns the energy delivered in the current session in Wh.
+func (w WallConnector) SessionEnergy() *float64 {
+	return w.Value(w.prefix + "SessionEnergy")
+}
+
+// VehicleConnected reports whether a vehicle is plugged in, and false for ok
+// if the connector did not report it.
+func (w WallConnector) VehicleConnected() (connected, ok bool) {
+	s, found := w.Signal(w.prefix + "VehicleConnected")
+	if !found {
+		return false, false
+	}
+	if b, isBool := s.BoolValue.(bool); isBool {
+		return b, true
+	}
+	if s.Value != nil {
+		return *s.Value != 0, true
+	}
+	return false, false
+}
+
+// State returns the connector's reported state, or "".
+func (w WallConnector) State() string {
+	return w.Text(w.prefix + "State")
+}
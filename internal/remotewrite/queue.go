@@ -0,0 +1,176 @@
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const queueExt = ".pb.snappy"
+
+// queue stores snappy-compressed WriteRequests as one file per batch. File
+// names sort in the order the batches were queued.
+type queue struct {
+	dir   string
+	mu    sync.Mutex
+	seq   int64
+	ready chan struct{}
+}
+
+func newQueue(dir string) (*queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create remote write queue: %w", err)
+	}
+	// Leftovers of an interrupted push are incomplete.
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	for _, f := range tmps {
+		_ = os.Remove(f)
+	}
+	return &queue{
+		dir:   dir,
+		seq:   time.Now().UnixNano(),
+		ready: make(chan struct{}, 1),
+	}, nil
+}
+
+func (q *queue) push(data []byte) error {
+	q.mu.Lock()
+	q.seq++
+	name := filepath.Join(q.dir, fmt.Sprintf("%020d%s", q.seq, queueExt))
+	q.mu.Unlock()
+
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (q *queue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// list returns the queued batch files, oldest first.
+func (q *queue) list() []string {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), queueExt) {
+			files = append(files, filepath.Join(q.dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (q *queue) len() int {
+	return len(q.list())
+}
+
+func (q *queue) remove(files []string) {
+	for _, f := range files {
+		_ = os.Remove(f)
+	}
+}
+
+// trim drops the oldest batches beyond max and returns how many were dropped.
+func (q *queue) trim(max int) int {
+	files := q.list()
+	if len(files) <= max {
+		return 0
+	}
+	drop := files[:len(files)-max]
+	q.remove(drop)
+	return len(drop)
+}
+
+// merge combines queued batches into a single snappy-compressed request and
+// returns it with the files it holds. Files that cannot be read or decoded are
+// left out and reported in the error.
+func (q *queue) merge(files []string) ([]byte, []string, error) {
+	if len(files) == 1 {
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filepath.Base(files[0]), err)
+		}
+		return data, files, nil
+	}
+
+	index := make(map[string]int)
+	merged := &prompb.WriteRequest{}
+	var (
+		read []string
+		errs []error
+	)
+	for _, f := range files {
+		req, err := readBatch(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(f), err))
+			continue
+		}
+		read = append(read, f)
+		for _, ts := range req.Timeseries {
+			key := seriesKey(ts.Labels)
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(merged.Timeseries)
+				merged.Timeseries = append(merged.Timeseries, ts)
+				continue
+			}
+			merged.Timeseries[i].Samples = append(merged.Timeseries[i].Samples, ts.Samples...)
+		}
+	}
+	if len(read) == 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	for i := range merged.Timeseries {
+		sortSamples(merged.Timeseries[i].Samples)
+	}
+
+	data, err := merged.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	return snappy.Encode(nil, data), read, errors.Join(errs...)
+}
+
+func readBatch(file string) (*prompb.WriteRequest, error) {
+	compressed, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+	req := &prompb.WriteRequest{}
+	if err := req.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func seriesKey(lbls []prompb.Label) string {
+	var sb strings.Builder
+	for _, l := range lbls {
+		sb.WriteString(l.Name)
+		sb.WriteByte(0)
+		sb.WriteString(l.Value)
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
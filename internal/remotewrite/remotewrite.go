@@ -0,0 +1,314 @@
+// Package remotewrite forwards samples committed to the local store to a
+// Prometheus remote-write endpoint through a persistent on-disk queue.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultFlushInterval = 5 * time.Second
+	defaultTimeout       = 30 * time.Second
+	defaultMaxQueueFiles = 20000
+	// maxFilesPerRequest bounds how many queued batches are merged into one request.
+	maxFilesPerRequest = 20
+	// maxPendingSamples triggers an early flush to disk.
+	maxPendingSamples = 10000
+	minBackoff        = time.Second
+	maxBackoff        = 5 * time.Minute
+)
+
+type Config struct {
+	URL         string
+	Username    string
+	Password    string
+	BearerToken string
+	Headers     map[string]string
+	// ExtraLabels are added to every series that does not already carry them.
+	ExtraLabels map[string]string
+	// QueueDir holds batches that have not been delivered yet.
+	QueueDir      string
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	// MaxQueueFiles caps the queue; the oldest batches are dropped beyond it.
+	MaxQueueFiles int
+}
+
+// Writer is a store.Sink that queues samples on disk and ships them to a
+// remote-write endpoint, retrying with exponential backoff.
+type Writer struct {
+	cfg    Config
+	client *http.Client
+	queue  *queue
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	pending []store.Sample
+
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func New(cfg Config, logger *zap.Logger) (*Writer, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("remote write url is required")
+	}
+	if cfg.QueueDir == "" {
+		return nil, errors.New("remote write queue directory is required")
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxQueueFiles <= 0 {
+		cfg.MaxQueueFiles = defaultMaxQueueFiles
+	}
+	q, err := newQueue(cfg.QueueDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		queue:   q,
+		logger:  logger.With(zap.String("component", "remote-write")),
+		flushCh: make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}, nil
+}
+
+// Write implements store.Sink.
+func (w *Writer) Write(samples []store.Sample) {
+	w.mu.Lock()
+	for _, s := range samples {
+		w.pending = append(w.pending, store.Sample{Labels: w.withExtraLabels(s.Labels), T: s.T, V: s.V})
+	}
+	full := len(w.pending) >= maxPendingSamples
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *Writer) withExtraLabels(lset labels.Labels) labels.Labels {
+	if len(w.cfg.ExtraLabels) == 0 {
+		return lset
+	}
+	b := labels.NewBuilder(lset)
+	for k, v := range w.cfg.ExtraLabels {
+		if !lset.Has(k) {
+			b.Set(k, v)
+		}
+	}
+	return b.Labels()
+}
+
+// Start launches the flush and send loops.
+func (w *Writer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.logger.Info("Starting remote write", zap.String("url", w.cfg.URL), zap.Int("queued_batches", w.queue.len()))
+	w.wg.Add(2)
+	go w.flushLoop()
+	go w.sendLoop(ctx)
+}
+
+// Stop persists any buffered samples and stops sending. Undelivered batches
+// stay on disk and are sent after the next start.
+func (w *Writer) Stop() {
+	close(w.stopCh)
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.wg.Wait()
+	w.flush()
+}
+
+func (w *Writer) flushLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.flush()
+		case <-w.flushCh:
+			w.flush()
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// flush moves the buffered samples into the on-disk queue.
+func (w *Writer) flush() {
+	w.mu.Lock()
+	samples := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if len(samples) == 0 {
+		return
+	}
+
+	req := buildRequest(samples)
+	data, err := req.Marshal()
+	if err != nil {
+		w.logger.Error("Failed to encode remote write batch", zap.Error(err))
+		return
+	}
+	if err := w.queue.push(snappy.Encode(nil, data)); err != nil {
+		w.logger.Error("Failed to queue remote write batch", zap.Int("samples", len(samples)), zap.Error(err))
+		return
+	}
+	if dropped := w.queue.trim(w.cfg.MaxQueueFiles); dropped > 0 {
+		w.logger.Warn("Remote write queue full, dropped oldest batches", zap.Int("dropped", dropped))
+	}
+	w.queue.notify()
+}
+
+func (w *Writer) sendLoop(ctx context.Context) {
+	defer w.wg.Done()
+	backoff := minBackoff
+	for {
+		files := w.queue.list()
+		if len(files) == 0 {
+			select {
+			case <-w.queue.ready:
+				continue
+			case <-w.stopCh:
+				return
+			}
+		}
+		files = files[:min(len(files), maxFilesPerRequest)]
+
+		body, read, err := w.queue.merge(files)
+		if err != nil {
+			w.logger.Error("Dropping unreadable remote write batches", zap.Error(err))
+			w.queue.remove(slices.DeleteFunc(files, func(f string) bool { return slices.Contains(read, f) }))
+		}
+		if len(read) == 0 {
+			continue
+		}
+		files = read
+
+		err = w.send(ctx, body)
+		if err == nil {
+			w.queue.remove(files)
+			backoff = minBackoff
+			continue
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			w.logger.Error("Remote write rejected batch, dropping it", zap.Int("batches", len(files)), zap.Error(err))
+			w.queue.remove(files)
+			continue
+		}
+
+		w.logger.Warn("Remote write failed, retrying", zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-time.After(backoff):
+		case <-w.stopCh:
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// permanentError is a rejection that retrying will not fix, such as a 400.
+type permanentError struct {
+	status string
+	body   string
+}
+
+func (e *permanentError) Error() string {
+	return fmt.Sprintf("server returned %s: %s", e.status, e.body)
+}
+
+func (w *Writer) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{status: "invalid request", body: err.Error()}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "power-dash")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if w.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+w.cfg.BearerToken)
+	} else if w.cfg.Username != "" {
+		req.SetBasicAuth(w.cfg.Username, w.cfg.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+		return err
+	}
+	return &permanentError{status: resp.Status, body: strings.TrimSpace(string(msg))}
+}
+
+// buildRequest groups samples by series, keeping each series' samples in
+// timestamp order as remote-write receivers require.
+func buildRequest(samples []store.Sample) *prompb.WriteRequest {
+	index := make(map[uint64]int)
+	req := &prompb.WriteRequest{}
+	for _, s := range samples {
+		h := s.Labels.Hash()
+		i, ok := index[h]
+		if !ok {
+			i = len(req.Timeseries)
+			index[h] = i
+			ts := prompb.TimeSeries{}
+			s.Labels.Range(func(l labels.Label) {
+				ts.Labels = append(ts.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+			})
+			req.Timeseries = append(req.Timeseries, ts)
+		}
+		req.Timeseries[i].Samples = append(req.Timeseries[i].Samples, prompb.Sample{Value: s.V, Timestamp: s.T})
+	}
+	for i := range req.Timeseries {
+		sortSamples(req.Timeseries[i].Samples)
+	}
+	return req
+}
+
+func sortSamples(samples []prompb.Sample) {
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+}
@@ -0,0 +1,182 @@
+package remotewrite
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/ygelfand/power-dash/internal/store"
+	"go.uber.org/zap"
+)
+
+// receiver is a remote-write endpoint answering with the queued status codes,
+// then 204, and counting the samples it accepted.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	samples  int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var wr prompb.WriteRequest
+	if err := wr.Unmarshal(data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	if status/100 == 2 {
+		for _, ts := range wr.Timeseries {
+			r.samples += len(ts.Samples)
+		}
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) counts() (requests, samples int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests, r.samples
+}
+
+func newTestWriter(t *testing.T, url, dir string) *Writer {
+	t.Helper()
+	w, err := New(Config{URL: url, QueueDir: dir, FlushInterval: 10 * time.Millisecond}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return w
+}
+
+func testSamples(n int) []store.Sample {
+	samples := make([]store.Sample, n)
+	for i := range samples {
+		samples[i] = store.Sample{Labels: labels.FromStrings(labels.MetricName, "power_watts", "site", "load"), T: int64(1000 * i), V: float64(i)}
+	}
+	return samples
+}
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriterRetriesServerErrors(t *testing.T) {
+	rcv := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	w := newTestWriter(t, srv.URL, t.TempDir())
+	w.Start()
+	defer w.Stop()
+
+	w.Write(testSamples(5))
+	waitFor(t, "delivery", func() bool { _, n := rcv.counts(); return n == 5 })
+	if requests, _ := rcv.counts(); requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+	waitFor(t, "empty queue", func() bool { return w.queue.len() == 0 })
+}
+
+func TestWriterDropsRejectedBatches(t *testing.T) {
+	rcv := &receiver{statuses: []int{http.StatusBadRequest}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	w := newTestWriter(t, srv.URL, t.TempDir())
+	w.Start()
+	defer w.Stop()
+
+	w.Write(testSamples(5))
+	waitFor(t, "rejection", func() bool { n, _ := rcv.counts(); return n == 1 })
+	waitFor(t, "empty queue", func() bool { return w.queue.len() == 0 })
+
+	// Later batches still go through.
+	w.Write(testSamples(3))
+	waitFor(t, "delivery", func() bool { _, n := rcv.counts(); return n == 3 })
+	if requests, _ := rcv.counts(); requests != 2 {
+		t.Errorf("got %d requests, want 2", requests)
+	}
+}
+
+func TestWriterReplaysQueueAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWriter(t, "http://127.0.0.1:1/unused", dir)
+	w.Write(testSamples(7))
+	w.Stop()
+	if n := w.queue.len(); n != 1 {
+		t.Fatalf("%d batches queued on stop, want 1", n)
+	}
+
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	w = newTestWriter(t, srv.URL, dir)
+	w.Start()
+	defer w.Stop()
+	waitFor(t, "replay", func() bool { _, n := rcv.counts(); return n == 7 })
+	waitFor(t, "empty queue", func() bool { return w.queue.len() == 0 })
+}
+
+func TestWriterSkipsCorruptQueueFiles(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newQueue(dir)
+	if err != nil {
+		t.Fatalf("newQueue: %v", err)
+	}
+	for range 2 {
+		data, err := buildRequest(testSamples(4)).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.push(snappy.Encode(nil, data)); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	corrupt := filepath.Join(dir, "00000000000000000001"+queueExt)
+	if err := os.WriteFile(corrupt, []byte("not a batch"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	w := newTestWriter(t, srv.URL, dir)
+	w.Start()
+	defer w.Stop()
+
+	waitFor(t, "delivery", func() bool { _, n := rcv.counts(); return n == 8 })
+	waitFor(t, "empty queue", func() bool { return w.queue.len() == 0 })
+	if _, err := os.Stat(corrupt); !os.IsNotExist(err) {
+		t.Errorf("corrupt batch still queued: %v", err)
+	}
+	if requests, _ := rcv.counts(); requests != 1 {
+		t.Errorf("got %d requests, want the readable batches merged into 1", requests)
+	}
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	alertEpisodesFile = "alert_episodes.json"
+	// maxAlertEpisodes bounds the history; the oldest ended episodes go first.
+	maxAlertEpisodes = 10000
+)
+
+// AlertEpisode is one continuous period during which an alert was active.
+// Start and End are unix seconds; End is zero while the alert is active.
+type AlertEpisode struct {
+	Source string `json:"source"`
+	Name   string `json:"name"`
+	Start  int64  `json:"start"`
+	End    int64  `json:"end,omitempty"`
+	// Duration in seconds, up to now for active episodes. Filled in by AlertEpisodes.
+	Duration int64 `json:"duration"`
+	Active   bool  `json:"active"`
+}
+
+// AlertEpisodeFilter selects episodes overlapping [Start, End] seconds. Zero
+// bounds are open; empty Source and Name match any.
+type AlertEpisodeFilter struct {
+	Source     string
+	Name       string
+	Start      int64
+	End        int64
+	ActiveOnly bool
+	// Limit caps the result to the newest episodes; zero returns all.
+	Limit int
+}
+
+func (e *engine) loadAlertEpisodes() {
+	data, err := os.ReadFile(e.alertsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			e.logger.Warn("Failed to read alert episodes", zap.Error(err))
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &e.alertEpisodes); err != nil {
+		e.logger.Warn("Failed to parse alert episodes", zap.Error(err))
+	}
+}
+
+func (e *engine) saveAlertEpisodesTo(path string) error {
+	e.alertMu.Lock()
+	defer e.alertMu.Unlock()
+	return writeJSONFile(path, e.alertEpisodes)
+}
+
+// saveAlertEpisodes persists the episodes when the storage has a path for
+// them. alertMu must be held, so concurrent collectors save in order.
+func (e *engine) saveAlertEpisodes() error {
+	if e.alertsPath == "" {
+		return nil
+	}
+	return writeJSONFile(e.alertsPath, e.alertEpisodes)
+}
+
+// OpenAlertEpisode records that an alert became active at start.
+func (e *engine) OpenAlertEpisode(source, name string, start time.Time) error {
+	e.alertMu.Lock()
+	defer e.alertMu.Unlock()
+	for _, ep := range e.alertEpisodes {
+		if ep.Source == source && ep.Name == name && ep.End == 0 {
+			return fmt.Errorf("alert %s/%s is already active", source, name)
+		}
+	}
+	e.alertEpisodes = append(e.alertEpisodes, AlertEpisode{Source: source, Name: name, Start: start.Unix()})
+	e.pruneAlertEpisodes()
+	return e.saveAlertEpisodes()
+}
+
+// CloseAlertEpisode ends the active episode of an alert at end.
+func (e *engine) CloseAlertEpisode(source, name string, end time.Time) error {
+	e.alertMu.Lock()
+	defer e.alertMu.Unlock()
+	for i := range e.alertEpisodes {
+		ep := &e.alertEpisodes[i]
+		if ep.Source == source && ep.Name == name && ep.End == 0 {
+			ep.End = max(end.Unix(), ep.Start)
+			return e.saveAlertEpisodes()
+		}
+	}
+	return fmt.Errorf("alert %s/%s is not active", source, name)
+}
+
+// pruneAlertEpisodes drops the oldest ended episodes beyond maxAlertEpisodes.
+// alertMu must be held.
+func (e *engine) pruneAlertEpisodes() {
+	excess := len(e.alertEpisodes) - maxAlertEpisodes
+	if excess <= 0 {
+		return
+	}
+	kept := e.alertEpisodes[:0]
+	for _, ep := range e.alertEpisodes {
+		if excess > 0 && ep.End != 0 {
+			excess--
+			continue
+		}
+		kept = append(kept, ep)
+	}
+	e.alertEpisodes = kept
+}
+
+// AlertEpisodes returns the episodes matching f, newest first.
+func (e *engine) AlertEpisodes(f AlertEpisodeFilter) []AlertEpisode {
+	now := time.Now().Unix()
+	e.alertMu.Lock()
+	defer e.alertMu.Unlock()
+
+	result := []AlertEpisode{}
+	for _, ep := range e.alertEpisodes {
+		end := ep.End
+		if end == 0 {
+			end = now
+		}
+		switch {
+		case f.Source != "" && ep.Source != f.Source,
+			f.Name != "" && ep.Name != f.Name,
+			f.ActiveOnly && ep.End != 0,
+			f.Start != 0 && end < f.Start,
+			f.End != 0 && ep.Start > f.End:
+			continue
+		}
+		ep.Active = ep.End == 0
+		ep.Duration = end - ep.Start
+		result = append(result, ep)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Start > result[j].Start })
+	if f.Limit > 0 && len(result) > f.Limit {
+		result = result[:f.Limit]
+	}
+	return result
+}
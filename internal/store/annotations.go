@@ -0,0 +1,223 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	annotationsFile = "annotations.json"
+	// maxAnnotationText bounds the text of a single annotation in bytes.
+	maxAnnotationText = 4096
+)
+
+var (
+	// ErrAnnotationNotFound is returned for an unknown annotation ID.
+	ErrAnnotationNotFound = errors.New("annotation not found")
+	// ErrInvalidAnnotation wraps validation failures of an added or updated annotation.
+	ErrInvalidAnnotation = errors.New("invalid annotation")
+)
+
+// Annotation is a user note about an event, such as an outage or a new
+// Powerwall, at Time or spanning [Time, End]. Times are unix seconds; End is
+// zero for a point in time.
+type Annotation struct {
+	ID      string   `json:"id"`
+	Time    int64    `json:"time"`
+	End     int64    `json:"end,omitempty"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags"`
+	Created int64    `json:"created"`
+	Updated int64    `json:"updated"`
+}
+
+// AnnotationFilter selects annotations overlapping [Start, End] seconds. Zero
+// bounds are open. Annotations must carry every tag in Tags.
+type AnnotationFilter struct {
+	Start int64
+	End   int64
+	Tags  []string
+	// Limit caps the result to the newest annotations; zero returns all.
+	Limit int
+}
+
+// normalize validates a and cleans up its text and tags.
+func (a *Annotation) normalize() error {
+	a.Text = strings.TrimSpace(a.Text)
+	switch {
+	case a.Time <= 0:
+		return fmt.Errorf("%w: time is required", ErrInvalidAnnotation)
+	case a.End != 0 && a.End < a.Time:
+		return fmt.Errorf("%w: end must not be before time", ErrInvalidAnnotation)
+	case a.Text == "":
+		return fmt.Errorf("%w: text is required", ErrInvalidAnnotation)
+	case len(a.Text) > maxAnnotationText:
+		return fmt.Errorf("%w: text is longer than %d bytes", ErrInvalidAnnotation, maxAnnotationText)
+	}
+	seen := make(map[string]struct{}, len(a.Tags))
+	tags := []string{}
+	for _, t := range a.Tags {
+		t = strings.TrimSpace(t)
+		if _, ok := seen[t]; t == "" || ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	a.Tags = tags
+	return nil
+}
+
+func (a *Annotation) matches(f AnnotationFilter) bool {
+	end := a.End
+	if end == 0 {
+		end = a.Time
+	}
+	if f.Start != 0 && end < f.Start || f.End != 0 && a.Time > f.End {
+		return false
+	}
+	for _, t := range f.Tags {
+		i := sort.SearchStrings(a.Tags, t)
+		if i == len(a.Tags) || a.Tags[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *engine) loadAnnotations() {
+	data, err := os.ReadFile(e.annotationsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			e.logger.Warn("Failed to read annotations", zap.Error(err))
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &e.annotations); err != nil {
+		e.logger.Warn("Failed to parse annotations", zap.Error(err))
+	}
+}
+
+func (e *engine) saveAnnotationsTo(path string) error {
+	e.annotationMu.Lock()
+	defer e.annotationMu.Unlock()
+	return writeJSONFile(path, e.annotations)
+}
+
+// saveAnnotations persists the annotations when the storage has a path for
+// them. annotationMu must be held, so saves land in the order of the changes.
+func (e *engine) saveAnnotations() error {
+	if e.annotationsPath == "" {
+		return nil
+	}
+	return writeJSONFile(e.annotationsPath, e.annotations)
+}
+
+// AddAnnotation stores a new annotation and returns it with its ID and
+// timestamps filled in.
+func (e *engine) AddAnnotation(a Annotation) (Annotation, error) {
+	if err := a.normalize(); err != nil {
+		return Annotation{}, err
+	}
+	now := time.Now().Unix()
+	a.ID = ulid.Make().String()
+	a.Created, a.Updated = now, now
+
+	e.annotationMu.Lock()
+	defer e.annotationMu.Unlock()
+	e.annotations = append(e.annotations, a)
+	if err := e.saveAnnotations(); err != nil {
+		e.annotations = e.annotations[:len(e.annotations)-1]
+		return Annotation{}, err
+	}
+	return a, nil
+}
+
+// UpdateAnnotation replaces the time, end, text and tags of annotation id.
+func (e *engine) UpdateAnnotation(id string, a Annotation) (Annotation, error) {
+	if err := a.normalize(); err != nil {
+		return Annotation{}, err
+	}
+	e.annotationMu.Lock()
+	defer e.annotationMu.Unlock()
+	i := e.annotationIndex(id)
+	if i < 0 {
+		return Annotation{}, ErrAnnotationNotFound
+	}
+	prev := e.annotations[i]
+	cur := &e.annotations[i]
+	cur.Time, cur.End, cur.Text, cur.Tags = a.Time, a.End, a.Text, a.Tags
+	cur.Updated = time.Now().Unix()
+	if err := e.saveAnnotations(); err != nil {
+		e.annotations[i] = prev
+		return Annotation{}, err
+	}
+	return *cur, nil
+}
+
+// DeleteAnnotation removes annotation id.
+func (e *engine) DeleteAnnotation(id string) error {
+	e.annotationMu.Lock()
+	defer e.annotationMu.Unlock()
+	i := e.annotationIndex(id)
+	if i < 0 {
+		return ErrAnnotationNotFound
+	}
+	prev := e.annotations
+	e.annotations = slices.Delete(slices.Clone(e.annotations), i, i+1)
+	if err := e.saveAnnotations(); err != nil {
+		e.annotations = prev
+		return err
+	}
+	return nil
+}
+
+// Annotation returns annotation id.
+func (e *engine) Annotation(id string) (Annotation, error) {
+	e.annotationMu.Lock()
+	defer e.annotationMu.Unlock()
+	i := e.annotationIndex(id)
+	if i < 0 {
+		return Annotation{}, ErrAnnotationNotFound
+	}
+	return e.annotations[i], nil
+}
+
+// annotationIndex returns the position of annotation id, or -1.
+// annotationMu must be held.
+func (e *engine) annotationIndex(id string) int {
+	for i := range e.annotations {
+		if e.annotations[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Annotations returns the annotations matching f, newest first.
+func (e *engine) Annotations(f AnnotationFilter) []Annotation {
+	e.annotationMu.Lock()
+	defer e.annotationMu.Unlock()
+
+	result := []Annotation{}
+	for _, a := range e.annotations {
+		if a.matches(f) {
+			result = append(result, a)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time > result[j].Time })
+	if f.Limit > 0 && len(result) > f.Limit {
+		result = result[:f.Limit]
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+	"time"
+	// Timezones must resolve on hosts without a zoneinfo database.
+	_ "time/tzdata"
+)
+
+// Calendar units accepted in Step.Unit.
+const (
+	UnitDay   = "day"
+	UnitWeek  = "week"
+	UnitMonth = "month"
+	UnitYear  = "year"
+)
+
+// Step sizes the buckets of an aggregated query. Fixed steps of Seconds are
+// aligned to midnight in Location. A calendar Unit instead follows local days,
+// weeks starting on Monday, months or years, so buckets spanning a DST change
+// are 23 or 25 hours long per day. A nil Location uses the store's timezone.
+type Step struct {
+	Seconds  int64
+	Unit     string
+	Location *time.Location
+}
+
+// IsZero reports whether st selects raw samples.
+func (st Step) IsZero() bool {
+	return st.Unit == "" && st.Seconds <= 0
+}
+
+// ParseStep builds a Step from a request's step in seconds, calendar unit and
+// IANA timezone name. The unit takes precedence over seconds, and an empty
+// timezone leaves the store's in effect.
+func ParseStep(seconds int64, unit, timezone string) (Step, error) {
+	st := Step{Seconds: seconds, Unit: unit}
+	switch unit {
+	case "", UnitDay, UnitWeek, UnitMonth, UnitYear:
+	default:
+		return st, fmt.Errorf("unknown unit %q (expected day, week, month or year)", unit)
+	}
+	if seconds < 0 {
+		return st, fmt.Errorf("step must not be negative")
+	}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return st, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+		st.Location = loc
+	}
+	return st, nil
+}
+
+// SetLocation sets the timezone buckets are aligned in when a query does not
+// name one, normally the site's timezone from the gateway config.
+func (e *engine) SetLocation(loc *time.Location) {
+	if loc != nil {
+		e.location.Store(loc)
+	}
+}
+
+// Location returns the store's timezone, time.Local until SetLocation is called.
+func (e *engine) Location() *time.Location {
+	if loc := e.location.Load(); loc != nil {
+		return loc
+	}
+	return time.Local
+}
+
+// bucketer aligns timestamps in seconds to the buckets of a Step.
+type bucketer struct {
+	step int64
+	unit string
+	loc  *time.Location
+}
+
+func (e *engine) bucketer(st Step) bucketer {
+	loc := st.Location
+	if loc == nil {
+		loc = e.Location()
+	}
+	return bucketer{step: st.Seconds, unit: st.Unit, loc: loc}
+}
+
+// start returns the start of the bucket containing tSec.
+func (b bucketer) start(tSec int64) int64 {
+	if b.unit == "" {
+		_, offset := time.Unix(tSec, 0).In(b.loc).Zone()
+		return ((tSec+int64(offset))/b.step)*b.step - int64(offset)
+	}
+	t := time.Unix(tSec, 0).In(b.loc)
+	switch b.unit {
+	case UnitWeek:
+		monday := t.Day() - (int(t.Weekday())+6)%7
+		return time.Date(t.Year(), t.Month(), monday, 0, 0, 0, 0, b.loc).Unix()
+	case UnitMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, b.loc).Unix()
+	case UnitYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, b.loc).Unix()
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, b.loc).Unix()
+}
+
+// next returns the start of the bucket following the one starting at start.
+func (b bucketer) next(start int64) int64 {
+	if b.unit == "" {
+		return start + b.step
+	}
+	// Work from the calendar date so a midnight skipped by DST does not drift.
+	t := time.Unix(start, 0).In(b.loc)
+	if t.Hour() >= 12 {
+		t = t.Add(12 * time.Hour)
+	}
+	switch b.unit {
+	case UnitWeek:
+		return time.Date(t.Year(), t.Month(), t.Day()+7, 0, 0, 0, 0, b.loc).Unix()
+	case UnitMonth:
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, b.loc).Unix()
+	case UnitYear:
+		return time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, b.loc).Unix()
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, b.loc).Unix()
+}
+
+// nests reports whether epoch-aligned buckets of res seconds fit inside these
+// buckets wherever the zone offset is a multiple of res.
+func (b bucketer) nests(res int64) bool {
+	if res <= 0 {
+		return false
+	}
+	if b.unit == "" {
+		return b.step >= res && b.step%res == 0
+	}
+	return 86400%res == 0
+}
@@ -0,0 +1,296 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/ygelfand/power-dash/internal/metrics"
+)
+
+const (
+	EnergyMethodCounter  = "counter"
+	EnergyMethodIntegral = "integral"
+
+	// How far before start to look for the counter sample that opens the first bucket.
+	energyLookback = 6 * 60 * 60
+)
+
+// energyPowerSign maps a site and counter direction to the sign of
+// power_watts that accumulates into it on the gateway.
+var energyPowerSign = map[string]map[string]float64{
+	"site":    {"import": 1, "export": -1},
+	"load":    {"import": 1, "export": -1},
+	"solar":   {"import": -1, "export": 1},
+	"battery": {"import": -1, "export": 1},
+}
+
+type EnergyPoint struct {
+	Timestamp int64   `json:"t"`
+	Value     float64 `json:"v"` // Wh
+	Method    string  `json:"method"`
+}
+
+type EnergySeries struct {
+	Site      string         `json:"site"`
+	Direction string         `json:"direction"`
+	Resets    int            `json:"resets"`
+	Points    []*EnergyPoint `json:"points"`
+}
+
+// energySample is a counter reading at T unix seconds.
+type energySample struct {
+	T int64
+	V float64
+}
+
+// energyRollups is the span [from, to) seconds of an energy query whose raw
+// samples are past the raw retention, answered from tier's rollups instead.
+type energyRollups struct {
+	tier     RollupTier
+	from, to int64
+}
+
+// SelectEnergy returns Wh per step bucket for a site and direction ("import" or
+// "export"). Buckets are computed from the gateway's lifetime energy_wh counters;
+// an increase spanning a collection gap is spread evenly over the gap. Buckets
+// without counter samples fall back to integrating power_watts. Where raw
+// samples are past the raw retention, the rollups of both are used.
+func (s *Store) SelectEnergy(site, direction string, start, end int64, step Step) (*EnergySeries, error) {
+	if step.IsZero() {
+		return nil, fmt.Errorf("step must be positive")
+	}
+	series := &EnergySeries{Site: site, Direction: direction, Points: []*EnergyPoint{}}
+	b := s.bucketer(step)
+	rr := s.energyRollupRange(start, end, b)
+
+	counter, resets, err := s.counterEnergy(site, direction, start, end, b, rr)
+	if err != nil {
+		return nil, err
+	}
+	series.Resets = resets
+
+	integral, err := s.integratedEnergy(site, direction, start, end, b, rr, counter)
+	if err != nil {
+		return nil, err
+	}
+
+	for t, v := range counter {
+		series.Points = append(series.Points, &EnergyPoint{Timestamp: t, Value: v, Method: EnergyMethodCounter})
+	}
+	for t, v := range integral {
+		series.Points = append(series.Points, &EnergyPoint{Timestamp: t, Value: v / 3600, Method: EnergyMethodIntegral})
+	}
+	sort.Slice(series.Points, func(i, j int) bool {
+		return series.Points[i].Timestamp < series.Points[j].Timestamp
+	})
+	return series, nil
+}
+
+// energyRollupRange returns the part of [start, end] that lies before the raw
+// retention cut-off and is covered by a rollup tier nesting in b, or nil.
+func (s *Store) energyRollupRange(start, end int64, b bucketer) *energyRollups {
+	cutoff, ok := s.rawCutoff()
+	if !ok || start*1000 >= cutoff {
+		return nil
+	}
+	tier, wm, ok := s.rollupTierFor(start, end, b, "")
+	if !ok {
+		return nil
+	}
+	res := int64(tier.Resolution / time.Second)
+	from, to := alignUp(start, res), min(wm, alignUp(cutoff/1000, res), alignDown(end+1, res))
+	if from >= to {
+		return nil
+	}
+	return &energyRollups{tier: tier, from: from, to: to}
+}
+
+// energyRollupBuckets returns the aggregates of metric's rollups in rr per
+// series, keyed by bucket start in seconds and aggregate name.
+func (s *Store) energyRollupBuckets(rr *energyRollups, metric string, matchers []*labels.Matcher) (map[string]map[int64]map[string]float64, error) {
+	q, err := s.db.Querier(rr.from*1000, rr.to*1000-1)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	ms := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, rollupMetricName(rr.tier, metric))}
+	for _, m := range matchers {
+		if m.Name != labels.MetricName {
+			ms = append(ms, m)
+		}
+	}
+	result := make(map[string]map[int64]map[string]float64)
+	ss := q.Select(context.Background(), false, nil, ms...)
+	for ss.Next() {
+		lset := ss.At().Labels()
+		key := energySeriesKey(lset)
+		if result[key] == nil {
+			result[key] = make(map[int64]map[string]float64)
+		}
+		agg := lset.Get(rollupAggLabel)
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			bt := t / 1000
+			if result[key][bt] == nil {
+				result[key][bt] = make(map[string]float64)
+			}
+			result[key][bt][agg] = v
+		}
+	}
+	return result, ss.Err()
+}
+
+// energySeriesKey identifies a raw series and its rollups alike.
+func energySeriesKey(lset labels.Labels) string {
+	return labels.NewBuilder(lset).Del(labels.MetricName, rollupAggLabel).Labels().String()
+}
+
+// counterSamples returns the energy_wh readings used for [start, end] per
+// series. Each rollup bucket in rr stands in for the raw samples it replaced
+// with its minimum at the bucket start and its maximum at the bucket end.
+func (s *Store) counterSamples(matchers []*labels.Matcher, start, end int64, rr *energyRollups) (map[string][]energySample, error) {
+	series := make(map[string][]energySample)
+	rawFrom := start - energyLookback
+	if rr != nil {
+		rollups, err := s.energyRollupBuckets(rr, metrics.EnergyWh, matchers)
+		if err != nil {
+			return nil, err
+		}
+		res := int64(rr.tier.Resolution / time.Second)
+		for key, buckets := range rollups {
+			for _, bt := range slices.Sorted(maps.Keys(buckets)) {
+				series[key] = append(series[key], energySample{bt, buckets[bt]["min"]}, energySample{bt + res - 1, buckets[bt]["max"]})
+			}
+		}
+		rawFrom = rr.to
+	}
+
+	q, err := s.db.Querier(rawFrom*1000, end*1000)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	ss := q.Select(context.Background(), false, nil, matchers...)
+	for ss.Next() {
+		key := energySeriesKey(ss.At().Labels())
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			series[key] = append(series[key], energySample{t / 1000, v})
+		}
+	}
+	return series, ss.Err()
+}
+
+// counterEnergy sums counter increases per bucket. A drop to less than half of
+// the previous value is a counter reset; smaller drops are meter jitter and ignored.
+func (s *Store) counterEnergy(site, direction string, start, end int64, b bucketer, rr *energyRollups) (map[int64]float64, int, error) {
+	series, err := s.counterSamples(tagMatchers(metrics.EnergyWh, map[string]string{"site": site, "direction": direction}), start, end, rr)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buckets := make(map[int64]float64)
+	resets := 0
+	for _, samples := range series {
+		var prevT int64
+		var prevV float64
+		first := true
+		for _, smp := range samples {
+			tSec, v := smp.T, smp.V
+			if !first && tSec > prevT {
+				inc := v - prevV
+				if v < prevV {
+					inc = 0
+					if v < prevV/2 {
+						inc = v
+						resets++
+					}
+				}
+				spreadIncrease(buckets, prevT, tSec, inc, start, end, b)
+			}
+			prevT, prevV, first = tSec, v, false
+		}
+	}
+	return buckets, resets, nil
+}
+
+// spreadIncrease distributes inc linearly over (t0, t1], clipped to [start, end].
+func spreadIncrease(buckets map[int64]float64, t0, t1 int64, inc float64, start, end int64, b bucketer) {
+	from, to := max(t0, start), min(t1, end+1)
+	span := float64(t1 - t0)
+	for from < to {
+		bs := b.start(from)
+		next := min(b.next(bs), to)
+		if next <= from {
+			next = to
+		}
+		buckets[bs] += inc * float64(next-from) / span
+		from = next
+	}
+}
+
+// integratedEnergy integrates power_watts (Watt-seconds) for buckets that have no
+// counter coverage, using the same 120s gap guard as integral queries. Rollup
+// integrals in rr are net of both directions, so each rollup bucket only
+// counts when it flowed in the requested direction overall.
+func (s *Store) integratedEnergy(site, direction string, start, end int64, bk bucketer, rr *energyRollups, skip map[int64]float64) (map[int64]float64, error) {
+	sign, ok := energyPowerSign[site][direction]
+	if !ok {
+		sign = 1
+		if direction == "export" {
+			sign = -1
+		}
+	}
+
+	matchers := append(tagMatchers(metrics.PowerWatts, map[string]string{"site": site}),
+		labels.MustNewMatcher(labels.MatchEqual, "phase", ""))
+
+	buckets := make(map[int64]float64)
+	if rr != nil {
+		rollups, err := s.energyRollupBuckets(rr, metrics.PowerWatts, matchers)
+		if err != nil {
+			return nil, err
+		}
+		for _, series := range rollups {
+			for bt, aggs := range series {
+				b := bk.start(bt)
+				if _, covered := skip[b]; !covered {
+					buckets[b] += max(sign*aggs["integral"], 0)
+				}
+			}
+		}
+		start = rr.to
+	}
+
+	q, err := s.db.Querier(start*1000, end*1000)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	ss := q.Select(context.Background(), false, nil, matchers...)
+	for ss.Next() {
+		var prevT int64
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			b := bk.start(t / 1000)
+			if _, covered := skip[b]; !covered && prevT > 0 {
+				if dt := (t - prevT) / 1000; dt > 0 && dt <= integralMaxGap {
+					buckets[b] += max(sign*v, 0) * float64(dt)
+				}
+			}
+			prevT = t
+		}
+	}
+	return buckets, ss.Err()
+}
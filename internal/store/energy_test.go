@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ygelfand/power-dash/internal/metrics"
+)
+
+func TestSelectEnergyPastRawRetention(t *testing.T) {
+	s := newTestStore(t, Config{RetentionPolicies: map[string]time.Duration{RawRetentionKey: 24 * time.Hour}})
+	day := testDay()
+	for i := range 1440 {
+		ts := day + int64(60*i)
+		if err := s.Insert(metrics.EnergyWh, []Label{{Name: "site", Value: "load"}, {Name: "direction", Value: "import"}}, float64(1000+10*i), ts); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		if err := s.Insert(metrics.PowerWatts, []Label{{Name: "site", Value: "solar"}}, 1200, ts); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+	s.runRollups()
+
+	tests := []struct {
+		site, direction, method string
+		want                    float64
+	}{
+		{"load", "import", EnergyMethodCounter, 10 * 1439},
+		{"solar", "export", EnergyMethodIntegral, 1200 * 1439 * 60 / 3600},
+	}
+	check := func(when string) {
+		t.Helper()
+		for _, tt := range tests {
+			series, err := s.SelectEnergy(tt.site, tt.direction, day, day+86400-1, Step{Unit: UnitDay})
+			if err != nil {
+				t.Fatalf("SelectEnergy: %v", err)
+			}
+			if len(series.Points) != 1 {
+				t.Errorf("%s %s %s: got %d points, want 1", when, tt.site, tt.direction, len(series.Points))
+				continue
+			}
+			p := series.Points[0]
+			if p.Timestamp != day || p.Value != tt.want || p.Method != tt.method {
+				t.Errorf("%s %s %s = %+v, want %v Wh at %d by %s", when, tt.site, tt.direction, p, tt.want, day, tt.method)
+			}
+		}
+	}
+	check("from raw samples")
+
+	if err := s.EnforceRetention(context.Background()); err != nil {
+		t.Fatalf("EnforceRetention: %v", err)
+	}
+	if n := rawCount(t, s, day, day+86400); n != 0 {
+		t.Fatalf("%d raw samples left after retention, want 0", n)
+	}
+	check("from rollups")
+}
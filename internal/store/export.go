@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+const (
+	// exportBatchSize caps the number of raw points handed to the callback at once.
+	exportBatchSize = 1000
+	// exportWindow is the time range aggregated per Select call in stepped exports.
+	exportWindow = 7 * 24 * time.Hour
+)
+
+// SeriesPoints is a batch of consecutive points from one series.
+type SeriesPoints struct {
+	Metric string
+	Labels []Label
+	Points []*DataPoint
+}
+
+// MatchSeries returns the series matching any of the matcher sets that hold
+// samples in [start, end] seconds, sorted by labels. Rollup series are left out.
+func (s *Store) MatchSeries(ctx context.Context, matcherSets [][]*labels.Matcher, start, end int64) ([]labels.Labels, error) {
+	q, err := s.db.Querier(start*1000, end*1000)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	seen := make(map[uint64]bool)
+	var result []labels.Labels
+	for _, matchers := range matcherSets {
+		ss := q.Select(ctx, false, &storage.SelectHints{Start: start * 1000, End: end * 1000, Func: "series"}, matchers...)
+		for ss.Next() {
+			lset := ss.At().Labels()
+			if IsRollupMetric(lset.Get(labels.MetricName)) {
+				continue
+			}
+			if h := lset.Hash(); !seen[h] {
+				seen[h] = true
+				result = append(result, lset)
+			}
+		}
+		if err := ss.Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return labels.Compare(result[i], result[j]) < 0 })
+	return result, nil
+}
+
+// Export streams the points of each series in [start, end] seconds to fn, one
+// series after another and in time order within a series. With step > 0 the
+// points are aggregated as in Select, one window at a time, so the whole
+// range is never held in memory.
+func (s *Store) Export(ctx context.Context, series []labels.Labels, start, end int64, step Step, function string, fn func(SeriesPoints) error) error {
+	absent, err := s.metricLabelNames(ctx, series, start, end)
+	if err != nil {
+		return err
+	}
+
+	for _, lset := range series {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		metric := lset.Get(labels.MetricName)
+		matchers := exactMatchers(lset, absent[metric])
+		out := SeriesPoints{Metric: metric}
+		lset.Range(func(l labels.Label) {
+			if l.Name != labels.MetricName {
+				out.Labels = append(out.Labels, Label{Name: l.Name, Value: l.Value})
+			}
+		})
+
+		var err error
+		if step.IsZero() {
+			err = s.exportRaw(ctx, matchers, start, end, out, fn)
+		} else {
+			err = s.exportStepped(ctx, metric, matchers, start, end, step, function, out, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) exportRaw(ctx context.Context, matchers []*labels.Matcher, start, end int64, out SeriesPoints, fn func(SeriesPoints) error) error {
+	q, err := s.db.Querier(start*1000, end*1000)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	ss := q.Select(ctx, false, nil, matchers...)
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			out.Points = append(out.Points, &DataPoint{Timestamp: t / 1000, Value: v})
+			if len(out.Points) == exportBatchSize {
+				if err := fn(out); err != nil {
+					return err
+				}
+				out.Points = nil
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	if err := ss.Err(); err != nil {
+		return err
+	}
+	if len(out.Points) > 0 {
+		return fn(out)
+	}
+	return nil
+}
+
+func (s *Store) exportStepped(ctx context.Context, metric string, matchers []*labels.Matcher, start, end int64, step Step, function string, out SeriesPoints, fn func(SeriesPoints) error) error {
+	b := s.bucketer(step)
+	// Resolve the zone once so every window buckets alike.
+	step.Location = b.loc
+	for from := start; from <= end; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Windows span whole buckets of at least exportWindow.
+		windowStart := b.start(from)
+		windowEnd := b.next(windowStart)
+		for windowEnd-windowStart < int64(exportWindow/time.Second) {
+			windowEnd = b.next(windowEnd)
+		}
+		to := min(end, windowEnd-1)
+		points, err := s.selectMatchers(metric, matchers, from, to, step, function, from > start)
+		if err != nil {
+			return err
+		}
+		if len(points) > 0 {
+			out.Points = points
+			if err := fn(out); err != nil {
+				return err
+			}
+		}
+		from = to + 1
+	}
+	return nil
+}
+
+// metricLabelNames returns the label names of every metric in series, as
+// needed by exactMatchers.
+func (s *Store) metricLabelNames(ctx context.Context, series []labels.Labels, start, end int64) (map[string][]string, error) {
+	names := make(map[string][]string)
+	for _, lset := range series {
+		metric := lset.Get(labels.MetricName)
+		if _, ok := names[metric]; ok {
+			continue
+		}
+		n, err := s.labelNames(ctx, metric, start, end)
+		if err != nil {
+			return nil, err
+		}
+		names[metric] = n
+	}
+	return names, nil
+}
+
+// labelNames returns the label names used by any series of metric.
+func (s *Store) labelNames(ctx context.Context, metric string, start, end int64) ([]string, error) {
+	q, err := s.db.Querier(start*1000, end*1000)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	names, _, err := q.LabelNames(ctx, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metric))
+	return names, err
+}
+
+// exactMatchers selects lset and nothing else: labels of the metric that lset
+// does not carry must be absent, so sibling series with extra labels such as
+// phase are not folded into it.
+func exactMatchers(lset labels.Labels, names []string) []*labels.Matcher {
+	var matchers []*labels.Matcher
+	lset.Range(func(l labels.Label) {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, l.Name, l.Value))
+	})
+	for _, name := range names {
+		if !lset.Has(name) {
+			matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, name, ""))
+		}
+	}
+	return matchers
+}
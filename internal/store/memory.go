@@ -0,0 +1,276 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+	"go.uber.org/zap"
+)
+
+// MemStore is a Storage that keeps every sample in memory until it is
+// closed. It has no rollups, retention or persistence, so queries always
+// aggregate raw samples.
+type MemStore struct {
+	engine
+}
+
+// NewMemStore returns an empty in-memory store. Writes are validated against
+// the metrics registry like those to a Store, and copied to sinks.
+func NewMemStore(logger *zap.Logger, sinks ...Sink) *MemStore {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &MemStore{engine: engine{db: newMemDB(), logger: logger, sinks: sinks}}
+}
+
+// Select returns the samples of metric in [start, end] seconds, or with a
+// non-zero step one point per bucket aggregated by function.
+func (m *MemStore) Select(metric string, tags map[string]string, start, end int64, step Step, function string) ([]*DataPoint, error) {
+	return m.selectRaw(tagMatchers(metric, tags), start, end, step, function, false)
+}
+
+// ChunkQueryable exposes the samples to readers that consume chunks, such as
+// streamed remote read.
+func (m *MemStore) ChunkQueryable() storage.SampleAndChunkQueryable {
+	return m.db.(*memDB)
+}
+
+// Close drops every sample.
+func (m *MemStore) Close() error {
+	db := m.db.(*memDB)
+	db.mu.Lock()
+	db.series = make(map[string]*memSeries)
+	db.mu.Unlock()
+	return nil
+}
+
+var errMemUnsupported = errors.New("not supported by the in-memory store")
+
+type memSample struct {
+	t int64
+	f float64
+}
+
+func (s memSample) T() int64                    { return s.t }
+func (s memSample) F() float64                  { return s.f }
+func (memSample) H() *histogram.Histogram       { return nil }
+func (memSample) FH() *histogram.FloatHistogram { return nil }
+func (memSample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
+func (s memSample) Copy() chunks.Sample         { return s }
+
+type memSeries struct {
+	lset    labels.Labels
+	samples []memSample
+}
+
+// add inserts a sample in time order, replacing one at the same timestamp.
+func (ms *memSeries) add(t int64, v float64) {
+	n := len(ms.samples)
+	if n == 0 || ms.samples[n-1].t < t {
+		ms.samples = append(ms.samples, memSample{t, v})
+		return
+	}
+	i := sort.Search(n, func(i int) bool { return ms.samples[i].t >= t })
+	if ms.samples[i].t == t {
+		ms.samples[i].f = v
+		return
+	}
+	ms.samples = append(ms.samples, memSample{})
+	copy(ms.samples[i+1:], ms.samples[i:])
+	ms.samples[i] = memSample{t, v}
+}
+
+// memDB is the backend of a MemStore: a map of series to sorted samples that
+// implements the Prometheus appender and queryable interfaces.
+type memDB struct {
+	mu     sync.RWMutex
+	series map[string]*memSeries
+}
+
+func newMemDB() *memDB {
+	return &memDB{series: make(map[string]*memSeries)}
+}
+
+func (db *memDB) Appender(context.Context) storage.Appender {
+	return &memAppender{db: db}
+}
+
+func (db *memDB) Querier(mint, maxt int64) (storage.Querier, error) {
+	return &memQuerier{db: db, mint: mint, maxt: maxt}, nil
+}
+
+func (db *memDB) ChunkQuerier(mint, maxt int64) (storage.ChunkQuerier, error) {
+	return &memChunkQuerier{memQuerier{db: db, mint: mint, maxt: maxt}}, nil
+}
+
+type memAppender struct {
+	db      *memDB
+	pending []memPending
+}
+
+type memPending struct {
+	lset labels.Labels
+	t    int64
+	v    float64
+}
+
+func (a *memAppender) Append(_ storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if l.IsEmpty() {
+		return 0, errors.New("empty labelset")
+	}
+	a.pending = append(a.pending, memPending{lset: l, t: t, v: v})
+	return 0, nil
+}
+
+func (a *memAppender) Commit() error {
+	a.db.mu.Lock()
+	defer a.db.mu.Unlock()
+	for _, p := range a.pending {
+		key := string(p.lset.Bytes(nil))
+		ms := a.db.series[key]
+		if ms == nil {
+			ms = &memSeries{lset: p.lset}
+			a.db.series[key] = ms
+		}
+		ms.add(p.t, p.v)
+	}
+	a.pending = nil
+	return nil
+}
+
+func (a *memAppender) Rollback() error {
+	a.pending = nil
+	return nil
+}
+
+func (*memAppender) SetOptions(*storage.AppendOptions) {}
+
+func (*memAppender) AppendExemplar(storage.SeriesRef, labels.Labels, exemplar.Exemplar) (storage.SeriesRef, error) {
+	return 0, errMemUnsupported
+}
+
+func (*memAppender) AppendHistogram(storage.SeriesRef, labels.Labels, int64, *histogram.Histogram, *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return 0, errMemUnsupported
+}
+
+func (*memAppender) AppendHistogramSTZeroSample(storage.SeriesRef, labels.Labels, int64, int64, *histogram.Histogram, *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return 0, errMemUnsupported
+}
+
+func (*memAppender) UpdateMetadata(storage.SeriesRef, labels.Labels, metadata.Metadata) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (*memAppender) AppendSTZeroSample(storage.SeriesRef, labels.Labels, int64, int64) (storage.SeriesRef, error) {
+	return 0, errMemUnsupported
+}
+
+type memQuerier struct {
+	db         *memDB
+	mint, maxt int64
+}
+
+// matching returns the series matching every matcher that have samples in
+// the querier's range, sorted by labels, with only those samples.
+func (q *memQuerier) matching(matchers []*labels.Matcher) []*memSeries {
+	q.db.mu.RLock()
+	defer q.db.mu.RUnlock()
+
+	var out []*memSeries
+	for _, ms := range q.db.series {
+		ok := true
+		for _, m := range matchers {
+			if !m.Matches(ms.lset.Get(m.Name)) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		lo := sort.Search(len(ms.samples), func(i int) bool { return ms.samples[i].t >= q.mint })
+		hi := sort.Search(len(ms.samples), func(i int) bool { return ms.samples[i].t > q.maxt })
+		if lo >= hi {
+			continue
+		}
+		samples := make([]memSample, hi-lo)
+		copy(samples, ms.samples[lo:hi])
+		out = append(out, &memSeries{lset: ms.lset, samples: samples})
+	}
+	sort.Slice(out, func(i, j int) bool { return labels.Compare(out[i].lset, out[j].lset) < 0 })
+	return out
+}
+
+func (q *memQuerier) Select(_ context.Context, _ bool, _ *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	matched := q.matching(matchers)
+	series := make([]storage.Series, 0, len(matched))
+	for _, ms := range matched {
+		samples := make([]chunks.Sample, len(ms.samples))
+		for i, s := range ms.samples {
+			samples[i] = s
+		}
+		series = append(series, storage.NewListSeries(ms.lset, samples))
+	}
+	return &memSeriesSet{series: series, i: -1}
+}
+
+func (q *memQuerier) LabelValues(_ context.Context, name string, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	seen := make(map[string]struct{})
+	for _, ms := range q.matching(matchers) {
+		if v := ms.lset.Get(name); v != "" {
+			seen[v] = struct{}{}
+		}
+	}
+	return sortedKeys(seen), nil, nil
+}
+
+func (q *memQuerier) LabelNames(_ context.Context, _ *storage.LabelHints, matchers ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	seen := make(map[string]struct{})
+	for _, ms := range q.matching(matchers) {
+		ms.lset.Range(func(l labels.Label) { seen[l.Name] = struct{}{} })
+	}
+	return sortedKeys(seen), nil, nil
+}
+
+func (*memQuerier) Close() error { return nil }
+
+type memChunkQuerier struct {
+	memQuerier
+}
+
+func (q *memChunkQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.ChunkSeriesSet {
+	return storage.NewSeriesSetToChunkSet(q.memQuerier.Select(ctx, sortSeries, hints, matchers...))
+}
+
+type memSeriesSet struct {
+	series []storage.Series
+	i      int
+}
+
+func (s *memSeriesSet) Next() bool {
+	s.i++
+	return s.i < len(s.series)
+}
+
+func (s *memSeriesSet) At() storage.Series              { return s.series[s.i] }
+func (*memSeriesSet) Err() error                        { return nil }
+func (*memSeriesSet) Warnings() annotations.Annotations { return nil }
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
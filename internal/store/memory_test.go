@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// insertFixture writes three hours of power_watts minutes for load and solar
+// into s, the solar samples newest first, then overwrites one load sample.
+func insertFixture(t *testing.T, s Storage, day int64) {
+	t.Helper()
+	solar := []Label{{Name: "site", Value: "solar"}}
+	for i := range 180 {
+		if err := s.Insert(metrics.PowerWatts, testSite, float64(10*i), day+int64(60*i)); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+	for i := 179; i >= 0; i-- {
+		if err := s.Insert(metrics.PowerWatts, solar, float64(5*i), day+int64(60*i)+30); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+	if err := s.Insert(metrics.PowerWatts, testSite, 5000, day+600); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+}
+
+func TestMemStoreOrdersSamples(t *testing.T) {
+	m := NewMemStore(zap.NewNop())
+	day := testDay()
+	for _, i := range []int64{3, 0, 4, 1, 2, 1} {
+		if err := m.Insert(metrics.PowerWatts, testSite, float64(10*i), day+60*i); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+	// A second write at the same timestamp replaces the first.
+	if err := m.Insert(metrics.PowerWatts, testSite, 99, day+120); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	got, err := m.Select(metrics.PowerWatts, map[string]string{"site": "load"}, day, day+3600, Step{}, "")
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	want := []DataPoint{{day, 0}, {day + 60, 10}, {day + 120, 99}, {day + 180, 30}, {day + 240, 40}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d samples, want %d: %v", len(got), len(want), got)
+	}
+	for i, p := range got {
+		if *p != want[i] {
+			t.Errorf("sample %d = %+v, want %+v", i, *p, want[i])
+		}
+	}
+}
+
+func TestMemStoreMatchers(t *testing.T) {
+	m := NewMemStore(zap.NewNop())
+	day := testDay()
+	insertFixture(t, m, day)
+	if err := m.Insert(metrics.PowerWatts, nil, 1, day); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	name := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metrics.PowerWatts)
+	tests := []struct {
+		name    string
+		matcher *labels.Matcher
+		want    []string
+	}{
+		{"equal", labels.MustNewMatcher(labels.MatchEqual, "site", "load"), []string{"load"}},
+		{"not equal", labels.MustNewMatcher(labels.MatchNotEqual, "site", "load"), []string{"", "solar"}},
+		{"regexp", labels.MustNewMatcher(labels.MatchRegexp, "site", "lo.*|sol.*"), []string{"load", "solar"}},
+		{"anchored regexp", labels.MustNewMatcher(labels.MatchRegexp, "site", "oad"), nil},
+		{"not regexp", labels.MustNewMatcher(labels.MatchNotRegexp, "site", "s.*"), []string{"", "load"}},
+		{"missing label", labels.MustNewMatcher(labels.MatchEqual, "site", ""), []string{""}},
+		{"present label", labels.MustNewMatcher(labels.MatchNotEqual, "site", ""), []string{"load", "solar"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := m.Queryable().Querier(day*1000, (day+3*3600)*1000)
+			if err != nil {
+				t.Fatalf("Querier: %v", err)
+			}
+			defer q.Close()
+			var sites []string
+			ss := q.Select(context.Background(), false, nil, name, tt.matcher)
+			for ss.Next() {
+				sites = append(sites, ss.At().Labels().Get("site"))
+			}
+			if err := ss.Err(); err != nil {
+				t.Fatalf("Select: %v", err)
+			}
+			if !slices.Equal(sites, tt.want) {
+				t.Errorf("matched sites %q, want %q", sites, tt.want)
+			}
+		})
+	}
+
+	// The querier's range trims samples and drops series with none left.
+	q, err := m.Queryable().Querier((day+30)*1000, (day+90)*1000)
+	if err != nil {
+		t.Fatalf("Querier: %v", err)
+	}
+	defer q.Close()
+	ss := q.Select(context.Background(), false, nil, name)
+	n := 0
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			n++
+		}
+	}
+	if n != 3 {
+		t.Errorf("%d samples in [day+30s, day+90s], want 3", n)
+	}
+}
+
+func TestMemStoreSelectMatchesStore(t *testing.T) {
+	day := testDay()
+	m := NewMemStore(zap.NewNop())
+	m.SetLocation(time.UTC)
+	insertFixture(t, m, day)
+	s := newTestStore(t, Config{})
+	insertFixture(t, s, day)
+	s.runRollups()
+
+	tags := []map[string]string{nil, {"site": "load"}, {"site": "solar"}}
+	steps := []Step{{}, {Seconds: 300}, {Seconds: 3600}, {Unit: UnitDay}}
+	functions := []string{"", "sum", "min", "max", "delta", "integral"}
+	for _, tag := range tags {
+		for _, step := range steps {
+			for _, fn := range functions {
+				want, err := s.Select(metrics.PowerWatts, tag, day, day+86400-1, step, fn)
+				if err != nil {
+					t.Fatalf("Store.Select: %v", err)
+				}
+				got, err := m.Select(metrics.PowerWatts, tag, day, day+86400-1, step, fn)
+				if err != nil {
+					t.Fatalf("MemStore.Select: %v", err)
+				}
+				if len(got) != len(want) {
+					t.Errorf("%v step %+v %q: got %d points, want %d", tag, step, fn, len(got), len(want))
+					continue
+				}
+				for i := range got {
+					if *got[i] != *want[i] {
+						t.Errorf("%v step %+v %q: point %d = %+v, want %+v", tag, step, fn, i, *got[i], *want[i])
+						break
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// rewriteBatchSize caps the number of rewritten samples per commit.
+const rewriteBatchSize = 10000
+
+// Rewrite transforms sample values into v*Scale + Offset, negating v first
+// when Negate is set. A zero Scale leaves values unscaled.
+type Rewrite struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+	Negate bool    `json:"negate"`
+}
+
+func (r Rewrite) apply(v float64) float64 {
+	if r.Negate {
+		v = -v
+	}
+	if r.Scale != 0 {
+		v *= r.Scale
+	}
+	return v + r.Offset
+}
+
+// ModifyRequest selects samples to delete, or to rewrite when Rewrite is set.
+// Matchers are PromQL series selectors; Start and End are unix seconds and
+// both inclusive.
+type ModifyRequest struct {
+	Matchers []string `json:"matchers"`
+	Start    int64    `json:"start"`
+	End      int64    `json:"end"`
+	Rewrite  *Rewrite `json:"rewrite,omitempty"`
+	// DryRun only counts the samples that would be affected.
+	DryRun bool `json:"dry_run"`
+}
+
+// Parse validates r and returns its parsed matchers.
+func (r *ModifyRequest) Parse() ([][]*labels.Matcher, error) {
+	if len(r.Matchers) == 0 {
+		return nil, fmt.Errorf("at least one matcher is required")
+	}
+	if r.End == 0 {
+		return nil, fmt.Errorf("end is required")
+	}
+	if r.Start > r.End {
+		return nil, fmt.Errorf("start must not be after end")
+	}
+	if rw := r.Rewrite; rw != nil && (rw.Scale == 0 || rw.Scale == 1) && rw.Offset == 0 && !rw.Negate {
+		return nil, fmt.Errorf("rewrite needs a scale, offset or negate")
+	}
+
+	sets := make([][]*labels.Matcher, 0, len(r.Matchers))
+	for _, m := range r.Matchers {
+		ms, err := parser.ParseMetricSelector(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %w", m, err)
+		}
+		sets = append(sets, ms)
+	}
+	return sets, nil
+}
+
+type ModifiedSeries struct {
+	Metric  string            `json:"metric"`
+	Labels  map[string]string `json:"labels"`
+	Samples int               `json:"samples"`
+}
+
+type ModifyResult struct {
+	DryRun  bool             `json:"dry_run"`
+	Rewrite *Rewrite         `json:"rewrite,omitempty"`
+	Series  []ModifiedSeries `json:"series"`
+	Samples int              `json:"samples"`
+}
+
+// Modify deletes or rewrites the samples selected by req. When it fails part
+// way, the result lists the samples changed before the failure.
+func (s *Store) Modify(ctx context.Context, req ModifyRequest) (*ModifyResult, error) {
+	matcherSets, err := req.Parse()
+	if err != nil {
+		return nil, err
+	}
+	if req.Rewrite != nil {
+		return s.RewriteSeries(ctx, matcherSets, req.Start, req.End, *req.Rewrite, req.DryRun)
+	}
+	return s.DeleteSeries(ctx, matcherSets, req.Start, req.End, req.DryRun)
+}
+
+// DeleteSeries removes the samples of the matching series in [start, end]
+// seconds and recomputes the rollups covering that range. Rollup series
+// themselves cannot be selected.
+func (s *Store) DeleteSeries(ctx context.Context, matcherSets [][]*labels.Matcher, start, end int64, dryRun bool) (*ModifyResult, error) {
+	return s.modify(ctx, matcherSets, start, end, nil, dryRun)
+}
+
+// RewriteSeries replaces the samples of the matching series in [start, end]
+// seconds with rw applied to them and recomputes the affected rollups.
+// Samples collected while the rewrite runs are left as they are.
+func (s *Store) RewriteSeries(ctx context.Context, matcherSets [][]*labels.Matcher, start, end int64, rw Rewrite, dryRun bool) (*ModifyResult, error) {
+	return s.modify(ctx, matcherSets, start, end, &rw, dryRun)
+}
+
+func (s *Store) modify(ctx context.Context, matcherSets [][]*labels.Matcher, start, end int64, rw *Rewrite, dryRun bool) (*ModifyResult, error) {
+	s.modifyMu.Lock()
+	defer s.modifyMu.Unlock()
+
+	res := &ModifyResult{DryRun: dryRun, Rewrite: rw, Series: []ModifiedSeries{}}
+	series, err := s.MatchSeries(ctx, matcherSets, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(series) == 0 {
+		return res, nil
+	}
+	names, err := s.metricLabelNames(ctx, series, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	mint, maxt := start*1000, end*1000+999
+	if !dryRun {
+		persisted, err := s.persistHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Deleting from the head would also hide the rewritten samples.
+		if rw != nil {
+			maxt = min(maxt, persisted)
+		}
+	}
+
+	var touched []labels.Labels
+	var failed error
+	for _, lset := range series {
+		if err := ctx.Err(); err != nil {
+			failed = err
+			break
+		}
+		matchers := exactMatchers(lset, names[lset.Get(labels.MetricName)])
+
+		var n int
+		if dryRun {
+			n, err = s.countSamples(ctx, matchers, mint, maxt)
+		} else if rw != nil {
+			n, err = s.rewriteSamples(ctx, lset, matchers, mint, maxt, *rw)
+		} else {
+			if n, err = s.countSamples(ctx, matchers, mint, maxt); err == nil && n > 0 {
+				if err = s.db.Delete(ctx, mint, maxt, matchers...); err != nil {
+					n = 0
+				}
+			}
+		}
+		if err != nil {
+			failed = fmt.Errorf("%s: %w", lset, err)
+		}
+		if n > 0 {
+			res.add(lset, n)
+			touched = append(touched, lset)
+		}
+		if failed != nil {
+			break
+		}
+	}
+
+	// Rollups are rebuilt for whatever was changed, even when a series failed
+	// part way, so that they match the raw samples left behind.
+	if dryRun || len(touched) == 0 {
+		return res, failed
+	}
+	if err := s.backfillRollups(context.WithoutCancel(ctx), time.UnixMilli(mint), time.UnixMilli(maxt+1)); err != nil {
+		return res, errors.Join(failed, err)
+	}
+	if failed != nil {
+		return res, failed
+	}
+
+	op := "Deleted samples"
+	if rw != nil {
+		op = "Rewrote samples"
+	}
+	s.logger.Info(op,
+		zap.Int("series", len(res.Series)),
+		zap.Int("samples", res.Samples),
+		zap.Time("start", time.UnixMilli(mint)),
+		zap.Time("end", time.UnixMilli(maxt)),
+	)
+	return res, nil
+}
+
+// add records that n samples of lset were deleted or rewritten.
+func (res *ModifyResult) add(lset labels.Labels, n int) {
+	ms := ModifiedSeries{Metric: lset.Get(labels.MetricName), Labels: make(map[string]string), Samples: n}
+	lset.Range(func(l labels.Label) {
+		if l.Name != labels.MetricName {
+			ms.Labels[l.Name] = l.Value
+		}
+	})
+	res.Series = append(res.Series, ms)
+	res.Samples += n
+}
+
+// persistHead writes the head, including out-of-order samples, to blocks so
+// that deletions become block tombstones, which unlike head tombstones do not
+// hide samples appended afterwards. It returns the newest persisted timestamp.
+func (s *Store) persistHead(ctx context.Context) (int64, error) {
+	if err := s.db.CompactOOOHead(ctx); err != nil {
+		return 0, fmt.Errorf("compact out-of-order head: %w", err)
+	}
+	head := s.db.Head()
+	mint, maxt := head.MinTime(), head.MaxTime()
+	if maxt == math.MinInt64 {
+		return math.MaxInt64, nil
+	}
+	if mint <= maxt {
+		if err := s.db.CompactHead(tsdb.NewRangeHead(head, mint, maxt)); err != nil {
+			return 0, err
+		}
+	}
+	return maxt, nil
+}
+
+func (s *Store) countSamples(ctx context.Context, matchers []*labels.Matcher, mint, maxt int64) (int, error) {
+	q, err := s.db.Querier(mint, maxt)
+	if err != nil {
+		return 0, err
+	}
+	defer q.Close()
+
+	n := 0
+	ss := q.Select(ctx, false, nil, matchers...)
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			n++
+		}
+		if err := it.Err(); err != nil {
+			return n, err
+		}
+	}
+	return n, ss.Err()
+}
+
+// rewriteSamples reads the samples of one series, deletes them and appends
+// the transformed values in their place. Every rewritten value is validated
+// before anything is deleted, and once the delete went through cancelling ctx
+// no longer stops the appends. On failure it returns how many samples were
+// written back.
+func (s *Store) rewriteSamples(ctx context.Context, lset labels.Labels, matchers []*labels.Matcher, mint, maxt int64, rw Rewrite) (int, error) {
+	type sample struct {
+		t int64
+		v float64
+	}
+	var samples []sample
+
+	q, err := s.db.Querier(mint, maxt)
+	if err != nil {
+		return 0, err
+	}
+	ss := q.Select(ctx, false, nil, matchers...)
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			samples = append(samples, sample{t, v})
+		}
+		if err := it.Err(); err != nil {
+			q.Close()
+			return 0, err
+		}
+	}
+	err = ss.Err()
+	q.Close()
+	if err != nil || len(samples) == 0 {
+		return 0, err
+	}
+
+	metric := lset.Get(labels.MetricName)
+	info, ok := metrics.Lookup(metric)
+	if !ok {
+		return 0, fmt.Errorf("unknown metric %q", metric)
+	}
+	for _, smp := range samples {
+		v := rw.apply(smp.v)
+		if math.IsInf(v, 0) {
+			return 0, fmt.Errorf("rewrite of %v at %s is infinite", smp.v, time.UnixMilli(smp.t).UTC())
+		}
+		if err := info.CheckValue(v); err != nil {
+			return 0, fmt.Errorf("rewrite of %v at %s: %w", smp.v, time.UnixMilli(smp.t).UTC(), err)
+		}
+	}
+
+	if err := s.db.Delete(ctx, mint, maxt, matchers...); err != nil {
+		return 0, err
+	}
+
+	// Rewritten samples bypass the sinks: remote-write receivers reject
+	// samples older than what they already hold.
+	ctx = context.WithoutCancel(ctx)
+	written := 0
+	app := s.db.Appender(ctx)
+	for i, smp := range samples {
+		if _, err := app.Append(0, lset, smp.t, rw.apply(smp.v)); err != nil {
+			_ = app.Rollback()
+			return written, fmt.Errorf("rewrote %d of %d samples before failing: %w", written, len(samples), err)
+		}
+		if (i+1)%rewriteBatchSize == 0 {
+			if err := app.Commit(); err != nil {
+				return written, fmt.Errorf("rewrote %d of %d samples before failing: %w", written, len(samples), err)
+			}
+			written = i + 1
+			app = s.db.Appender(ctx)
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return written, fmt.Errorf("rewrote %d of %d samples before failing: %w", written, len(samples), err)
+	}
+	return len(samples), nil
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/ygelfand/power-dash/internal/metrics"
+)
+
+var powerMatchers = [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metrics.PowerWatts)}}
+
+// insertSpread writes 60 samples into a block, 60 into the head and 30 out of
+// order between them, starting at day, and returns the sum of their values.
+func insertSpread(t *testing.T, s *Store, day int64) float64 {
+	t.Helper()
+	insertMinutes(t, s, day, 60)
+	if _, err := s.persistHead(context.Background()); err != nil {
+		t.Fatalf("persistHead: %v", err)
+	}
+	insertMinutes(t, s, day+2*3600, 60)
+	insertMinutes(t, s, day+3600+30, 30)
+	if n := rawCount(t, s, day, day+3*3600); n != 150 {
+		t.Fatalf("inserted %d samples, want 150", n)
+	}
+	return 2*float64(10*59*60/2) + float64(10*29*30/2)
+}
+
+// rawSum returns the sum of the raw power_watts samples in [start, end] seconds.
+func rawSum(t *testing.T, s *Store, start, end int64) float64 {
+	t.Helper()
+	q, err := s.db.Querier(start*1000, end*1000)
+	if err != nil {
+		t.Fatalf("Querier: %v", err)
+	}
+	defer q.Close()
+	ss := q.Select(context.Background(), false, nil, powerMatchers[0]...)
+	sum := 0.0
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			_, v := it.At()
+			sum += v
+		}
+	}
+	if err := ss.Err(); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	return sum
+}
+
+func TestDeleteSeries(t *testing.T) {
+	s := newTestStore(t, Config{})
+	day := testDay()
+	insertSpread(t, s, day)
+	s.runRollups()
+	ctx := context.Background()
+
+	res, err := s.DeleteSeries(ctx, powerMatchers, day, day+3*3600, true)
+	if err != nil {
+		t.Fatalf("DeleteSeries dry run: %v", err)
+	}
+	if res.Samples != 150 || rawCount(t, s, day, day+3*3600) != 150 {
+		t.Fatalf("dry run reported %d samples and left %d, want 150 of 150", res.Samples, rawCount(t, s, day, day+3*3600))
+	}
+
+	res, err = s.DeleteSeries(ctx, powerMatchers, day+30*60, day+2*3600+29*60, false)
+	if err != nil {
+		t.Fatalf("DeleteSeries: %v", err)
+	}
+	if res.Samples != 90 || len(res.Series) != 1 {
+		t.Errorf("deleted %d samples in %d series, want 90 in 1", res.Samples, len(res.Series))
+	}
+	if n := rawCount(t, s, day, day+3*3600); n != 60 {
+		t.Errorf("%d samples left, want 60", n)
+	}
+	if got := rollupValue(t, s, "1d", "count", day); got != 60 {
+		t.Errorf("1d count after delete = %v, want 60", got)
+	}
+
+	// Samples written into a deleted range afterwards stay visible.
+	if err := s.Insert(metrics.PowerWatts, testSite, 5000, day+3600); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if n := rawCount(t, s, day+3600, day+3600); n != 1 {
+		t.Errorf("%d samples after re-insert, want 1", n)
+	}
+}
+
+func TestRewriteSeries(t *testing.T) {
+	s := newTestStore(t, Config{})
+	day := testDay()
+	sum := insertSpread(t, s, day)
+	ctx := context.Background()
+
+	res, err := s.RewriteSeries(ctx, powerMatchers, day, day+3*3600, Rewrite{Scale: 2, Offset: 1}, false)
+	if err != nil {
+		t.Fatalf("RewriteSeries: %v", err)
+	}
+	if res.Samples != 150 {
+		t.Errorf("rewrote %d samples, want 150", res.Samples)
+	}
+	if n := rawCount(t, s, day, day+3*3600); n != 150 {
+		t.Errorf("%d samples after rewrite, want 150", n)
+	}
+	if got, want := rawSum(t, s, day, day+3*3600), 2*sum+150; got != want {
+		t.Errorf("sum after rewrite = %v, want %v", got, want)
+	}
+}
+
+func TestRewriteSeriesRejectsInvalidValues(t *testing.T) {
+	s := newTestStore(t, Config{})
+	day := testDay()
+	sum := insertSpread(t, s, day)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		rw   Rewrite
+	}{
+		{"infinite", Rewrite{Scale: math.MaxFloat64}},
+		{"not a number", Rewrite{Scale: math.NaN()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.RewriteSeries(ctx, powerMatchers, day, day+3*3600, tt.rw, false)
+			if err == nil {
+				t.Fatal("RewriteSeries succeeded, want an error")
+			}
+			if res.Samples != 0 {
+				t.Errorf("reported %d rewritten samples, want 0", res.Samples)
+			}
+			if n := rawCount(t, s, day, day+3*3600); n != 150 {
+				t.Errorf("%d samples left, want 150", n)
+			}
+			if got := rawSum(t, s, day, day+3*3600); got != sum {
+				t.Errorf("sum = %v, want %v", got, sum)
+			}
+		})
+	}
+}
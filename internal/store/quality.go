@@ -0,0 +1,428 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/ygelfand/power-dash/internal/metrics"
+)
+
+const (
+	defaultQualityInterval = 30 * time.Second
+	defaultFlatLine        = 6 * time.Hour
+	// A day is trusted when collection gaps cover less than this share of it.
+	trustedGapRatio = 0.01
+	// Backfill suggestions are widened to whole hours, the granularity imports use.
+	backfillAlign = 3600
+)
+
+// eventMetrics are only written while something is happening, so silence in
+// them is not a gap.
+var eventMetrics = map[string]bool{
+	metrics.ActiveAlert:    true,
+	metrics.CollectionMark: true,
+}
+
+// flatLineMetrics are live measurements that never hold the same non-zero
+// value for hours on a healthy system.
+var flatLineMetrics = map[string]bool{
+	metrics.PowerWatts:             true,
+	metrics.PowerReactiveVAR:       true,
+	metrics.PowerApparentVA:        true,
+	metrics.VoltageVolts:           true,
+	metrics.CurrentAmps:            true,
+	metrics.FrequencyHertz:         true,
+	metrics.InverterPowerWatts:     true,
+	metrics.InverterFrequencyHertz: true,
+	metrics.InverterVoltageVolts:   true,
+	metrics.SolarVoltageVolts:      true,
+	metrics.SolarCurrentAmps:       true,
+	metrics.SolarPowerWatts:        true,
+	metrics.TemperatureCelsius:     true,
+}
+
+type QualityOptions struct {
+	Start int64 // seconds
+	End   int64 // seconds
+	// Interval is the expected collection interval. Spacing of more than
+	// three intervals is a gap, less than half an interval is a duplicate.
+	Interval time.Duration
+	// Intervals overrides Interval for metrics written on their own
+	// schedule, by metric name.
+	Intervals map[string]MetricInterval
+	// FlatLine is how long a live value may stay unchanged before it is flagged.
+	FlatLine time.Duration
+}
+
+// MetricInterval is how often a metric is written. Metrics that several
+// collectors on different schedules write arrive every Min to Max.
+type MetricInterval struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+type Gap struct {
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Duration string `json:"duration"`
+}
+
+func newGap(start, end int64) Gap {
+	return Gap{Start: start, End: end, Duration: (time.Duration(end-start) * time.Second).String()}
+}
+
+type SeriesIssue struct {
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels"`
+	Start  int64             `json:"start"`
+	End    int64             `json:"end"`
+	// Duration of the affected range.
+	Duration string `json:"duration"`
+	// Samples involved: duplicated samples, or decreases of a counter.
+	Samples int     `json:"samples,omitempty"`
+	Value   float64 `json:"value,omitempty"`
+}
+
+type DayQuality struct {
+	Date        string  `json:"date"`
+	Coverage    float64 `json:"coverage"` // share of the day with collection marks
+	GapSeconds  int64   `json:"gap_seconds"`
+	CounterDrop bool    `json:"counter_drop"`
+	Trusted     bool    `json:"trusted"`
+}
+
+type QualityReport struct {
+	Start          int64         `json:"start"`
+	End            int64         `json:"end"`
+	Interval       string        `json:"interval"`
+	CollectionGaps []Gap         `json:"collection_gaps"`
+	SeriesGaps     []SeriesIssue `json:"series_gaps"`
+	Duplicates     []SeriesIssue `json:"duplicates"`
+	OutOfOrder     []SeriesIssue `json:"out_of_order"`
+	FlatLines      []SeriesIssue `json:"flat_lines"`
+	Backfill       []Gap         `json:"backfill"`
+	Days           []DayQuality  `json:"days"`
+}
+
+// Quality scans raw samples in the requested range for collection gaps,
+// per-series gaps, duplicated or out-of-order samples and flat-lined values,
+// and rates each local day by how complete its data is.
+func (s *Store) Quality(ctx context.Context, opts QualityOptions) (*QualityReport, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultQualityInterval
+	}
+	if opts.FlatLine <= 0 {
+		opts.FlatLine = defaultFlatLine
+	}
+	if opts.End == 0 {
+		opts.End = time.Now().Unix()
+	}
+	// Nothing before the first stored sample can be missing.
+	if minT, ok := s.minTime(); ok && opts.Start < minT/1000 {
+		opts.Start = minT / 1000
+	}
+	if opts.Start >= opts.End {
+		return nil, fmt.Errorf("empty time range")
+	}
+
+	interval := int64(opts.Interval / time.Second)
+	maxGap := 3 * interval
+	report := &QualityReport{
+		Start:          opts.Start,
+		End:            opts.End,
+		Interval:       opts.Interval.String(),
+		CollectionGaps: []Gap{},
+		SeriesGaps:     []SeriesIssue{},
+		Duplicates:     []SeriesIssue{},
+		OutOfOrder:     []SeriesIssue{},
+		FlatLines:      []SeriesIssue{},
+		Backfill:       []Gap{},
+		Days:           []DayQuality{},
+	}
+
+	q, err := s.db.Querier(opts.Start*1000, opts.End*1000)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	// Collection marks first: series gaps inside them are already explained.
+	var lastMark int64
+	marks := q.Select(ctx, false, nil, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metrics.CollectionMark))
+	prev := opts.Start
+	for marks.Next() {
+		it := marks.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, _ := it.At()
+			tSec := t / 1000
+			if tSec-prev > maxGap {
+				report.CollectionGaps = append(report.CollectionGaps, newGap(prev, tSec))
+			}
+			prev = tSec
+			lastMark = tSec
+		}
+	}
+	if err := marks.Err(); err != nil {
+		return nil, err
+	}
+	if opts.End-prev > maxGap {
+		report.CollectionGaps = append(report.CollectionGaps, newGap(prev, opts.End))
+	}
+
+	ss := q.Select(ctx, false, nil,
+		labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"),
+		labels.MustNewMatcher(labels.MatchNotRegexp, labels.MetricName, rollupPrefix+".*"),
+	)
+	for ss.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		lset := ss.At().Labels()
+		metric := lset.Get(labels.MetricName)
+		if eventMetrics[metric] {
+			continue
+		}
+		sc := seriesScan{
+			report:   report,
+			metric:   metric,
+			labels:   lset,
+			interval: interval,
+			maxGap:   maxGap,
+			flatLine: int64(opts.FlatLine / time.Second),
+		}
+		if mi, ok := opts.Intervals[metric]; ok {
+			sc.interval = int64(mi.Min / time.Second)
+			sc.maxGap = 3 * int64(mi.Max/time.Second)
+		}
+		if info, ok := metrics.Lookup(metric); ok {
+			sc.counter = info.Type == metrics.Counter
+		}
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			sc.observe(t/1000, v)
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+		// A series that stopped while collection kept running went silent.
+		sc.finish(lastMark)
+	}
+	if err := ss.Err(); err != nil {
+		return nil, err
+	}
+
+	report.SeriesGaps = withoutCovered(report.SeriesGaps, report.CollectionGaps)
+	report.Backfill = backfillRanges(report.CollectionGaps, report.SeriesGaps, opts.Start, opts.End)
+	report.Days = dayQuality(report, opts.Start, opts.End, s.Location())
+	return report, nil
+}
+
+// seriesScan walks the samples of one series in time order.
+type seriesScan struct {
+	report   *QualityReport
+	metric   string
+	labels   labels.Labels
+	interval int64
+	maxGap   int64
+	flatLine int64
+	counter  bool
+
+	n          int
+	prevT      int64
+	prevV      float64
+	dupStart   int64
+	dupEnd     int64
+	dupCount   int
+	flatStart  int64
+	dropStart  int64
+	dropEnd    int64
+	dropCount  int
+	dropAmount float64
+}
+
+func (sc *seriesScan) issue(start, end int64) SeriesIssue {
+	tags := make(map[string]string)
+	sc.labels.Range(func(l labels.Label) {
+		if l.Name != labels.MetricName {
+			tags[l.Name] = l.Value
+		}
+	})
+	return SeriesIssue{
+		Metric:   sc.metric,
+		Labels:   tags,
+		Start:    start,
+		End:      end,
+		Duration: (time.Duration(end-start) * time.Second).String(),
+	}
+}
+
+func (sc *seriesScan) observe(t int64, v float64) {
+	if sc.n == 0 {
+		sc.n, sc.prevT, sc.prevV, sc.flatStart = 1, t, v, t
+		return
+	}
+	sc.n++
+	dt := t - sc.prevT
+
+	if dt > sc.maxGap {
+		sc.report.SeriesGaps = append(sc.report.SeriesGaps, sc.issue(sc.prevT, t))
+	}
+
+	// Samples much closer together than the collection interval come from
+	// two overlapping sources, typically an import over collected data.
+	if dt*2 < sc.interval {
+		if sc.dupCount == 0 {
+			sc.dupStart = sc.prevT
+		}
+		sc.dupEnd = t
+		sc.dupCount++
+	} else if sc.dupCount > 0 && t-sc.dupEnd > sc.maxGap {
+		sc.flushDuplicates()
+	}
+
+	// Counters only ever increase; a decrease points at out-of-order or
+	// mixed-up imported samples.
+	if sc.counter && v < sc.prevV {
+		if sc.dropCount == 0 {
+			sc.dropStart = sc.prevT
+		}
+		sc.dropEnd = t
+		sc.dropCount++
+		sc.dropAmount += sc.prevV - v
+	} else if sc.dropCount > 0 && t-sc.dropEnd > sc.maxGap {
+		sc.flushDrops()
+	}
+
+	if v != sc.prevV || dt > sc.maxGap {
+		sc.flushFlat(sc.prevT)
+		sc.flatStart = t
+	}
+	sc.prevT, sc.prevV = t, v
+}
+
+func (sc *seriesScan) flushDuplicates() {
+	if sc.dupCount > 0 {
+		is := sc.issue(sc.dupStart, sc.dupEnd)
+		is.Samples = sc.dupCount
+		sc.report.Duplicates = append(sc.report.Duplicates, is)
+		sc.dupCount = 0
+	}
+}
+
+func (sc *seriesScan) flushDrops() {
+	if sc.dropCount > 0 {
+		is := sc.issue(sc.dropStart, sc.dropEnd)
+		is.Samples = sc.dropCount
+		is.Value = sc.dropAmount
+		sc.report.OutOfOrder = append(sc.report.OutOfOrder, is)
+		sc.dropCount, sc.dropAmount = 0, 0
+	}
+}
+
+func (sc *seriesScan) flushFlat(end int64) {
+	if flatLineMetrics[sc.metric] && sc.prevV != 0 && end-sc.flatStart >= sc.flatLine {
+		is := sc.issue(sc.flatStart, end)
+		is.Value = sc.prevV
+		sc.report.FlatLines = append(sc.report.FlatLines, is)
+	}
+}
+
+func (sc *seriesScan) finish(lastMark int64) {
+	if sc.n == 0 {
+		return
+	}
+	sc.flushDuplicates()
+	sc.flushDrops()
+	sc.flushFlat(sc.prevT)
+	if lastMark-sc.prevT > sc.maxGap {
+		sc.report.SeriesGaps = append(sc.report.SeriesGaps, sc.issue(sc.prevT, lastMark))
+	}
+}
+
+// withoutCovered drops series gaps that lie inside a collection gap.
+func withoutCovered(issues []SeriesIssue, gaps []Gap) []SeriesIssue {
+	out := []SeriesIssue{}
+	for _, is := range issues {
+		covered := false
+		for _, g := range gaps {
+			if is.Start >= g.Start && is.End <= g.End {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			out = append(out, is)
+		}
+	}
+	return out
+}
+
+// backfillRanges merges all gaps into hour-aligned ranges worth re-importing.
+func backfillRanges(collection []Gap, series []SeriesIssue, start, end int64) []Gap {
+	type span struct{ from, to int64 }
+	var spans []span
+	for _, g := range collection {
+		spans = append(spans, span{g.Start, g.End})
+	}
+	for _, is := range series {
+		spans = append(spans, span{is.Start, is.End})
+	}
+	for i := range spans {
+		spans[i].from = max(start, alignDown(spans[i].from, backfillAlign))
+		spans[i].to = min(end, alignUp(spans[i].to, backfillAlign))
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].from < spans[j].from })
+
+	out := []Gap{}
+	for _, sp := range spans {
+		if n := len(out); n > 0 && sp.from <= out[n-1].End {
+			if sp.to > out[n-1].End {
+				out[n-1] = newGap(out[n-1].Start, sp.to)
+			}
+			continue
+		}
+		out = append(out, newGap(sp.from, sp.to))
+	}
+	return out
+}
+
+// dayQuality rates each calendar day in loc touched by the range.
+func dayQuality(report *QualityReport, start, end int64, loc *time.Location) []DayQuality {
+	days := []DayQuality{}
+	t := time.Unix(start, 0).In(loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	for day.Unix() < end {
+		next := day.AddDate(0, 0, 1)
+		from, to := max(day.Unix(), start), min(next.Unix(), end)
+
+		var gapSeconds int64
+		for _, g := range report.CollectionGaps {
+			if overlap := min(g.End, to) - max(g.Start, from); overlap > 0 {
+				gapSeconds += overlap
+			}
+		}
+		drop := false
+		for _, is := range report.OutOfOrder {
+			if is.Start < to && is.End >= from {
+				drop = true
+				break
+			}
+		}
+
+		coverage := 1 - float64(gapSeconds)/float64(to-from)
+		days = append(days, DayQuality{
+			Date:        day.Format("2006-01-02"),
+			Coverage:    coverage,
+			GapSeconds:  gapSeconds,
+			CounterDrop: drop,
+			Trusted:     !drop && 1-coverage < trustedGapRatio,
+		})
+		day = next
+	}
+	return days
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"go.uber.org/zap"
+)
+
+// RawRetentionKey selects raw samples in Config.RetentionPolicies.
+const RawRetentionKey = "raw"
+
+// Tombstoning rewrites the blocks it touches, so cut-offs are applied at most
+// once per interval and aligned to whole days to avoid rewriting on every pass.
+const (
+	retentionInterval = 24 * time.Hour
+	retentionAlign    = 24 * time.Hour
+)
+
+func (s *Store) validateRetentionPolicies(policies map[string]time.Duration) error {
+	for name, d := range policies {
+		if d < 0 {
+			return fmt.Errorf("retention for %q must not be negative", name)
+		}
+		if name == RawRetentionKey {
+			continue
+		}
+		found := false
+		for _, tier := range s.tiers {
+			if tier.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown retention tier %q", name)
+		}
+	}
+	return nil
+}
+
+func (s *Store) maybeEnforceRetention() {
+	if len(s.retention) == 0 || time.Since(s.lastRetention) < retentionInterval {
+		return
+	}
+	if err := s.EnforceRetention(context.Background()); err != nil {
+		s.logger.Error("Retention enforcement failed", zap.Error(err))
+		return
+	}
+	s.lastRetention = time.Now()
+}
+
+// EnforceRetention deletes raw and rollup samples older than their configured
+// retention. Raw samples are never dropped before every tier has summarized them.
+func (s *Store) EnforceRetention(ctx context.Context) error {
+	now := time.Now()
+	deleted := false
+
+	if d := s.retention[RawRetentionKey]; d > 0 {
+		cutoff := alignDown(now.Add(-d).UnixMilli(), retentionAlign.Milliseconds())
+		for _, tier := range s.tiers {
+			cutoff = min(cutoff, s.rollupWatermark(tier))
+		}
+		if cutoff > 0 {
+			err := s.db.Delete(ctx, math.MinInt64, cutoff-1,
+				labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"),
+				labels.MustNewMatcher(labels.MatchNotRegexp, labels.MetricName, rollupPrefix+".*"),
+			)
+			if err != nil {
+				return fmt.Errorf("delete raw samples: %w", err)
+			}
+			s.logger.Info("Applied raw retention", zap.Duration("retention", d), zap.Time("cutoff", time.UnixMilli(cutoff)))
+			deleted = true
+		}
+	}
+
+	for _, tier := range s.tiers {
+		d := s.retention[tier.Name]
+		if d <= 0 {
+			continue
+		}
+		cutoff := alignDown(now.Add(-d).UnixMilli(), retentionAlign.Milliseconds())
+		err := s.db.Delete(ctx, math.MinInt64, cutoff-1,
+			labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, rollupPrefix+tier.Name+":.+"),
+		)
+		if err != nil {
+			return fmt.Errorf("delete %s rollups: %w", tier.Name, err)
+		}
+		s.logger.Info("Applied rollup retention", zap.String("tier", tier.Name), zap.Duration("retention", d), zap.Time("cutoff", time.UnixMilli(cutoff)))
+		deleted = true
+	}
+
+	if !deleted {
+		return nil
+	}
+	return s.db.CleanTombstones()
+}
@@ -0,0 +1,634 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/util/annotations"
+	"go.uber.org/zap"
+)
+
+// RollupTier is a downsampled resolution kept alongside the raw samples.
+type RollupTier struct {
+	Name       string
+	Resolution time.Duration
+}
+
+var DefaultRollupTiers = []RollupTier{
+	{Name: "5m", Resolution: 5 * time.Minute},
+	{Name: "1h", Resolution: time.Hour},
+	{Name: "1d", Resolution: 24 * time.Hour},
+}
+
+const (
+	rollupPrefix    = "rollup_"
+	rollupAggLabel  = "agg"
+	rollupStateFile = "rollups.json"
+
+	// Buckets newer than this may still receive samples from the collector.
+	rollupSettle = 2 * time.Minute
+	// Amount of raw data summarized per pass, kept a multiple of every tier.
+	rollupChunk = 7 * 24 * time.Hour
+	// Gaps longer than this are not integrated, matching raw integral queries.
+	integralMaxGap = 120
+)
+
+var rollupAggs = []string{"min", "max", "sum", "count", "integral"}
+
+// rollupFunctions are the Select functions that can be answered from rollups.
+var rollupFunctions = map[string]bool{"": true, "avg": true, "sum": true, "integral": true, "min": true, "max": true, "delta": true}
+
+type rollupState struct {
+	Watermarks map[string]int64 `json:"watermarks"`
+	// Dirty holds, per tier, the starts (ms) of rollupChunk-sized ranges whose
+	// rollups are stale because raw samples were written into them later.
+	Dirty map[string][]int64 `json:"dirty,omitempty"`
+}
+
+func rollupMetricName(tier RollupTier, metric string) string {
+	return rollupPrefix + tier.Name + ":" + metric
+}
+
+// IsRollupMetric reports whether name is a rollup series written by the store.
+func IsRollupMetric(name string) bool {
+	return strings.HasPrefix(name, rollupPrefix) && strings.Contains(name, ":")
+}
+
+// notRollup excludes rollup series from a selection.
+var notRollup = labels.MustNewMatcher(labels.MatchNotRegexp, labels.MetricName, rollupPrefix+".+:.+")
+
+func withoutRollups(ms []*labels.Matcher) []*labels.Matcher {
+	return append(slices.Clip(ms), notRollup)
+}
+
+// HideRollups wraps q so that its queriers never return rollup series, for
+// readers that list or fetch series directly rather than through Select.
+func HideRollups(q storage.Queryable) storage.Queryable {
+	return storage.QueryableFunc(func(mint, maxt int64) (storage.Querier, error) {
+		inner, err := q.Querier(mint, maxt)
+		if err != nil {
+			return nil, err
+		}
+		return rollupHidingQuerier{inner}, nil
+	})
+}
+
+// HideRollupChunks is HideRollups for a queryable that also serves chunks.
+func HideRollupChunks(q storage.SampleAndChunkQueryable) storage.SampleAndChunkQueryable {
+	return rollupHidingQueryable{q}
+}
+
+type rollupHidingQueryable struct {
+	storage.SampleAndChunkQueryable
+}
+
+func (q rollupHidingQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	return HideRollups(q.SampleAndChunkQueryable).Querier(mint, maxt)
+}
+
+func (q rollupHidingQueryable) ChunkQuerier(mint, maxt int64) (storage.ChunkQuerier, error) {
+	inner, err := q.SampleAndChunkQueryable.ChunkQuerier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	return rollupHidingChunkQuerier{inner}, nil
+}
+
+type rollupHidingQuerier struct {
+	storage.Querier
+}
+
+func (q rollupHidingQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, ms ...*labels.Matcher) storage.SeriesSet {
+	return q.Querier.Select(ctx, sortSeries, hints, withoutRollups(ms)...)
+}
+
+func (q rollupHidingQuerier) LabelValues(ctx context.Context, name string, hints *storage.LabelHints, ms ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	return q.Querier.LabelValues(ctx, name, hints, withoutRollups(ms)...)
+}
+
+func (q rollupHidingQuerier) LabelNames(ctx context.Context, hints *storage.LabelHints, ms ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	return q.Querier.LabelNames(ctx, hints, withoutRollups(ms)...)
+}
+
+type rollupHidingChunkQuerier struct {
+	storage.ChunkQuerier
+}
+
+func (q rollupHidingChunkQuerier) Select(ctx context.Context, sortSeries bool, hints *storage.SelectHints, ms ...*labels.Matcher) storage.ChunkSeriesSet {
+	return q.ChunkQuerier.Select(ctx, sortSeries, hints, withoutRollups(ms)...)
+}
+
+func (q rollupHidingChunkQuerier) LabelValues(ctx context.Context, name string, hints *storage.LabelHints, ms ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	return q.ChunkQuerier.LabelValues(ctx, name, hints, withoutRollups(ms)...)
+}
+
+func (q rollupHidingChunkQuerier) LabelNames(ctx context.Context, hints *storage.LabelHints, ms ...*labels.Matcher) ([]string, annotations.Annotations, error) {
+	return q.ChunkQuerier.LabelNames(ctx, hints, withoutRollups(ms)...)
+}
+
+func (s *Store) loadRollupState() {
+	s.watermarks = make(map[string]int64)
+	s.dirty = make(map[string]map[int64]bool)
+	data, err := os.ReadFile(filepath.Join(s.dataPath, rollupStateFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.logger.Warn("Failed to read rollup state", zap.Error(err))
+		}
+		return
+	}
+	var st rollupState
+	if err := json.Unmarshal(data, &st); err != nil {
+		s.logger.Warn("Failed to parse rollup state", zap.Error(err))
+		return
+	}
+	for k, v := range st.Watermarks {
+		s.watermarks[k] = v
+	}
+	for k, starts := range st.Dirty {
+		s.dirty[k] = make(map[int64]bool, len(starts))
+		for _, c := range starts {
+			s.dirty[k][c] = true
+		}
+	}
+}
+
+func (s *Store) saveRollupState() error {
+	return s.saveRollupStateTo(filepath.Join(s.dataPath, rollupStateFile))
+}
+
+func (s *Store) saveRollupStateTo(path string) error {
+	s.rollupMu.Lock()
+	defer s.rollupMu.Unlock()
+	st := rollupState{Watermarks: s.watermarks, Dirty: make(map[string][]int64, len(s.dirty))}
+	for k, chunks := range s.dirty {
+		for c := range chunks {
+			st.Dirty[k] = append(st.Dirty[k], c)
+		}
+		slices.Sort(st.Dirty[k])
+	}
+	return writeJSONFile(path, st)
+}
+
+// rollupWatermark returns the end (ms) of the range already summarized for tier.
+func (s *Store) rollupWatermark(tier RollupTier) int64 {
+	s.rollupMu.RLock()
+	defer s.rollupMu.RUnlock()
+	return s.watermarks[tier.Name]
+}
+
+func (s *Store) setRollupWatermark(tier RollupTier, wm int64) {
+	s.rollupMu.Lock()
+	s.watermarks[tier.Name] = wm
+	s.rollupMu.Unlock()
+}
+
+// markDirty records that raw samples in [mint, maxt] ms were committed. Ranges
+// a tier has already summarized are rebuilt by the next rollup pass.
+func (s *Store) markDirty(mint, maxt int64) {
+	chunk := rollupChunk.Milliseconds()
+	changed := false
+	s.rollupMu.Lock()
+	for _, tier := range s.tiers {
+		// Only samples behind the watermark land in summarized buckets.
+		wm := s.watermarks[tier.Name]
+		if mint >= wm {
+			continue
+		}
+		for c, end := alignDown(mint, chunk), min(maxt, wm-1); c <= end; c += chunk {
+			if s.dirty[tier.Name] == nil {
+				s.dirty[tier.Name] = make(map[int64]bool)
+			}
+			if !s.dirty[tier.Name][c] {
+				s.dirty[tier.Name][c] = true
+				changed = true
+			}
+		}
+	}
+	s.rollupMu.Unlock()
+	if changed {
+		if err := s.saveRollupState(); err != nil {
+			s.logger.Error("Failed to save rollup state", zap.Error(err))
+		}
+	}
+}
+
+// takeDirty removes and returns the dirty chunk starts of tier, oldest first.
+func (s *Store) takeDirty(tier RollupTier) []int64 {
+	s.rollupMu.Lock()
+	defer s.rollupMu.Unlock()
+	chunks := make([]int64, 0, len(s.dirty[tier.Name]))
+	for c := range s.dirty[tier.Name] {
+		chunks = append(chunks, c)
+	}
+	delete(s.dirty, tier.Name)
+	slices.Sort(chunks)
+	return chunks
+}
+
+// clearDirty forgets the dirty chunks of tier that lie within [from, to) ms.
+func (s *Store) clearDirty(tier RollupTier, from, to int64) {
+	chunk := rollupChunk.Milliseconds()
+	s.rollupMu.Lock()
+	defer s.rollupMu.Unlock()
+	for c := range s.dirty[tier.Name] {
+		if c >= from && c+chunk <= to {
+			delete(s.dirty[tier.Name], c)
+		}
+	}
+}
+
+// rangeAppender tracks the time range of the samples appended through it.
+type rangeAppender struct {
+	storage.Appender
+	mint, maxt int64
+}
+
+func (a *rangeAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	ref, err := a.Appender.Append(ref, l, t, v)
+	if err == nil {
+		a.mint, a.maxt = min(a.mint, t), max(a.maxt, t)
+	}
+	return ref, err
+}
+
+// StartRollups runs the rollup and retention worker until the store is closed.
+func (s *Store) StartRollups(interval time.Duration) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.runRollups()
+			s.maybeEnforceRetention()
+			select {
+			case <-ticker.C:
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Store) runRollups() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for _, tier := range s.tiers {
+		res := tier.Resolution.Milliseconds()
+		end := alignDown(time.Now().Add(-rollupSettle).UnixMilli(), res)
+
+		wm := s.rollupWatermark(tier)
+		if wm == 0 {
+			minT, ok := s.minTime()
+			if !ok {
+				continue
+			}
+			wm = alignDown(minT, res)
+			s.logger.Info("Backfilling rollup tier", zap.String("tier", tier.Name), zap.Time("from", time.UnixMilli(wm)))
+		}
+
+		if dirty := s.takeDirty(tier); len(dirty) > 0 {
+			s.logger.Info("Rebuilding stale rollups", zap.String("tier", tier.Name), zap.Int("chunks", len(dirty)))
+			if err := s.rebuildChunks(ctx, tier, dirty); err != nil {
+				if !errors.Is(err, context.Canceled) {
+					s.logger.Error("Rollup rebuild failed", zap.String("tier", tier.Name), zap.Error(err))
+				}
+				return
+			}
+		}
+
+		for wm < end {
+			chunkEnd := min(wm+alignUp(rollupChunk.Milliseconds(), res), end)
+			n, err := s.rollupRange(ctx, tier, wm, chunkEnd)
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					s.logger.Error("Rollup failed", zap.String("tier", tier.Name), zap.Error(err))
+				}
+				return
+			}
+			wm = chunkEnd
+			s.setRollupWatermark(tier, wm)
+			if err := s.saveRollupState(); err != nil {
+				s.logger.Error("Failed to save rollup state", zap.Error(err))
+			}
+			s.logger.Debug("Rollup pass complete", zap.String("tier", tier.Name), zap.Int("buckets", n), zap.Time("watermark", time.UnixMilli(wm)))
+		}
+	}
+}
+
+// rebuildChunks rebuilds the rollups of tier in the given rollupChunk starts.
+// Chunks that could not be rebuilt are marked dirty again.
+func (s *Store) rebuildChunks(ctx context.Context, tier RollupTier, chunks []int64) error {
+	s.modifyMu.Lock()
+	defer s.modifyMu.Unlock()
+
+	chunk := rollupChunk.Milliseconds()
+	for i, c := range chunks {
+		if err := s.rebuildRollups(ctx, tier, c, c+chunk); err != nil {
+			s.rollupMu.Lock()
+			if s.dirty[tier.Name] == nil {
+				s.dirty[tier.Name] = make(map[int64]bool)
+			}
+			for _, c := range chunks[i:] {
+				s.dirty[tier.Name][c] = true
+			}
+			s.rollupMu.Unlock()
+			return err
+		}
+	}
+	return s.saveRollupState()
+}
+
+// BackfillRollups recomputes the rollups of every tier in [start, end) from
+// the raw samples, replacing any that exist. Used after out-of-order imports.
+func (s *Store) BackfillRollups(ctx context.Context, start, end time.Time) error {
+	s.modifyMu.Lock()
+	defer s.modifyMu.Unlock()
+	return s.backfillRollups(ctx, start, end)
+}
+
+// backfillRollups is BackfillRollups with modifyMu held.
+func (s *Store) backfillRollups(ctx context.Context, start, end time.Time) error {
+	for _, tier := range s.tiers {
+		if err := s.rebuildRollups(ctx, tier, start.UnixMilli(), end.UnixMilli()); err != nil {
+			return err
+		}
+		s.clearDirty(tier, start.UnixMilli(), end.UnixMilli())
+	}
+	return s.saveRollupState()
+}
+
+// rebuildRollups deletes the rollups of tier in [from, to) ms, widened to
+// whole buckets, and computes them again. Buckets past the tier's watermark
+// are left to the regular rollup pass. Where raw retention may already have
+// dropped samples, rollups are kept and only missing buckets are filled in.
+// modifyMu must be held.
+func (s *Store) rebuildRollups(ctx context.Context, tier RollupTier, from, to int64) error {
+	res := tier.Resolution.Milliseconds()
+	from = alignDown(from, res)
+	to = min(alignUp(to, res), s.rollupWatermark(tier))
+	if from >= to {
+		return nil
+	}
+	if cut, ok := s.rawCutoff(); ok && from < alignUp(cut, res) {
+		if err := s.summarize(ctx, tier, from, min(to, alignUp(cut, res))); err != nil {
+			return err
+		}
+		if from = alignUp(cut, res); from >= to {
+			return nil
+		}
+	}
+	// Head tombstones would also hide the rollups written afterwards.
+	if _, err := s.persistHead(ctx); err != nil {
+		return err
+	}
+	rollups := labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, rollupPrefix+tier.Name+":.+")
+	if err := s.db.Delete(ctx, from, to-1, rollups); err != nil {
+		return fmt.Errorf("delete %s rollups: %w", tier.Name, err)
+	}
+	if err := s.db.CleanTombstones(); err != nil {
+		return fmt.Errorf("clean tombstones: %w", err)
+	}
+	return s.summarize(ctx, tier, from, to)
+}
+
+// summarize writes the missing rollups of tier in the bucket-aligned range
+// [from, to) ms, one rollupChunk at a time.
+func (s *Store) summarize(ctx context.Context, tier RollupTier, from, to int64) error {
+	res := tier.Resolution.Milliseconds()
+	for from < to {
+		chunkEnd := min(from+alignUp(rollupChunk.Milliseconds(), res), to)
+		n, err := s.rollupRange(ctx, tier, from, chunkEnd)
+		if err != nil {
+			return fmt.Errorf("rollup %s: %w", tier.Name, err)
+		}
+		s.logger.Debug("Rollup rebuild", zap.String("tier", tier.Name), zap.Int("buckets", n), zap.Time("from", time.UnixMilli(from)))
+		from = chunkEnd
+	}
+	return nil
+}
+
+// rawCutoff returns the time (ms) before which raw retention may have deleted
+// samples, if raw retention is configured.
+func (s *Store) rawCutoff() (int64, bool) {
+	d := s.retention[RawRetentionKey]
+	if d <= 0 {
+		return 0, false
+	}
+	return alignDown(time.Now().Add(-d).UnixMilli(), retentionAlign.Milliseconds()), true
+}
+
+type rollupAgg struct {
+	min, max, sum, integral float64
+	count                   int
+}
+
+// rollupRange writes rollups for the tier-aligned range [from, to) in ms.
+// Buckets that already have a rollup are left untouched.
+func (s *Store) rollupRange(ctx context.Context, tier RollupTier, from, to int64) (int, error) {
+	res := tier.Resolution.Milliseconds()
+
+	existing, err := s.existingRollups(ctx, tier, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	q, err := s.db.Querier(from-integralMaxGap*1000, to-1)
+	if err != nil {
+		return 0, err
+	}
+	defer q.Close()
+
+	ss := q.Select(ctx, false, nil,
+		labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"),
+		labels.MustNewMatcher(labels.MatchNotRegexp, labels.MetricName, rollupPrefix+".*"),
+	)
+
+	written := 0
+	for ss.Next() {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		lset := ss.At().Labels()
+		buckets := make(map[int64]*rollupAgg)
+		var prevT int64
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			if t < from {
+				prevT = t
+				continue
+			}
+			bt := alignDown(t, res)
+			b, ok := buckets[bt]
+			if !ok {
+				b = &rollupAgg{min: v, max: v}
+				buckets[bt] = b
+			}
+			b.sum += v
+			b.count++
+			b.min = math.Min(b.min, v)
+			b.max = math.Max(b.max, v)
+			if prevT > 0 {
+				if dt := (t - prevT) / 1000; dt > 0 && dt <= integralMaxGap {
+					b.integral += v * float64(dt)
+				}
+			}
+			prevT = t
+		}
+		if err := it.Err(); err != nil {
+			return written, err
+		}
+
+		name := rollupMetricName(tier, lset.Get(labels.MetricName))
+		app := s.db.Appender(ctx)
+		for bt, b := range buckets {
+			key := seriesKey(name, lset) + "@" + fmt.Sprint(bt)
+			if existing[key] {
+				continue
+			}
+			values := map[string]float64{"min": b.min, "max": b.max, "sum": b.sum, "count": float64(b.count), "integral": b.integral}
+			for _, agg := range rollupAggs {
+				lb := labels.NewBuilder(lset)
+				lb.Set(labels.MetricName, name)
+				lb.Set(rollupAggLabel, agg)
+				if _, err := app.Append(0, lb.Labels(), bt, values[agg]); err != nil {
+					s.logger.Debug("Rollup append failed", zap.String("metric", name), zap.Error(err))
+				}
+			}
+			written++
+		}
+		if err := app.Commit(); err != nil {
+			return written, err
+		}
+	}
+	return written, ss.Err()
+}
+
+// existingRollups returns the buckets in [from, to) that already have a rollup,
+// keyed by series and bucket timestamp.
+func (s *Store) existingRollups(ctx context.Context, tier RollupTier, from, to int64) (map[string]bool, error) {
+	q, err := s.db.Querier(from, to-1)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	ss := q.Select(ctx, false, nil,
+		labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, rollupPrefix+tier.Name+":.+"),
+		labels.MustNewMatcher(labels.MatchEqual, rollupAggLabel, "count"),
+	)
+	existing := make(map[string]bool)
+	for ss.Next() {
+		lset := ss.At().Labels()
+		key := seriesKey(lset.Get(labels.MetricName), labels.NewBuilder(lset).Del(rollupAggLabel).Labels())
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, _ := it.At()
+			existing[key+"@"+fmt.Sprint(t)] = true
+		}
+	}
+	return existing, ss.Err()
+}
+
+// seriesKey identifies a series by name and its non-name labels.
+func seriesKey(name string, lset labels.Labels) string {
+	var parts []string
+	lset.Range(func(l labels.Label) {
+		if l.Name != labels.MetricName {
+			parts = append(parts, l.Name+"="+l.Value)
+		}
+	})
+	return name + "{" + strings.Join(parts, ",") + "}"
+}
+
+// rollupTierFor picks the coarsest tier whose buckets nest inside the
+// requested step buckets and that has been summarized past start.
+func (s *Store) rollupTierFor(start, end int64, b bucketer, function string) (RollupTier, int64, bool) {
+	if !rollupFunctions[function] {
+		return RollupTier{}, 0, false
+	}
+	_, startOffset := time.Unix(start, 0).In(b.loc).Zone()
+	_, endOffset := time.Unix(end, 0).In(b.loc).Zone()
+
+	for i := len(s.tiers) - 1; i >= 0; i-- {
+		tier := s.tiers[i]
+		res := int64(tier.Resolution / time.Second)
+		if !b.nests(res) {
+			continue
+		}
+		if int64(startOffset)%res != 0 || int64(endOffset)%res != 0 {
+			continue
+		}
+		wm := s.rollupWatermark(tier) / 1000
+		if wm <= start {
+			continue
+		}
+		return tier, wm, true
+	}
+	return RollupTier{}, 0, false
+}
+
+// accumulateRollup merges rollup buckets of tier for [from, to) seconds into buckets.
+func (s *Store) accumulateRollup(q storage.Querier, tier RollupTier, metric string, matchers []*labels.Matcher, from, to int64, bk bucketer, buckets map[int64]*bucketData) error {
+	ms := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, rollupMetricName(tier, metric))}
+	for _, m := range matchers {
+		if m.Name != labels.MetricName {
+			ms = append(ms, m)
+		}
+	}
+
+	ss := q.Select(context.Background(), false, nil, ms...)
+	for ss.Next() {
+		agg := ss.At().Labels().Get(rollupAggLabel)
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			tSec := t / 1000
+			if tSec < from || tSec >= to {
+				continue
+			}
+			b := bucketFor(buckets, tSec, bk)
+			switch agg {
+			case "min":
+				b.observe(v)
+			case "max":
+				b.observe(v)
+			case "sum":
+				b.sum += v
+			case "count":
+				b.count += int(v)
+			case "integral":
+				b.integral += v
+			}
+		}
+	}
+	return ss.Err()
+}
+
+func alignDown(t, res int64) int64 {
+	return t - ((t%res)+res)%res
+}
+
+func alignUp(t, res int64) int64 {
+	return alignDown(t+res-1, res)
+}
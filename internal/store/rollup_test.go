@@ -0,0 +1,334 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"go.uber.org/zap"
+)
+
+func newTestStore(t *testing.T, cfg Config) *Store {
+	t.Helper()
+	cfg.DataPath = t.TempDir()
+	s, err := NewStore(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.SetLocation(time.UTC)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+var testSite = []Label{{Name: "site", Value: "load"}}
+
+// insertMinutes writes n samples of power_watts{site="load"}, one a minute
+// from start (unix seconds), with value 10*i.
+func insertMinutes(t *testing.T, s *Store, start int64, n int) {
+	t.Helper()
+	for i := range n {
+		if err := s.Insert(metrics.PowerWatts, testSite, float64(10*i), start+int64(60*i)); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+}
+
+// rollupValue returns the agg rollup of power_watts{site="load"} in the tier
+// bucket starting at bucket (unix seconds), or -1 if there is none.
+func rollupValue(t *testing.T, s *Store, tier, agg string, bucket int64) float64 {
+	t.Helper()
+	q, err := s.db.Querier(bucket*1000, bucket*1000)
+	if err != nil {
+		t.Fatalf("Querier: %v", err)
+	}
+	defer q.Close()
+	ss := q.Select(context.Background(), false, nil,
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, rollupPrefix+tier+":"+metrics.PowerWatts),
+		labels.MustNewMatcher(labels.MatchEqual, "site", "load"),
+		labels.MustNewMatcher(labels.MatchEqual, rollupAggLabel, agg),
+	)
+	v := -1.0
+	for ss.Next() {
+		it := ss.At().Iterator(nil)
+		for it.Next() == chunkenc.ValFloat {
+			_, v = it.At()
+		}
+	}
+	if err := ss.Err(); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	return v
+}
+
+// rawCount returns the number of raw power_watts samples in [start, end] seconds.
+func rawCount(t *testing.T, s *Store, start, end int64) int {
+	t.Helper()
+	n, err := s.countSamples(context.Background(), []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metrics.PowerWatts),
+	}, start*1000, end*1000)
+	if err != nil {
+		t.Fatalf("countSamples: %v", err)
+	}
+	return n
+}
+
+// testDay is the start of the UTC day three days ago.
+func testDay() int64 {
+	return alignDown(time.Now().Add(-72*time.Hour).Unix(), 86400)
+}
+
+func TestRollupTierFor(t *testing.T) {
+	s := newTestStore(t, Config{})
+	day := testDay()
+	s.watermarks = map[string]int64{"5m": (day + 2*86400) * 1000, "1h": (day + 86400) * 1000, "1d": day * 1000}
+
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		start    int64
+		step     Step
+		function string
+		want     string
+	}{
+		{"five minute step", day, Step{Seconds: 300}, "avg", "5m"},
+		{"hourly step", day, Step{Seconds: 3600}, "sum", "1h"},
+		{"two hour step", day, Step{Seconds: 7200}, "max", "1h"},
+		{"daily step past day watermark", day, Step{Seconds: 86400}, "integral", "1h"},
+		{"daily step before day watermark", day - 86400, Step{Seconds: 86400}, "integral", "1d"},
+		{"calendar day", day - 86400, Step{Unit: UnitDay}, "", "1d"},
+		{"step finer than every tier", day, Step{Seconds: 60}, "avg", ""},
+		{"step not a multiple of a tier", day, Step{Seconds: 450}, "avg", ""},
+		{"unsupported function", day, Step{Seconds: 3600}, "last", ""},
+		{"past every watermark", day + 3*86400, Step{Seconds: 300}, "avg", ""},
+		{"half hour zone offset", day, Step{Seconds: 3600, Location: kolkata}, "avg", "5m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier, wm, ok := s.rollupTierFor(tt.start, tt.start+86400, s.bucketer(tt.step), tt.function)
+			if tt.want == "" {
+				if ok {
+					t.Fatalf("got tier %s, want raw samples", tier.Name)
+				}
+				return
+			}
+			if !ok || tier.Name != tt.want {
+				t.Fatalf("got tier %q (ok=%v), want %q", tier.Name, ok, tt.want)
+			}
+			if wm != s.watermarks[tt.want]/1000 {
+				t.Errorf("got watermark %d, want %d", wm, s.watermarks[tt.want]/1000)
+			}
+		})
+	}
+}
+
+func TestRunRollupsAdvancesWatermark(t *testing.T) {
+	s := newTestStore(t, Config{})
+	day := testDay()
+	insertMinutes(t, s, day, 180)
+
+	s.runRollups()
+
+	settled := time.Now().Add(-rollupSettle).UnixMilli()
+	for _, tier := range s.tiers {
+		want := alignDown(settled, tier.Resolution.Milliseconds())
+		if got := s.rollupWatermark(tier); got != want {
+			t.Errorf("%s watermark = %d, want %d", tier.Name, got, want)
+		}
+	}
+
+	tests := []struct {
+		tier, agg string
+		bucket    int64
+		want      float64
+	}{
+		{"5m", "count", day, 5},
+		{"5m", "sum", day, 0 + 10 + 20 + 30 + 40},
+		{"1h", "count", day + 3600, 60},
+		{"1h", "min", day + 3600, 600},
+		{"1h", "max", day + 3600, 1190},
+		{"1d", "count", day, 180},
+		{"1d", "sum", day, 10 * 179 * 180 / 2},
+	}
+	for _, tt := range tests {
+		if got := rollupValue(t, s, tt.tier, tt.agg, tt.bucket); got != tt.want {
+			t.Errorf("%s %s at %d = %v, want %v", tt.tier, tt.agg, tt.bucket, got, tt.want)
+		}
+	}
+
+	// Queries over summarized buckets are answered from the rollups.
+	pts, err := s.Select(metrics.PowerWatts, map[string]string{"site": "load"}, day, day+3*3600-1, Step{Seconds: 3600}, "max")
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if len(pts) != 3 || pts[2].Value != 1790 {
+		t.Errorf("hourly max = %+v, want 3 points ending at 1790", pts)
+	}
+}
+
+func TestLateWritesRebuildRollups(t *testing.T) {
+	s := newTestStore(t, Config{})
+	day := testDay()
+	insertMinutes(t, s, day, 120)
+	s.runRollups()
+
+	// An import between two collected samples lands in summarized buckets.
+	if err := s.Insert(metrics.PowerWatts, testSite, 5000, day+30); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if len(s.dirty) == 0 {
+		t.Fatal("late write did not mark rollups dirty")
+	}
+	s.runRollups()
+	if len(s.dirty) != 0 {
+		t.Errorf("dirty chunks left after rebuild: %v", s.dirty)
+	}
+	tests := []struct {
+		tier, agg string
+		want      float64
+	}{
+		{"5m", "count", 6},
+		{"5m", "max", 5000},
+		{"1h", "count", 61},
+		{"1d", "count", 121},
+		{"1d", "max", 5000},
+	}
+	for _, tt := range tests {
+		if got := rollupValue(t, s, tt.tier, tt.agg, day); got != tt.want {
+			t.Errorf("%s %s = %v, want %v", tt.tier, tt.agg, got, tt.want)
+		}
+	}
+	// Buckets without late writes keep their values.
+	if got := rollupValue(t, s, "1h", "count", day+3600); got != 60 {
+		t.Errorf("untouched 1h count = %v, want 60", got)
+	}
+
+	// BackfillRollups replaces existing rollups as well.
+	if err := s.Insert(metrics.PowerWatts, testSite, 7000, day+90); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if err := s.BackfillRollups(context.Background(), time.Unix(day, 0), time.Unix(day+3600, 0)); err != nil {
+		t.Fatalf("BackfillRollups: %v", err)
+	}
+	if got := rollupValue(t, s, "1h", "count", day); got != 62 {
+		t.Errorf("1h count after backfill = %v, want 62", got)
+	}
+	if got := rollupValue(t, s, "1h", "max", day); got != 7000 {
+		t.Errorf("1h max after backfill = %v, want 7000", got)
+	}
+}
+
+func TestMarkDirty(t *testing.T) {
+	s := newTestStore(t, Config{})
+	day := testDay()
+	insertMinutes(t, s, day, 60)
+	s.runRollups()
+
+	// Live samples after every watermark leave the rollups alone.
+	if err := s.Insert(metrics.PowerWatts, testSite, 100, time.Now().Unix()); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if len(s.dirty) != 0 {
+		t.Fatalf("insert after the watermarks marked %v dirty", s.dirty)
+	}
+
+	// An import behind them marks its chunk in every tier.
+	if err := s.Insert(metrics.PowerWatts, testSite, 100, day+90); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	want := alignDown(day*1000, rollupChunk.Milliseconds())
+	for _, tier := range s.tiers {
+		if len(s.dirty[tier.Name]) != 1 || !s.dirty[tier.Name][want] {
+			t.Errorf("%s dirty chunks = %v, want [%d]", tier.Name, s.dirty[tier.Name], want)
+		}
+	}
+}
+
+func TestRawRetentionWaitsForRollups(t *testing.T) {
+	s := newTestStore(t, Config{RetentionPolicies: map[string]time.Duration{RawRetentionKey: 24 * time.Hour}})
+	day := testDay()
+	insertMinutes(t, s, day, 60)
+	ctx := context.Background()
+
+	// Nothing is summarized yet, so raw samples must stay.
+	if err := s.EnforceRetention(ctx); err != nil {
+		t.Fatalf("EnforceRetention: %v", err)
+	}
+	if n := rawCount(t, s, day, day+3600); n != 60 {
+		t.Fatalf("raw samples before rollups = %d, want 60", n)
+	}
+
+	s.runRollups()
+	if err := s.EnforceRetention(ctx); err != nil {
+		t.Fatalf("EnforceRetention: %v", err)
+	}
+	if n := rawCount(t, s, day, day+3600); n != 0 {
+		t.Errorf("raw samples after retention = %d, want 0", n)
+	}
+	if got := rollupValue(t, s, "1h", "count", day); got != 60 {
+		t.Fatalf("1h count after retention = %v, want 60", got)
+	}
+
+	// A late write behind the raw cut-off must not replace rollups whose raw
+	// samples are gone.
+	if err := s.Insert(metrics.PowerWatts, testSite, 5000, day+30); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	s.runRollups()
+	if got := rollupValue(t, s, "1h", "count", day); got != 60 {
+		t.Errorf("1h count after late write = %v, want 60", got)
+	}
+}
+
+func TestHideRollups(t *testing.T) {
+	s := newTestStore(t, Config{})
+	day := testDay()
+	insertMinutes(t, s, day, 60)
+	s.runRollups()
+	ctx := context.Background()
+
+	q, err := s.Queryable().Querier(day*1000, (day+86400)*1000)
+	if err != nil {
+		t.Fatalf("Querier: %v", err)
+	}
+	defer q.Close()
+	hq, err := HideRollups(s.Queryable()).Querier(day*1000, (day+86400)*1000)
+	if err != nil {
+		t.Fatalf("Querier: %v", err)
+	}
+	defer hq.Close()
+
+	all, _, err := q.LabelValues(ctx, labels.MetricName, nil)
+	if err != nil {
+		t.Fatalf("LabelValues: %v", err)
+	}
+	hidden, _, err := hq.LabelValues(ctx, labels.MetricName, nil)
+	if err != nil {
+		t.Fatalf("LabelValues: %v", err)
+	}
+	if len(all) <= len(hidden) {
+		t.Fatalf("no rollups in %v", all)
+	}
+	if len(hidden) != 1 || hidden[0] != metrics.PowerWatts {
+		t.Errorf("names with rollups hidden = %v, want [%s]", hidden, metrics.PowerWatts)
+	}
+
+	ss := hq.Select(ctx, false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for ss.Next() {
+		if name := ss.At().Labels().Get(labels.MetricName); IsRollupMetric(name) {
+			t.Errorf("Select returned rollup series %s", name)
+		}
+	}
+	if err := ss.Err(); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+
+	if all := s.GetAllSeries(); len(all) != 1 || all[metrics.PowerWatts] == nil {
+		t.Errorf("GetAllSeries lists %v, want only %s", all, metrics.PowerWatts)
+	}
+}
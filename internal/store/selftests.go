@@ -0,0 +1,179 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	selfTestsFile = "self_tests.json"
+	// maxSelfTestRuns bounds the history; the oldest runs go first.
+	maxSelfTestRuns = 1000
+)
+
+// Kinds of self-test runs.
+const (
+	SelfTestInverter       = "inverter"
+	SelfTestProtectionTrip = "protection_trip"
+)
+
+// SelfTestRun is one completed inverter self-test or protection-trip test
+// run. Device is the inverter DIN for inverter self-tests and empty for
+// protection-trip tests, which cover the whole system. Time is when the last
+// test of the run finished, in unix seconds.
+type SelfTestRun struct {
+	ID       string           `json:"id"`
+	Kind     string           `json:"kind"`
+	Device   string           `json:"device,omitempty"`
+	Time     int64            `json:"time"`
+	Passed   bool             `json:"passed"`
+	Results  []SelfTestResult `json:"results"`
+	Recorded int64            `json:"recorded"`
+}
+
+// SelfTestResult is one test of a run, such as an over-voltage trip test.
+type SelfTestResult struct {
+	Test      string `json:"test"`
+	Status    string `json:"status"`
+	Passed    bool   `json:"passed"`
+	Summary   string `json:"summary,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	Time      int64  `json:"time,omitempty"`
+	// Measurements holds the trip magnitudes, times and accuracies by name,
+	// e.g. "trip_time".
+	Measurements map[string]Measurement `json:"measurements,omitempty"`
+}
+
+type Measurement struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// SelfTestFilter selects runs finished within [Start, End] seconds. Zero
+// bounds are open; empty Kind and Device match any.
+type SelfTestFilter struct {
+	Kind   string
+	Device string
+	Start  int64
+	End    int64
+	// Limit caps the result to the newest runs; zero returns all.
+	Limit int
+}
+
+func (r *SelfTestRun) matches(f SelfTestFilter) bool {
+	return (f.Kind == "" || r.Kind == f.Kind) &&
+		(f.Device == "" || r.Device == f.Device) &&
+		(f.Start == 0 || r.Time >= f.Start) &&
+		(f.End == 0 || r.Time <= f.End)
+}
+
+// SelfTestComparison compares a run with the previous run of the same kind
+// on the same device.
+type SelfTestComparison struct {
+	PreviousID   string               `json:"previous_id"`
+	PreviousTime int64                `json:"previous_time"`
+	Tests        []SelfTestTestChange `json:"tests"`
+}
+
+// SelfTestTestChange reports how one test changed since the previous run.
+// Deltas holds current minus previous for measurements present in both.
+type SelfTestTestChange struct {
+	Test           string             `json:"test"`
+	Status         string             `json:"status"`
+	PreviousStatus string             `json:"previous_status,omitempty"`
+	StatusChanged  bool               `json:"status_changed"`
+	Deltas         map[string]float64 `json:"deltas,omitempty"`
+}
+
+// CompareSelfTestRuns compares cur with prev test by test.
+func CompareSelfTestRuns(cur, prev SelfTestRun) SelfTestComparison {
+	before := make(map[string]SelfTestResult, len(prev.Results))
+	for _, r := range prev.Results {
+		before[r.Test] = r
+	}
+	cmp := SelfTestComparison{PreviousID: prev.ID, PreviousTime: prev.Time, Tests: []SelfTestTestChange{}}
+	for _, r := range cur.Results {
+		ch := SelfTestTestChange{Test: r.Test, Status: r.Status}
+		if p, ok := before[r.Test]; ok {
+			ch.PreviousStatus = p.Status
+			ch.StatusChanged = p.Status != r.Status
+			for name, m := range r.Measurements {
+				if pm, ok := p.Measurements[name]; ok && pm.Unit == m.Unit {
+					if ch.Deltas == nil {
+						ch.Deltas = make(map[string]float64)
+					}
+					ch.Deltas[name] = m.Value - pm.Value
+				}
+			}
+		}
+		cmp.Tests = append(cmp.Tests, ch)
+	}
+	return cmp
+}
+
+func (e *engine) loadSelfTests() {
+	data, err := os.ReadFile(e.selfTestsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			e.logger.Warn("Failed to read self-test history", zap.Error(err))
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &e.selfTests); err != nil {
+		e.logger.Warn("Failed to parse self-test history", zap.Error(err))
+	}
+}
+
+func (e *engine) saveSelfTestsTo(path string) error {
+	e.selfTestMu.Lock()
+	defer e.selfTestMu.Unlock()
+	return writeJSONFile(path, e.selfTests)
+}
+
+// saveSelfTests persists the runs when the storage has a path for them.
+// selfTestMu must be held, so saves land in the order of the changes.
+func (e *engine) saveSelfTests() error {
+	if e.selfTestsPath == "" {
+		return nil
+	}
+	return writeJSONFile(e.selfTestsPath, e.selfTests)
+}
+
+// AddSelfTestRun stores a completed run and returns it with its ID and
+// recording time filled in.
+func (e *engine) AddSelfTestRun(r SelfTestRun) (SelfTestRun, error) {
+	r.ID = ulid.Make().String()
+	r.Recorded = time.Now().Unix()
+
+	e.selfTestMu.Lock()
+	defer e.selfTestMu.Unlock()
+	e.selfTests = append(e.selfTests, r)
+	if len(e.selfTests) > maxSelfTestRuns {
+		sort.SliceStable(e.selfTests, func(i, j int) bool { return e.selfTests[i].Time < e.selfTests[j].Time })
+		e.selfTests = e.selfTests[len(e.selfTests)-maxSelfTestRuns:]
+	}
+	return r, e.saveSelfTests()
+}
+
+// SelfTestRuns returns the runs matching f, newest first.
+func (e *engine) SelfTestRuns(f SelfTestFilter) []SelfTestRun {
+	e.selfTestMu.Lock()
+	defer e.selfTestMu.Unlock()
+
+	result := []SelfTestRun{}
+	for _, r := range e.selfTests {
+		if r.matches(f) {
+			result = append(result, r)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time > result[j].Time })
+	if f.Limit > 0 && len(result) > f.Limit {
+		result = result[:f.Limit]
+	}
+	return result
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// Sample is a single appended value. T is in milliseconds.
+type Sample struct {
+	Labels labels.Labels
+	T      int64
+	V      float64
+}
+
+// Sink receives the samples of each committed insert batch. Write is called
+// synchronously from the insert path and must not block; sinks must not
+// modify the slice, which is shared between them.
+type Sink interface {
+	Write(samples []Sample)
+}
+
+// recordingAppender keeps a copy of every appended sample so it can be handed
+// to the sinks once the batch is committed.
+type recordingAppender struct {
+	storage.Appender
+	samples []Sample
+}
+
+func (a *recordingAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	ref, err := a.Appender.Append(ref, l, t, v)
+	if err == nil {
+		a.samples = append(a.samples, Sample{Labels: l, T: t, V: v})
+	}
+	return ref, err
+}
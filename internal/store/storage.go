@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/prometheus/storage"
+	"go.uber.org/zap"
+)
+
+// Storage is what the collectors, the importer and the API read and write
+// through. *Store keeps samples in a Prometheus TSDB on disk; *MemStore keeps
+// them in memory for demos, tests and embedding the collectors elsewhere.
+type Storage interface {
+	Insert(metric string, lbls []Label, value float64, timestamp int64) error
+	InsertCollectionMark(ts time.Time) error
+	InsertMeterReadings(readings []MeterReading) error
+	InsertInverterReadings(readings []InverterReading) error
+	InsertSolarReadings(readings []SolarReading) error
+	InsertBatteryReadings(readings []BatteryReading) error
+	InsertSystemStatus(readings []SystemStatus) error
+	InsertEnvironmentalReadings(readings []EnvironmentalReading) error
+	InsertAlerts(readings []Alert) error
+
+	Select(metric string, tags map[string]string, start, end int64, step Step, function string) ([]*DataPoint, error)
+	GetLastPoint(metric string, tags map[string]string) (*DataPoint, error)
+	GetLastTimestamp(metric string) (int64, error)
+	GetSeries(metric string) [][]Label
+	GetAllSeries() map[string]map[string][]Label
+	Queryable() storage.Queryable
+
+	SetLocation(loc *time.Location)
+	Location() *time.Location
+
+	OpenAlertEpisode(source, name string, start time.Time) error
+	CloseAlertEpisode(source, name string, end time.Time) error
+	AlertEpisodes(f AlertEpisodeFilter) []AlertEpisode
+
+	AddAnnotation(a Annotation) (Annotation, error)
+	UpdateAnnotation(id string, a Annotation) (Annotation, error)
+	DeleteAnnotation(id string) error
+	Annotation(id string) (Annotation, error)
+	Annotations(f AnnotationFilter) []Annotation
+
+	AddSelfTestRun(r SelfTestRun) (SelfTestRun, error)
+	SelfTestRuns(f SelfTestFilter) []SelfTestRun
+
+	AddUtilityControlEvent(ev UtilityControlEvent) error
+	UtilityControlEvents(f UtilityControlFilter) []UtilityControlEvent
+	CurrentUtilityControls() map[string]UtilityControlEvent
+	UtilityControlPeriods(start, end int64) []UtilityControlPeriod
+
+	Close() error
+}
+
+var (
+	_ Storage = (*Store)(nil)
+	_ Storage = (*MemStore)(nil)
+)
+
+// backend is the database a Storage appends to and queries.
+type backend interface {
+	storage.Queryable
+	Appender(ctx context.Context) storage.Appender
+}
+
+// engine implements the parts of Storage that only need a backend: mapping
+// readings onto registry metrics, raw queries, the bucket timezone, alert
+// episodes, annotations, self-test runs and utility control events.
+type engine struct {
+	db     backend
+	logger *zap.Logger
+	sinks  []Sink
+	// written, if set, is told the time range (ms) of every committed batch.
+	written func(mint, maxt int64)
+
+	// location aligns query buckets; see SetLocation.
+	location atomic.Pointer[time.Location]
+
+	alertMu       sync.Mutex
+	alertEpisodes []AlertEpisode
+	// alertsPath is where episodes are saved; empty keeps them in memory.
+	alertsPath string
+
+	annotationMu sync.Mutex
+	annotations  []Annotation
+	// annotationsPath is where annotations are saved; empty keeps them in memory.
+	annotationsPath string
+
+	selfTestMu sync.Mutex
+	selfTests  []SelfTestRun
+	// selfTestsPath is where self-test runs are saved; empty keeps them in memory.
+	selfTestsPath string
+
+	utilityControlMu sync.Mutex
+	utilityControls  []UtilityControlEvent
+	// utilityControlsPath is where utility control events are saved; empty
+	// keeps them in memory.
+	utilityControlsPath string
+}
+
+// writeJSONFile writes v as indented JSON to path through a temporary file,
+// so readers never see a partial file. Callers serialize writes to the same
+// path.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -2,11 +2,15 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,6 +18,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/ygelfand/power-dash/internal/metrics"
 	"go.uber.org/zap"
 )
 
@@ -90,15 +95,47 @@ type Alert struct {
 	Name      string
 }
 
+// snapshotDir holds in-progress snapshots inside the data path. The TSDB
+// ignores directories that are not block ULIDs.
+const snapshotDir = "snapshots"
+
+// outOfOrderWindow (ms) accepts imports of arbitrarily old data.
+const outOfOrderWindow = 10 * 365 * 24 * 60 * 60 * 1000
+
 type Store struct {
-	db     *tsdb.DB
-	logger *zap.Logger
+	engine
+
+	db       *tsdb.DB
+	dataPath string
+
+	tiers      []RollupTier
+	rollupMu   sync.RWMutex
+	watermarks map[string]int64
+	// dirty holds per tier the rollupChunk starts with stale rollups.
+	dirty map[string]map[int64]bool
+
+	retention     map[string]time.Duration
+	lastRetention time.Time
+
+	// modifyMu serializes deletes and rewrites.
+	modifyMu sync.Mutex
+
+	stopCh    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
 type Config struct {
 	DataPath          string
 	Retention         time.Duration
 	PartitionDuration time.Duration
+	// RollupTiers defaults to DefaultRollupTiers when nil.
+	RollupTiers []RollupTier
+	// RetentionPolicies maps "raw" or a rollup tier name to how long its
+	// samples are kept. Zero or missing keeps them until Retention applies.
+	RetentionPolicies map[string]time.Duration
+	// Sinks receive a copy of every batch committed by the Insert methods.
+	Sinks []Sink
 }
 
 func NewStore(cfg Config, logger *zap.Logger) (*Store, error) {
@@ -107,11 +144,60 @@ func NewStore(cfg Config, logger *zap.Logger) (*Store, error) {
 	}
 
 	slogger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	opts := tsdbOptions(cfg)
+
+	logger.Info("Initializing TSDB",
+		zap.String("path", cfg.DataPath),
+		zap.Duration("retention", cfg.Retention),
+		zap.Duration("partition", cfg.PartitionDuration),
+		zap.Any("retention_policies", cfg.RetentionPolicies),
+	)
+
+	db, err := tsdb.Open(cfg.DataPath, slogger, prometheus.NewRegistry(), opts, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tsdb: %w", err)
+	}
 
+	tiers := cfg.RollupTiers
+	if tiers == nil {
+		tiers = DefaultRollupTiers
+	}
+
+	s := &Store{
+		engine: engine{
+			db:                  db,
+			logger:              logger,
+			sinks:               cfg.Sinks,
+			alertsPath:          filepath.Join(cfg.DataPath, alertEpisodesFile),
+			annotationsPath:     filepath.Join(cfg.DataPath, annotationsFile),
+			selfTestsPath:       filepath.Join(cfg.DataPath, selfTestsFile),
+			utilityControlsPath: filepath.Join(cfg.DataPath, utilityControlsFile),
+		},
+		db:        db,
+		dataPath:  cfg.DataPath,
+		tiers:     tiers,
+		retention: cfg.RetentionPolicies,
+		stopCh:    make(chan struct{}),
+	}
+	if err := s.validateRetentionPolicies(cfg.RetentionPolicies); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	s.loadRollupState()
+	s.written = s.markDirty
+	s.loadAlertEpisodes()
+	s.loadAnnotations()
+	s.loadSelfTests()
+	s.loadUtilityControls()
+	return s, nil
+}
+
+// tsdbOptions returns the TSDB options for cfg.
+func tsdbOptions(cfg Config) *tsdb.Options {
 	opts := tsdb.DefaultOptions()
 	opts.RetentionDuration = int64(cfg.Retention / time.Millisecond)
 	// Enable OOO support for historical imports (10 years window)
-	opts.OutOfOrderTimeWindow = 10 * 365 * 24 * 60 * 60 * 1000
+	opts.OutOfOrderTimeWindow = outOfOrderWindow
 
 	if cfg.PartitionDuration > 0 {
 		// MinBlockDuration controls when Head is flushed to disk. Keep it small (2h) for safety.
@@ -122,31 +208,27 @@ func NewStore(cfg Config, logger *zap.Logger) (*Store, error) {
 		opts.MinBlockDuration = min(defaultMin, targetMax)
 		opts.MaxBlockDuration = targetMax
 	}
-
-	logger.Info("Initializing TSDB",
-		zap.String("path", cfg.DataPath),
-		zap.Duration("retention", cfg.Retention),
-		zap.Duration("partition", cfg.PartitionDuration),
-	)
-
-	db, err := tsdb.Open(cfg.DataPath, slogger, prometheus.NewRegistry(), opts, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open tsdb: %w", err)
-	}
-
-	return &Store{db: db, logger: logger}, nil
+	return opts
 }
 
 func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.stopCh) })
+	s.wg.Wait()
 	return s.db.Close()
 }
 
-func (s *Store) Queryable() storage.Queryable {
+func (e *engine) Queryable() storage.Queryable {
+	return e.db
+}
+
+// ChunkQueryable exposes the database to readers that can consume raw
+// chunks, such as streamed remote read.
+func (s *Store) ChunkQueryable() storage.SampleAndChunkQueryable {
 	return s.db
 }
 
-func (s *Store) GetLastTimestamp(metric string) (int64, error) {
-	q, err := s.db.Querier(time.Now().Add(-30*24*time.Hour).UnixMilli(), time.Now().UnixMilli())
+func (e *engine) GetLastTimestamp(metric string) (int64, error) {
+	q, err := e.db.Querier(time.Now().Add(-30*24*time.Hour).UnixMilli(), time.Now().UnixMilli())
 	if err != nil {
 		return 0, err
 	}
@@ -166,25 +248,18 @@ func (s *Store) GetLastTimestamp(metric string) (int64, error) {
 	return lastTs / 1000, nil
 }
 
-func (s *Store) GetLastPoint(metric string, tags map[string]string) (*DataPoint, error) {
+func (e *engine) GetLastPoint(metric string, tags map[string]string) (*DataPoint, error) {
 	// Look back 24 hours to ensure we find data even after gaps
 	end := time.Now().UnixMilli()
 	start := end - 24*60*60*1000
 
-	q, err := s.db.Querier(start, end)
+	q, err := e.db.Querier(start, end)
 	if err != nil {
 		return nil, err
 	}
 	defer q.Close()
 
-	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metric)}
-	for k, v := range tags {
-		if v != "" {
-			matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, k, v))
-		}
-	}
-
-	ss := q.Select(context.Background(), false, nil, matchers...)
+	ss := q.Select(context.Background(), false, nil, tagMatchers(metric, tags)...)
 	var lastPoint *DataPoint
 	var lastTs int64
 
@@ -201,112 +276,204 @@ func (s *Store) GetLastPoint(metric string, tags map[string]string) (*DataPoint,
 	return lastPoint, nil
 }
 
-func (s *Store) Select(metric string, tags map[string]string, start, end, step int64, function string) ([]*DataPoint, error) {
-	q, err := s.db.Querier(start*1000, end*1000)
-	if err != nil {
-		return nil, err
+type bucketData struct {
+	sum      float64
+	integral float64
+	count    int
+	min      float64
+	max      float64
+	set      bool
+}
+
+func (b *bucketData) observe(v float64) {
+	if !b.set || v < b.min {
+		b.min = v
 	}
-	defer q.Close()
+	if !b.set || v > b.max {
+		b.max = v
+	}
+	b.set = true
+}
 
+// bucketFor returns the bucket containing tSec.
+func bucketFor(buckets map[int64]*bucketData, tSec int64, b bucketer) *bucketData {
+	bucketTs := b.start(tSec)
+
+	bd, ok := buckets[bucketTs]
+	if !ok {
+		bd = &bucketData{}
+		buckets[bucketTs] = bd
+	}
+	return bd
+}
+
+func tagMatchers(metric string, tags map[string]string) []*labels.Matcher {
 	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, metric)}
 	for k, v := range tags {
 		if v != "" {
 			matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, k, v))
 		}
 	}
+	return matchers
+}
 
-	ss := q.Select(context.Background(), false, nil, matchers...)
-	var results []*DataPoint
+// Select returns the samples of metric in [start, end] seconds, or with a
+// non-zero step one point per bucket aggregated by function.
+func (s *Store) Select(metric string, tags map[string]string, start, end int64, step Step, function string) ([]*DataPoint, error) {
+	return s.selectMatchers(metric, tagMatchers(metric, tags), start, end, step, function, false)
+}
+
+// selectMatchers implements Select for an arbitrary set of matchers on metric.
+// With lookback, the sample preceding start contributes to the first integral.
+func (s *Store) selectMatchers(metric string, matchers []*labels.Matcher, start, end int64, step Step, function string, lookback bool) ([]*DataPoint, error) {
+	if step.IsZero() {
+		return s.selectRaw(matchers, start, end, step, function, lookback)
+	}
+
+	buckets := make(map[int64]*bucketData)
+	b := s.bucketer(step)
+
+	if tier, wm, ok := s.rollupTierFor(start, end, b, function); ok {
+		res := int64(tier.Resolution / time.Second)
+		rollupFrom := alignUp(start, res)
+		rollupTo := min(wm, alignDown(end+1, res))
+		if rollupFrom < rollupTo {
+			q, err := s.db.Querier(rollupFrom*1000, rollupTo*1000-1)
+			if err != nil {
+				return nil, err
+			}
+			defer q.Close()
+
+			if err := s.accumulateRollup(q, tier, metric, matchers, rollupFrom, rollupTo, b, buckets); err != nil {
+				return nil, err
+			}
+			if err := s.accumulateRaw(matchers, start, rollupFrom-1, b, lookback || rollupFrom > start, buckets); err != nil {
+				return nil, err
+			}
+			if err := s.accumulateRaw(matchers, rollupTo, end, b, true, buckets); err != nil {
+				return nil, err
+			}
+			return bucketResults(buckets, function), nil
+		}
+	}
+	return s.selectRaw(matchers, start, end, step, function, lookback)
+}
+
+// selectRaw implements Select from raw samples alone.
+func (e *engine) selectRaw(matchers []*labels.Matcher, start, end int64, step Step, function string, lookback bool) ([]*DataPoint, error) {
+	if step.IsZero() {
+		q, err := e.db.Querier(start*1000, end*1000)
+		if err != nil {
+			return nil, err
+		}
+		defer q.Close()
 
-	type bucketData struct {
-		sum   float64
-		count int
-		min   float64
-		max   float64
-		set   bool
+		var results []*DataPoint
+		ss := q.Select(context.Background(), false, nil, matchers...)
+		for ss.Next() {
+			it := ss.At().Iterator(nil)
+			for it.Next() == chunkenc.ValFloat {
+				t, v := it.At()
+				results = append(results, &DataPoint{Timestamp: t / 1000, Value: v})
+			}
+		}
+		sortPoints(results)
+		return results, nil
 	}
+
 	buckets := make(map[int64]*bucketData)
+	if err := e.accumulateRaw(matchers, start, end, e.bucketer(step), lookback, buckets); err != nil {
+		return nil, err
+	}
+	return bucketResults(buckets, function), nil
+}
 
+// accumulateRaw folds raw samples in [from, to] seconds into buckets. With
+// lookback, the sample preceding from is used to integrate the first interval.
+func (e *engine) accumulateRaw(matchers []*labels.Matcher, from, to int64, bk bucketer, lookback bool, buckets map[int64]*bucketData) error {
+	if from > to {
+		return nil
+	}
+	qStart := from
+	if lookback {
+		qStart -= integralMaxGap
+	}
+	q, err := e.db.Querier(qStart*1000, to*1000)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	ss := q.Select(context.Background(), false, nil, matchers...)
 	for ss.Next() {
 		it := ss.At().Iterator(nil)
 		var prevT int64 = 0
 		for it.Next() == chunkenc.ValFloat {
 			t, v := it.At()
 			tSec := t / 1000
+			if tSec < from {
+				prevT = t
+				continue
+			}
 
-			if step > 0 {
-				// Align buckets to local timezone offset
-				_, offset := time.Unix(tSec, 0).In(time.Local).Zone()
-				bucketTs := ((tSec+int64(offset))/step)*step - int64(offset)
-
-				b, ok := buckets[bucketTs]
-				if !ok {
-					b = &bucketData{min: v, max: v, set: true}
-					buckets[bucketTs] = b
-				}
-
-				if function == "integral" {
-					if prevT > 0 {
-						dt := (t - prevT) / 1000
-						// Sanity check for dt to avoid massive spikes on gaps (2+min)
-						if dt > 0 && dt <= 120 {
-							b.sum += v * float64(dt)
-						}
-					}
-					b.count++ // Count points
-				} else {
-					b.sum += v
-					b.count++
-				}
-
-				if v < b.min {
-					b.min = v
+			b := bucketFor(buckets, tSec, bk)
+			if prevT > 0 {
+				dt := (t - prevT) / 1000
+				// Sanity check for dt to avoid massive spikes on gaps (2+min)
+				if dt > 0 && dt <= integralMaxGap {
+					b.integral += v * float64(dt)
 				}
-				if v > b.max {
-					b.max = v
-				}
-			} else {
-				results = append(results, &DataPoint{Timestamp: tSec, Value: v})
 			}
+			b.sum += v
+			b.count++
+			b.observe(v)
 			prevT = t
 		}
 	}
+	return ss.Err()
+}
 
-	if step > 0 {
-		for t, b := range buckets {
-			var val float64
-			switch function {
-			case "sum":
-				val = b.sum
-			case "integral":
-				val = b.sum // Already integrated (Watt-seconds)
-			case "min":
-				val = b.min
-			case "max":
-				val = b.max
-			case "delta":
-				val = b.max - b.min
-			default:
-				val = b.sum / float64(b.count) // Default to avg
-			}
-			results = append(results, &DataPoint{
-				Timestamp: t,
-				Value:     val,
-			})
+func bucketResults(buckets map[int64]*bucketData, function string) []*DataPoint {
+	var results []*DataPoint
+	for t, b := range buckets {
+		if b.count == 0 {
+			continue
+		}
+		var val float64
+		switch function {
+		case "sum":
+			val = b.sum
+		case "integral":
+			val = b.integral // Watt-seconds
+		case "min":
+			val = b.min
+		case "max":
+			val = b.max
+		case "delta":
+			val = b.max - b.min
+		default:
+			val = b.sum / float64(b.count) // Default to avg
 		}
+		results = append(results, &DataPoint{
+			Timestamp: t,
+			Value:     val,
+		})
 	}
+	sortPoints(results)
+	return results
+}
 
+func sortPoints(results []*DataPoint) {
 	sort.Slice(results, func(i, j int) bool {
 		if results[i].Timestamp == results[j].Timestamp {
 			return results[i].Value < results[j].Value
 		}
 		return results[i].Timestamp < results[j].Timestamp
 	})
-	return results, nil
 }
 
-func (s *Store) GetSeries(metric string) [][]Label {
-	q, err := s.db.Querier(time.Now().Add(-24*time.Hour).UnixMilli(), time.Now().UnixMilli())
+func (e *engine) GetSeries(metric string) [][]Label {
+	q, err := e.db.Querier(time.Now().Add(-24*time.Hour).UnixMilli(), time.Now().UnixMilli())
 	if err != nil {
 		return nil
 	}
@@ -327,8 +494,8 @@ func (s *Store) GetSeries(metric string) [][]Label {
 	return results
 }
 
-func (s *Store) GetAllSeries() map[string]map[string][]Label {
-	q, err := s.db.Querier(time.Now().Add(-30*24*time.Hour).UnixMilli(), time.Now().UnixMilli())
+func (e *engine) GetAllSeries() map[string]map[string][]Label {
+	q, err := e.db.Querier(time.Now().Add(-30*24*time.Hour).UnixMilli(), time.Now().UnixMilli())
 	if err != nil {
 		return nil
 	}
@@ -341,6 +508,9 @@ func (s *Store) GetAllSeries() map[string]map[string][]Label {
 
 	result := make(map[string]map[string][]Label)
 	for _, name := range metricNames {
+		if IsRollupMetric(name) {
+			continue
+		}
 		result[name] = make(map[string][]Label)
 
 		matchers := []*labels.Matcher{
@@ -366,6 +536,23 @@ func (s *Store) GetAllSeries() map[string]map[string][]Label {
 	return result
 }
 
+// minTime returns the oldest timestamp (ms) held by the database.
+func (s *Store) minTime() (int64, bool) {
+	minT := int64(math.MaxInt64)
+	if blocks := s.db.Blocks(); len(blocks) > 0 {
+		minT = blocks[0].Meta().MinTime
+	}
+	if head := s.db.Head(); head.NumSeries() > 0 {
+		minT = min(minT, head.MinTime())
+		// After head GC the OOO minimum is only a lower bound at the far end
+		// of the OOO window, not a real sample.
+		if ooo := head.MinOOOTime(); ooo > head.MaxTime()-outOfOrderWindow {
+			minT = min(minT, ooo)
+		}
+	}
+	return minT, minT != math.MaxInt64
+}
+
 func (s *Store) CompactOOO() error {
 	s.logger.Info("Triggering manual compaction (OOO)")
 	err := s.db.CompactOOOHead(context.Background())
@@ -393,49 +580,104 @@ func (s *Store) Checkpoint() error {
 	return err
 }
 
-func (s *Store) insertData(fn func(app storage.Appender) error) error {
-	app := s.db.Appender(context.Background())
+func (e *engine) insertData(fn func(app storage.Appender) error) error {
+	app := e.db.Appender(context.Background())
+	var rng *rangeAppender
+	if e.written != nil {
+		rng = &rangeAppender{Appender: app, mint: math.MaxInt64, maxt: math.MinInt64}
+		app = rng
+	}
+	var rec *recordingAppender
+	if len(e.sinks) > 0 {
+		rec = &recordingAppender{Appender: app}
+		app = rec
+	}
 	if err := fn(app); err != nil {
 		if rbErr := app.Rollback(); rbErr != nil {
-			s.logger.Error("Failed to rollback appender", zap.Error(rbErr))
+			e.logger.Error("Failed to rollback appender", zap.Error(rbErr))
 		}
 		return err
 	}
 	if err := app.Commit(); err != nil {
-		s.logger.Error("Failed to commit data batch", zap.Error(err))
+		e.logger.Error("Failed to commit data batch", zap.Error(err))
 		return err
 	}
+	if rec != nil && len(rec.samples) > 0 {
+		for _, sink := range e.sinks {
+			sink.Write(rec.samples)
+		}
+	}
+	if rng != nil && rng.mint <= rng.maxt {
+		e.written(rng.mint, rng.maxt)
+	}
 	return nil
 }
 
-func (s *Store) safeAppend(app storage.Appender, metric string, lset labels.Labels, t int64, v float64) error {
+// safeAppend appends a sample of a metric from the metrics registry. Samples
+// of unknown metrics or with unregistered labels, values outside the metric's
+// valid range and samples the database rejects, such as duplicates, are
+// logged and dropped without failing the rest of the batch.
+func (e *engine) safeAppend(app storage.Appender, metric string, lset labels.Labels, t int64, v float64) error {
+	info, ok := metrics.Lookup(metric)
+	if !ok {
+		e.logger.Warn("Dropping sample of unknown metric", zap.String("series", lset.String()), zap.String("metric", metric))
+		return nil
+	}
+	var err error
+	lset.Range(func(l labels.Label) {
+		if err == nil && l.Name != labels.MetricName && !info.HasLabel(l.Name) {
+			err = fmt.Errorf("metric %s has no label %q", metric, l.Name)
+		}
+	})
+	if err != nil {
+		e.logger.Warn("Dropping sample with unknown label", zap.String("series", lset.String()), zap.Error(err))
+		return nil
+	}
+	if err := info.CheckValue(v); err != nil {
+		e.logger.Warn("Dropping out-of-range sample", zap.String("series", lset.String()), zap.Time("timestamp", time.UnixMilli(t)), zap.Error(err))
+		return nil
+	}
+
 	b := labels.NewBuilder(lset)
 	b.Set(labels.MetricName, metric)
-	_, err := app.Append(0, b.Labels(), t, v)
+	_, err = app.Append(0, b.Labels(), t, v)
+	if isSampleError(err) {
+		e.logger.Warn("Dropping rejected sample", zap.String("series", lset.String()), zap.Time("timestamp", time.UnixMilli(t)), zap.Error(err))
+		return nil
+	}
 	if err != nil {
-		s.logger.Error("Append failed", zap.String("metric", metric), zap.Error(err))
+		e.logger.Error("Append failed", zap.String("metric", metric), zap.Error(err))
 	}
 	return err
 }
 
-func (s *Store) safeAppendIfSet(app storage.Appender, metric string, lset labels.Labels, t int64, v *float64) error {
+// isSampleError reports whether err rejects a single sample and leaves the
+// appender usable for the rest of the batch.
+func isSampleError(err error) bool {
+	return errors.Is(err, storage.ErrDuplicateSampleForTimestamp) ||
+		errors.Is(err, storage.ErrOutOfOrderSample) ||
+		errors.Is(err, storage.ErrOutOfBounds) ||
+		errors.Is(err, storage.ErrTooOldSample)
+}
+
+func (e *engine) safeAppendIfSet(app storage.Appender, metric string, lset labels.Labels, t int64, v *float64) error {
 	if v != nil {
-		return s.safeAppend(app, metric, lset, t, *v)
+		return e.safeAppend(app, metric, lset, t, *v)
 	}
 	return nil
 }
 
-func (s *Store) InsertCollectionMark(ts time.Time) error {
-	return s.insertData(func(app storage.Appender) error {
-		return s.safeAppend(app, "collection_mark", labels.EmptyLabels(), ts.UnixMilli(), 1)
+func (e *engine) InsertCollectionMark(ts time.Time) error {
+	return e.insertData(func(app storage.Appender) error {
+		return e.safeAppend(app, metrics.CollectionMark, labels.EmptyLabels(), ts.UnixMilli(), 1)
 	})
 }
 
-func (s *Store) InsertMeterReadings(readings []MeterReading) error {
+func (e *engine) InsertMeterReadings(readings []MeterReading) error {
 	if len(readings) == 0 {
 		return nil
 	}
-	return s.insertData(func(app storage.Appender) error {
+	return e.insertData(func(app storage.Appender) error {
 		for _, r := range readings {
 			t := r.Timestamp.UnixMilli()
 			labelsList := []string{"site", r.Site}
@@ -443,34 +685,34 @@ func (s *Store) InsertMeterReadings(readings []MeterReading) error {
 				labelsList = append(labelsList, "phase", *r.Phase)
 			}
 			l := labels.FromStrings(labelsList...)
-			if err := s.safeAppendIfSet(app, "power_watts", l, t, r.Power); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.PowerWatts, l, t, r.Power); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "power_reactive_var", l, t, r.Reactive); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.PowerReactiveVAR, l, t, r.Reactive); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "power_apparent_va", l, t, r.Apparent); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.PowerApparentVA, l, t, r.Apparent); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "voltage_volts", l, t, r.Voltage); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.VoltageVolts, l, t, r.Voltage); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "current_amps", l, t, r.Current); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.CurrentAmps, l, t, r.Current); err != nil {
 				return err
 			}
 			// site/load aggregates like to report 0 frequency
 			if r.Frequency != nil && *r.Frequency > 0 {
-				if err := s.safeAppendIfSet(app, "frequency_hertz", l, t, r.Frequency); err != nil {
+				if err := e.safeAppendIfSet(app, metrics.FrequencyHertz, l, t, r.Frequency); err != nil {
 					return err
 				}
 			}
 			if r.Imported != nil {
-				if err := s.safeAppend(app, "energy_wh", labels.FromStrings("site", r.Site, "direction", "import"), t, *r.Imported); err != nil {
+				if err := e.safeAppend(app, metrics.EnergyWh, labels.FromStrings("site", r.Site, "direction", "import"), t, *r.Imported); err != nil {
 					return err
 				}
 			}
 			if r.Exported != nil {
-				if err := s.safeAppend(app, "energy_wh", labels.FromStrings("site", r.Site, "direction", "export"), t, *r.Exported); err != nil {
+				if err := e.safeAppend(app, metrics.EnergyWh, labels.FromStrings("site", r.Site, "direction", "export"), t, *r.Exported); err != nil {
 					return err
 				}
 			}
@@ -479,11 +721,11 @@ func (s *Store) InsertMeterReadings(readings []MeterReading) error {
 	})
 }
 
-func (s *Store) InsertInverterReadings(readings []InverterReading) error {
+func (e *engine) InsertInverterReadings(readings []InverterReading) error {
 	if len(readings) == 0 {
 		return nil
 	}
-	return s.insertData(func(app storage.Appender) error {
+	return e.insertData(func(app storage.Appender) error {
 		for _, r := range readings {
 			t, idx := r.Timestamp.UnixMilli(), fmt.Sprint(r.InverterIndex)
 			invType := r.Type
@@ -491,19 +733,19 @@ func (s *Store) InsertInverterReadings(readings []InverterReading) error {
 				invType = "battery"
 			}
 			l := labels.FromStrings("index", idx, "type", invType)
-			if err := s.safeAppendIfSet(app, "inverter_power_watts", l, t, r.Power); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.InverterPowerWatts, l, t, r.Power); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "inverter_frequency_hertz", l, t, r.Frequency); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.InverterFrequencyHertz, l, t, r.Frequency); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "inverter_voltage_volts", labels.FromStrings("index", idx, "type", invType, "phase", "1"), t, r.Voltage1); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.InverterVoltageVolts, labels.FromStrings("index", idx, "type", invType, "phase", "1"), t, r.Voltage1); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "inverter_voltage_volts", labels.FromStrings("index", idx, "type", invType, "phase", "2"), t, r.Voltage2); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.InverterVoltageVolts, labels.FromStrings("index", idx, "type", invType, "phase", "2"), t, r.Voltage2); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "inverter_voltage_volts", labels.FromStrings("index", idx, "type", invType, "phase", "3"), t, r.Voltage3); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.InverterVoltageVolts, labels.FromStrings("index", idx, "type", invType, "phase", "3"), t, r.Voltage3); err != nil {
 				return err
 			}
 		}
@@ -511,21 +753,21 @@ func (s *Store) InsertInverterReadings(readings []InverterReading) error {
 	})
 }
 
-func (s *Store) InsertSolarReadings(readings []SolarReading) error {
+func (e *engine) InsertSolarReadings(readings []SolarReading) error {
 	if len(readings) == 0 {
 		return nil
 	}
-	return s.insertData(func(app storage.Appender) error {
+	return e.insertData(func(app storage.Appender) error {
 		for _, r := range readings {
 			t, idx := r.Timestamp.UnixMilli(), fmt.Sprint(r.InverterIndex)
 			l := labels.FromStrings("index", idx, "string", r.StringID)
-			if err := s.safeAppendIfSet(app, "solar_voltage_volts", l, t, r.Voltage); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.SolarVoltageVolts, l, t, r.Voltage); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "solar_current_amps", l, t, r.Current); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.SolarCurrentAmps, l, t, r.Current); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "solar_power_watts", l, t, r.Power); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.SolarPowerWatts, l, t, r.Power); err != nil {
 				return err
 			}
 		}
@@ -533,27 +775,27 @@ func (s *Store) InsertSolarReadings(readings []SolarReading) error {
 	})
 }
 
-func (s *Store) InsertBatteryReadings(readings []BatteryReading) error {
+func (e *engine) InsertBatteryReadings(readings []BatteryReading) error {
 	if len(readings) == 0 {
 		return nil
 	}
-	return s.insertData(func(app storage.Appender) error {
+	return e.insertData(func(app storage.Appender) error {
 		for _, r := range readings {
 			t, idx := r.Timestamp.UnixMilli(), fmt.Sprint(r.PodIndex)
 			if r.PodIndex == -1 {
 				if r.SOE != nil {
-					if err := s.safeAppend(app, "battery_soe_percent", labels.EmptyLabels(), t, *r.SOE); err != nil {
+					if err := e.safeAppend(app, metrics.BatterySOEPercent, labels.EmptyLabels(), t, *r.SOE); err != nil {
 						return err
 					}
 				}
 			} else {
 				if r.EnergyRemaining != nil {
-					if err := s.safeAppend(app, "battery_energy_wh", labels.FromStrings("index", idx, "type", "remaining"), t, *r.EnergyRemaining); err != nil {
+					if err := e.safeAppend(app, metrics.BatteryEnergyWh, labels.FromStrings("index", idx, "type", "remaining"), t, *r.EnergyRemaining); err != nil {
 						return err
 					}
 				}
 				if r.EnergyCapacity != nil {
-					if err := s.safeAppend(app, "battery_energy_wh", labels.FromStrings("index", idx, "type", "capacity"), t, *r.EnergyCapacity); err != nil {
+					if err := e.safeAppend(app, metrics.BatteryEnergyWh, labels.FromStrings("index", idx, "type", "capacity"), t, *r.EnergyCapacity); err != nil {
 						return err
 					}
 				}
@@ -563,15 +805,15 @@ func (s *Store) InsertBatteryReadings(readings []BatteryReading) error {
 	})
 }
 
-func (s *Store) InsertSystemStatus(readings []SystemStatus) error {
+func (e *engine) InsertSystemStatus(readings []SystemStatus) error {
 	if len(readings) == 0 {
 		return nil
 	}
-	return s.insertData(func(app storage.Appender) error {
+	return e.insertData(func(app storage.Appender) error {
 		for _, r := range readings {
 			t := r.Timestamp.UnixMilli()
 			if r.GridStatus != nil {
-				if err := s.safeAppend(app, "grid_status_code", labels.EmptyLabels(), t, *r.GridStatus); err != nil {
+				if err := e.safeAppend(app, metrics.GridStatusCode, labels.EmptyLabels(), t, *r.GridStatus); err != nil {
 					return err
 				}
 			}
@@ -580,7 +822,7 @@ func (s *Store) InsertSystemStatus(readings []SystemStatus) error {
 				if *r.ServicesActive {
 					val = 1.0
 				}
-				if err := s.safeAppend(app, "grid_services_active_bool", labels.EmptyLabels(), t, val); err != nil {
+				if err := e.safeAppend(app, metrics.GridServicesActiveBool, labels.EmptyLabels(), t, val); err != nil {
 					return err
 				}
 			}
@@ -589,21 +831,21 @@ func (s *Store) InsertSystemStatus(readings []SystemStatus) error {
 	})
 }
 
-func (s *Store) InsertEnvironmentalReadings(readings []EnvironmentalReading) error {
+func (e *engine) InsertEnvironmentalReadings(readings []EnvironmentalReading) error {
 	if len(readings) == 0 {
 		return nil
 	}
-	return s.insertData(func(app storage.Appender) error {
+	return e.insertData(func(app storage.Appender) error {
 		for _, r := range readings {
 			t, idx := r.Timestamp.UnixMilli(), fmt.Sprint(r.MsaIndex)
 			l := labels.FromStrings("index", idx)
-			if err := s.safeAppendIfSet(app, "temperature_celsius", l, t, r.AmbientTemp); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.TemperatureCelsius, l, t, r.AmbientTemp); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "fan_speed_rpm", labels.FromStrings("index", idx, "type", "actual"), t, r.FanSpeedActual); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.FanSpeedRPM, labels.FromStrings("index", idx, "type", "actual"), t, r.FanSpeedActual); err != nil {
 				return err
 			}
-			if err := s.safeAppendIfSet(app, "fan_speed_rpm", labels.FromStrings("index", idx, "type", "target"), t, r.FanSpeedTarget); err != nil {
+			if err := e.safeAppendIfSet(app, metrics.FanSpeedRPM, labels.FromStrings("index", idx, "type", "target"), t, r.FanSpeedTarget); err != nil {
 				return err
 			}
 		}
@@ -611,13 +853,13 @@ func (s *Store) InsertEnvironmentalReadings(readings []EnvironmentalReading) err
 	})
 }
 
-func (s *Store) InsertAlerts(readings []Alert) error {
+func (e *engine) InsertAlerts(readings []Alert) error {
 	if len(readings) == 0 {
 		return nil
 	}
-	return s.insertData(func(app storage.Appender) error {
+	return e.insertData(func(app storage.Appender) error {
 		for _, r := range readings {
-			if err := s.safeAppend(app, "active_alert", labels.FromStrings("source", r.Source, "name", r.Name), r.Timestamp.UnixMilli(), 1.0); err != nil {
+			if err := e.safeAppend(app, metrics.ActiveAlert, labels.FromStrings("source", r.Source, "name", r.Name), r.Timestamp.UnixMilli(), 1.0); err != nil {
 				return err
 			}
 		}
@@ -625,13 +867,48 @@ func (s *Store) InsertAlerts(readings []Alert) error {
 	})
 }
 
-func (s *Store) Insert(metric string, lbls []Label, value float64, timestamp int64) error {
-	return s.insertData(func(app storage.Appender) error {
+func (e *engine) Insert(metric string, lbls []Label, value float64, timestamp int64) error {
+	return e.insertData(func(app storage.Appender) error {
 		ls := make([]string, 0, len(lbls)*2+2)
 		ls = append(ls, labels.MetricName, metric)
 		for _, l := range lbls {
 			ls = append(ls, l.Name, l.Value)
 		}
-		return s.safeAppend(app, metric, labels.FromStrings(ls...), timestamp*1000, value)
+		return e.safeAppend(app, metric, labels.FromStrings(ls...), timestamp*1000, value)
 	})
 }
+
+// Snapshot writes a consistent copy of the database, including the in-memory
+// head, to a new directory under the data path and returns its location. The
+// caller owns the directory and should remove it when done.
+func (s *Store) Snapshot() (string, error) {
+	dir := filepath.Join(s.dataPath, snapshotDir, fmt.Sprint(time.Now().UnixNano()))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create snapshot dir: %w", err)
+	}
+	if err := s.db.Snapshot(dir, true); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to snapshot tsdb: %w", err)
+	}
+	if err := s.saveRollupStateTo(filepath.Join(dir, rollupStateFile)); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to snapshot rollup state: %w", err)
+	}
+	if err := s.saveAlertEpisodesTo(filepath.Join(dir, alertEpisodesFile)); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to snapshot alert episodes: %w", err)
+	}
+	if err := s.saveAnnotationsTo(filepath.Join(dir, annotationsFile)); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to snapshot annotations: %w", err)
+	}
+	if err := s.saveSelfTestsTo(filepath.Join(dir, selfTestsFile)); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to snapshot self-test history: %w", err)
+	}
+	if err := s.saveUtilityControlsTo(filepath.Join(dir, utilityControlsFile)); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to snapshot utility control events: %w", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	utilityControlsFile = "utility_control_events.json"
+	// maxUtilityControlEvents bounds the history; the oldest events go first.
+	maxUtilityControlEvents = 10000
+)
+
+// UtilityControl is an IEEE 2030.5 DER control set by the utility. Limits
+// are in watts; nil means the mode is not set.
+type UtilityControl struct {
+	MRID     string   `json:"mrid,omitempty"`
+	SetGradW *float64 `json:"set_grad_w,omitempty"`
+	Energize *bool    `json:"energize,omitempty"`
+	MaxLimW  *float64 `json:"max_lim_w,omitempty"`
+	ImpLimW  *float64 `json:"imp_lim_w,omitempty"`
+	ExpLimW  *float64 `json:"exp_lim_w,omitempty"`
+	GenLimW  *float64 `json:"gen_lim_w,omitempty"`
+	LoadLimW *float64 `json:"load_lim_w,omitempty"`
+}
+
+// UtilityControlEvent records that a control changed at Time (unix seconds).
+// Control is "default" or "active". Controls are told apart by MRID, or by
+// their position Index when the gateway reports no mRID. Current is nil when
+// the control was withdrawn.
+type UtilityControlEvent struct {
+	Time     int64           `json:"time"`
+	Control  string          `json:"control"`
+	Index    int             `json:"index"`
+	MRID     string          `json:"mrid,omitempty"`
+	Previous *UtilityControl `json:"previous,omitempty"`
+	Current  *UtilityControl `json:"current,omitempty"`
+}
+
+// Key identifies the control ev belongs to, e.g. "active/<mRID>", or
+// "active/0" for a control without an mRID.
+func (ev UtilityControlEvent) Key() string {
+	if ev.MRID != "" {
+		return ev.Control + "/" + ev.MRID
+	}
+	return fmt.Sprintf("%s/%d", ev.Control, ev.Index)
+}
+
+// UtilityControlPeriod is a span during which a control was in effect, with
+// Start and End in unix seconds. Ongoing periods end at the queried end.
+type UtilityControlPeriod struct {
+	Control string         `json:"control"`
+	Index   int            `json:"index"`
+	MRID    string         `json:"mrid,omitempty"`
+	Start   int64          `json:"start"`
+	End     int64          `json:"end"`
+	Ongoing bool           `json:"ongoing"`
+	Values  UtilityControl `json:"values"`
+}
+
+// UtilityControlFilter selects events within [Start, End] seconds. Zero
+// bounds are open.
+type UtilityControlFilter struct {
+	Start int64
+	End   int64
+	// Limit caps the result to the newest events; zero returns all.
+	Limit int
+}
+
+// Equal reports whether c and o set the same control.
+func (c *UtilityControl) Equal(o *UtilityControl) bool {
+	return reflect.DeepEqual(c, o)
+}
+
+func (e *engine) loadUtilityControls() {
+	data, err := os.ReadFile(e.utilityControlsPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			e.logger.Warn("Failed to read utility control events", zap.Error(err))
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &e.utilityControls); err != nil {
+		e.logger.Warn("Failed to parse utility control events", zap.Error(err))
+	}
+}
+
+func (e *engine) saveUtilityControlsTo(path string) error {
+	e.utilityControlMu.Lock()
+	defer e.utilityControlMu.Unlock()
+	return writeJSONFile(path, e.utilityControls)
+}
+
+// saveUtilityControls persists the events when the storage has a path for them.
+// utilityControlMu must be held, so saves land in the order of the changes.
+func (e *engine) saveUtilityControls() error {
+	if e.utilityControlsPath == "" {
+		return nil
+	}
+	return writeJSONFile(e.utilityControlsPath, e.utilityControls)
+}
+
+// AddUtilityControlEvent records a change of a utility control.
+func (e *engine) AddUtilityControlEvent(ev UtilityControlEvent) error {
+	e.utilityControlMu.Lock()
+	defer e.utilityControlMu.Unlock()
+	e.utilityControls = append(e.utilityControls, ev)
+	sort.SliceStable(e.utilityControls, func(i, j int) bool { return e.utilityControls[i].Time < e.utilityControls[j].Time })
+	if len(e.utilityControls) > maxUtilityControlEvents {
+		e.utilityControls = e.utilityControls[len(e.utilityControls)-maxUtilityControlEvents:]
+	}
+	return e.saveUtilityControls()
+}
+
+// UtilityControlEvents returns the events matching f, newest first.
+func (e *engine) UtilityControlEvents(f UtilityControlFilter) []UtilityControlEvent {
+	e.utilityControlMu.Lock()
+	defer e.utilityControlMu.Unlock()
+
+	result := []UtilityControlEvent{}
+	for i := len(e.utilityControls) - 1; i >= 0; i-- {
+		ev := e.utilityControls[i]
+		if f.Start != 0 && ev.Time < f.Start || f.End != 0 && ev.Time > f.End {
+			continue
+		}
+		result = append(result, ev)
+		if f.Limit > 0 && len(result) == f.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// CurrentUtilityControls returns the last recorded state of every control,
+// keyed by UtilityControlEvent.Key. Withdrawn controls are left out.
+func (e *engine) CurrentUtilityControls() map[string]UtilityControlEvent {
+	e.utilityControlMu.Lock()
+	defer e.utilityControlMu.Unlock()
+
+	cur := make(map[string]UtilityControlEvent)
+	for _, ev := range e.utilityControls {
+		if ev.Current == nil {
+			delete(cur, ev.Key())
+			continue
+		}
+		cur[ev.Key()] = ev
+	}
+	return cur
+}
+
+// UtilityControlPeriods returns the spans during which each control was in
+// effect within [start, end] seconds, ordered by start. A zero end means now.
+func (e *engine) UtilityControlPeriods(start, end int64) []UtilityControlPeriod {
+	if end == 0 {
+		end = time.Now().Unix()
+	}
+	e.utilityControlMu.Lock()
+	defer e.utilityControlMu.Unlock()
+
+	open := make(map[string]*UtilityControlPeriod)
+	result := []UtilityControlPeriod{}
+	closePeriod := func(p *UtilityControlPeriod, at int64) {
+		if at >= start && p.Start <= end {
+			p.Start, p.End = max(p.Start, start), min(at, end)
+			result = append(result, *p)
+		}
+	}
+	for _, ev := range e.utilityControls {
+		if ev.Time > end {
+			break
+		}
+		if p, ok := open[ev.Key()]; ok {
+			closePeriod(p, ev.Time)
+			delete(open, ev.Key())
+		}
+		if ev.Current != nil {
+			open[ev.Key()] = &UtilityControlPeriod{Control: ev.Control, Index: ev.Index, MRID: ev.MRID, Start: ev.Time, Values: *ev.Current}
+		}
+	}
+	for _, p := range open {
+		p.Ongoing = true
+		closePeriod(p, end)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Start < result[j].Start })
+	return result
+}
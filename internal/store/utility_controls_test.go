@@ -0,0 +1,60 @@
+package store
+
+import "testing"
+
+func TestUtilityControlPeriodsByMRID(t *testing.T) {
+	s := newTestStore(t, Config{})
+	limit := func(w float64) *UtilityControl { return &UtilityControl{ExpLimW: &w} }
+	a, b, unnamed := limit(5000), limit(3000), limit(1000)
+	a.MRID, b.MRID = "A", "B"
+
+	events := []UtilityControlEvent{
+		{Time: 100, Control: "active", Index: 0, MRID: "A", Current: a},
+		{Time: 100, Control: "active", Index: 1, MRID: "B", Current: b},
+		{Time: 100, Control: "active", Index: 2, Current: unnamed},
+		// A ends; B and the unnamed control move up one position.
+		{Time: 200, Control: "active", Index: 0, MRID: "A", Previous: a},
+		{Time: 200, Control: "active", Index: 2, Previous: unnamed},
+		{Time: 200, Control: "active", Index: 1, Current: unnamed},
+	}
+	for _, ev := range events {
+		if err := s.AddUtilityControlEvent(ev); err != nil {
+			t.Fatalf("AddUtilityControlEvent: %v", err)
+		}
+	}
+
+	cur := s.CurrentUtilityControls()
+	if len(cur) != 2 || cur["active/B"].Index != 1 || cur["active/1"].Current == nil {
+		t.Errorf("current controls = %v, want active/B and active/1", cur)
+	}
+
+	tests := []struct {
+		mrid       string
+		index      int
+		start, end int64
+		ongoing    bool
+	}{
+		{"A", 0, 100, 200, false},
+		{"B", 1, 100, 300, true},
+		{"", 2, 100, 200, false},
+		{"", 1, 200, 300, true},
+	}
+	periods := s.UtilityControlPeriods(0, 300)
+	if len(periods) != len(tests) {
+		t.Fatalf("got %d periods, want %d: %+v", len(periods), len(tests), periods)
+	}
+	for _, tt := range tests {
+		found := false
+		for _, p := range periods {
+			if p.MRID == tt.mrid && p.Index == tt.index && p.Start == tt.start {
+				found = true
+				if p.End != tt.end || p.Ongoing != tt.ongoing {
+					t.Errorf("period %q/%d = %+v, want end %d ongoing %v", tt.mrid, tt.index, p, tt.end, tt.ongoing)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no period for %q/%d from %d in %+v", tt.mrid, tt.index, tt.start, periods)
+		}
+	}
+}
@@ -0,0 +1,652 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/prometheus/prometheus/tsdb/wlog"
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// quarantineDir receives the corrupt blocks moved aside by Repair. The TSDB
+// ignores directories that are not block ULIDs.
+const quarantineDir = "quarantine"
+
+// maxBlockErrors caps the errors kept per block; the rest are only counted.
+const maxBlockErrors = 10
+
+// VerifyOptions sets the thresholds above which label cardinality is reported.
+type VerifyOptions struct {
+	// MaxSeries flags metrics with more series than this.
+	MaxSeries int
+	// MaxLabelValues flags labels with more distinct values than this.
+	MaxLabelValues int
+}
+
+// BlockCheck is the result of reading every series and chunk of a block.
+// Start and End are unix seconds.
+type BlockCheck struct {
+	ULID      string   `json:"ulid"`
+	Start     int64    `json:"start"`
+	End       int64    `json:"end"`
+	Series    int      `json:"series"`
+	Chunks    int      `json:"chunks"`
+	Samples   int      `json:"samples"`
+	BadChunks int      `json:"bad_chunks"`
+	Corrupt   bool     `json:"corrupt"`
+	Errors    []string `json:"errors,omitempty"`
+	// Superseded is set when a readable block was compacted from this one,
+	// so nothing is lost by dropping it.
+	Superseded bool `json:"superseded,omitempty"`
+	ErrorCount int  `json:"error_count"`
+}
+
+func (bc *BlockCheck) fail(err error) {
+	bc.Corrupt = true
+	bc.ErrorCount++
+	if len(bc.Errors) < maxBlockErrors {
+		bc.Errors = append(bc.Errors, err.Error())
+	}
+}
+
+// WALCheck is the result of decoding every record of a write-ahead log.
+// Segment and Offset locate the first corruption; opening the TSDB for
+// writing truncates the log there.
+type WALCheck struct {
+	Dir        string `json:"dir"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+	Segments   int    `json:"segments"`
+	Records    int    `json:"records"`
+	Series     int    `json:"series"`
+	Samples    int    `json:"samples"`
+	Error      string `json:"error,omitempty"`
+	Segment    int    `json:"segment,omitempty"`
+	Offset     int64  `json:"offset,omitempty"`
+}
+
+// BlockOverlap is a time range in unix seconds covered by more than one
+// block, usually left behind by out-of-order imports until compaction
+// merges them.
+type BlockOverlap struct {
+	Start  int64    `json:"start"`
+	End    int64    `json:"end"`
+	Blocks []string `json:"blocks"`
+}
+
+// CardinalityOutlier is a metric with too many series, or one of its labels
+// with too many distinct values when Label is set.
+type CardinalityOutlier struct {
+	Metric     string `json:"metric"`
+	Label      string `json:"label,omitempty"`
+	Series     int    `json:"series"`
+	Values     int    `json:"values,omitempty"`
+	Registered bool   `json:"registered"`
+}
+
+type VerifyReport struct {
+	DataPath string               `json:"data_path"`
+	Blocks   []BlockCheck         `json:"blocks"`
+	WAL      *WALCheck            `json:"wal,omitempty"`
+	WBL      *WALCheck            `json:"wbl,omitempty"`
+	Overlaps []BlockOverlap       `json:"overlaps"`
+	Outliers []CardinalityOutlier `json:"outliers"`
+	Series   int                  `json:"series"`
+	// Healthy is false when a block is corrupt or a log cannot be replayed.
+	// Overlaps and outliers are reported but do not stop the TSDB opening.
+	Healthy bool `json:"healthy"`
+}
+
+// CorruptBlocks returns the blocks that failed verification.
+func (r *VerifyReport) CorruptBlocks() []BlockCheck {
+	var bad []BlockCheck
+	for _, bc := range r.Blocks {
+		if bc.Corrupt {
+			bad = append(bad, bc)
+		}
+	}
+	return bad
+}
+
+// Verify checks the TSDB in dataPath without opening it for writing: every
+// block's index, chunks and tombstones, the write-ahead and out-of-order
+// logs, overlapping blocks and label cardinality. It does not take the
+// storage lock, so results may be stale while power-dash is writing.
+func Verify(ctx context.Context, dataPath string, opts VerifyOptions) (*VerifyReport, error) {
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &VerifyReport{DataPath: dataPath, Blocks: []BlockCheck{}, Overlaps: []BlockOverlap{}, Outliers: []CardinalityOutlier{}}
+	card := newCardinality()
+	var metas []tsdb.BlockMeta
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := ulid.ParseStrict(e.Name()); err != nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		bc, meta := verifyBlock(ctx, filepath.Join(dataPath, e.Name()), card)
+		r.Blocks = append(r.Blocks, bc)
+		if meta != nil && !bc.Corrupt {
+			metas = append(metas, *meta)
+		}
+	}
+	sort.Slice(r.Blocks, func(i, j int) bool { return r.Blocks[i].Start < r.Blocks[j].Start })
+
+	parents := make(map[string]bool)
+	for _, m := range metas {
+		for _, p := range m.Compaction.Parents {
+			parents[p.ULID.String()] = true
+		}
+	}
+	r.Healthy = true
+	for i := range r.Blocks {
+		if bc := &r.Blocks[i]; bc.Corrupt {
+			bc.Superseded = parents[bc.ULID]
+			r.Healthy = false
+		}
+	}
+
+	sort.Slice(metas, func(i, j int) bool { return metas[i].MinTime < metas[j].MinTime })
+	for iv, blocks := range tsdb.OverlappingBlocks(metas) {
+		o := BlockOverlap{Start: iv.Min / 1000, End: iv.Max / 1000}
+		for _, m := range blocks {
+			o.Blocks = append(o.Blocks, m.ULID.String())
+		}
+		r.Overlaps = append(r.Overlaps, o)
+	}
+	sort.Slice(r.Overlaps, func(i, j int) bool { return r.Overlaps[i].Start < r.Overlaps[j].Start })
+
+	r.WAL = verifyWAL(filepath.Join(dataPath, "wal"), card)
+	r.WBL = verifyWAL(filepath.Join(dataPath, wlog.WblDirName), card)
+	for _, wc := range []*WALCheck{r.WAL, r.WBL} {
+		if wc != nil && wc.Error != "" {
+			r.Healthy = false
+		}
+	}
+
+	r.Series = len(card.seen)
+	r.Outliers = card.outliers(opts)
+	return r, nil
+}
+
+// verifyBlock reads every series, chunk and sample of the block in dir. The
+// returned meta is nil when the block cannot be opened at all.
+func verifyBlock(ctx context.Context, dir string, card *cardinality) (BlockCheck, *tsdb.BlockMeta) {
+	bc := BlockCheck{ULID: filepath.Base(dir)}
+	b, err := tsdb.OpenBlock(nil, dir, nil, nil)
+	if err != nil {
+		bc.fail(err)
+		return bc, nil
+	}
+	defer b.Close()
+
+	meta := b.Meta()
+	bc.Start, bc.End = meta.MinTime/1000, meta.MaxTime/1000
+
+	ir, err := b.Index()
+	if err != nil {
+		bc.fail(err)
+		return bc, &meta
+	}
+	defer ir.Close()
+	cr, err := b.Chunks()
+	if err != nil {
+		bc.fail(err)
+		return bc, &meta
+	}
+	defer cr.Close()
+
+	k, v := index.AllPostingsKey()
+	p, err := ir.Postings(ctx, k, v)
+	if err != nil {
+		bc.fail(fmt.Errorf("postings: %w", err))
+		return bc, &meta
+	}
+	var (
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+		prev    labels.Labels
+	)
+	for p.Next() {
+		if err := ir.Series(p.At(), &builder, &chks); err != nil {
+			bc.fail(fmt.Errorf("series %d: %w", p.At(), err))
+			continue
+		}
+		lset := builder.Labels()
+		if bc.Series > 0 && labels.Compare(prev, lset) >= 0 {
+			bc.fail(fmt.Errorf("series %s not sorted after %s", lset, prev))
+		}
+		prev = lset
+		bc.Series++
+		card.add(lset)
+
+		for i, c := range chks {
+			bc.Chunks++
+			if c.MinTime > c.MaxTime || c.MinTime < meta.MinTime || c.MaxTime >= meta.MaxTime {
+				bc.fail(fmt.Errorf("%s: chunk [%d, %d] outside block [%d, %d)", lset, c.MinTime, c.MaxTime, meta.MinTime, meta.MaxTime))
+			}
+			if i > 0 && c.MinTime <= chks[i-1].MaxTime {
+				bc.fail(fmt.Errorf("%s: chunk at %d overlaps the previous chunk", lset, c.MinTime))
+			}
+			n, err := verifyChunk(cr, c)
+			bc.Samples += n
+			if err != nil {
+				bc.BadChunks++
+				bc.fail(fmt.Errorf("%s: chunk at %d: %w", lset, c.MinTime, err))
+			}
+		}
+	}
+	if err := p.Err(); err != nil {
+		bc.fail(fmt.Errorf("postings: %w", err))
+	}
+
+	if uint64(bc.Series) != meta.Stats.NumSeries {
+		bc.fail(fmt.Errorf("meta.json lists %d series, index has %d", meta.Stats.NumSeries, bc.Series))
+	}
+	if bc.BadChunks == 0 && uint64(bc.Samples) != meta.Stats.NumSamples {
+		bc.fail(fmt.Errorf("meta.json lists %d samples, chunks hold %d", meta.Stats.NumSamples, bc.Samples))
+	}
+
+	tr, err := b.Tombstones()
+	if err != nil {
+		bc.fail(fmt.Errorf("tombstones: %w", err))
+	} else {
+		defer tr.Close()
+	}
+	return bc, &meta
+}
+
+// verifyChunk decodes one chunk, checking its checksum and that its samples
+// are in order within the range the index recorded.
+func verifyChunk(cr tsdb.ChunkReader, c chunks.Meta) (int, error) {
+	chk, iterable, err := cr.ChunkOrIterable(c)
+	if err != nil {
+		return 0, err
+	}
+	var it chunkenc.Iterator
+	if iterable != nil {
+		it = iterable.Iterator(nil)
+	} else {
+		it = chk.Iterator(nil)
+	}
+	n := 0
+	prev := c.MinTime - 1
+	for it.Next() != chunkenc.ValNone {
+		t := it.AtT()
+		if t <= prev || t > c.MaxTime {
+			return n, fmt.Errorf("sample at %d out of order or outside [%d, %d]", t, c.MinTime, c.MaxTime)
+		}
+		prev = t
+		n++
+	}
+	return n, it.Err()
+}
+
+// verifyWAL decodes the last checkpoint and every segment of the log in dir,
+// the records the head replays on startup. It returns nil if dir does not
+// exist.
+func verifyWAL(dir string, card *cardinality) *WALCheck {
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	wc := &WALCheck{Dir: filepath.Base(dir)}
+
+	cp, _, err := wlog.LastCheckpoint(dir)
+	if err != nil && !errors.Is(err, record.ErrNotFound) {
+		wc.Error = err.Error()
+		return wc
+	}
+	if cp != "" {
+		wc.Checkpoint = filepath.Base(cp)
+		if !wc.read(cp, card) {
+			return wc
+		}
+	}
+
+	first, last, err := wlog.Segments(dir)
+	if err != nil {
+		wc.Error = err.Error()
+		return wc
+	}
+	if first >= 0 {
+		wc.Segments = last - first + 1
+		wc.read(dir, card)
+	}
+	return wc
+}
+
+// read decodes the segments in dir into wc, reporting false on the first
+// corrupt record.
+func (wc *WALCheck) read(dir string, card *cardinality) bool {
+	sr, err := wlog.NewSegmentsReader(dir)
+	if err != nil {
+		wc.Error = err.Error()
+		return false
+	}
+	defer sr.Close()
+
+	var (
+		dec     = record.NewDecoder(labels.NewSymbolTable(), nil)
+		series  []record.RefSeries
+		samples []record.RefSample
+		stones  []tombstones.Stone
+	)
+	r := wlog.NewReader(sr)
+	for r.Next() {
+		rec := r.Record()
+		wc.Records++
+		switch typ := dec.Type(rec); typ {
+		case record.Series:
+			series, err = dec.Series(rec, series[:0])
+			for _, s := range series {
+				card.add(s.Labels)
+			}
+			wc.Series += len(series)
+		case record.Samples:
+			samples, err = dec.Samples(rec, samples[:0])
+			wc.Samples += len(samples)
+		case record.Tombstones:
+			stones, err = dec.Tombstones(rec, stones[:0])
+		case record.Unknown:
+			err = fmt.Errorf("unknown record type %d", rec[0])
+		}
+		if err != nil {
+			wc.Error = fmt.Sprintf("%s: decode record: %v", filepath.Base(dir), err)
+			wc.Segment, wc.Offset = r.Segment(), r.Offset()
+			return false
+		}
+	}
+	if err := r.Err(); err != nil {
+		wc.Error = err.Error()
+		wc.Segment, wc.Offset = r.Segment(), r.Offset()
+		var cerr *wlog.CorruptionErr
+		if errors.As(err, &cerr) {
+			wc.Segment, wc.Offset = cerr.Segment, cerr.Offset
+		}
+		return false
+	}
+	return true
+}
+
+// cardinality counts the distinct series and label values per metric across
+// blocks and logs.
+type cardinality struct {
+	seen    map[uint64]struct{}
+	metrics map[string]*metricCardinality
+}
+
+type metricCardinality struct {
+	series int
+	values map[string]map[string]struct{}
+}
+
+func newCardinality() *cardinality {
+	return &cardinality{seen: make(map[uint64]struct{}), metrics: make(map[string]*metricCardinality)}
+}
+
+func (c *cardinality) add(lset labels.Labels) {
+	h := lset.Hash()
+	if _, ok := c.seen[h]; ok {
+		return
+	}
+	c.seen[h] = struct{}{}
+
+	name := lset.Get(labels.MetricName)
+	mc := c.metrics[name]
+	if mc == nil {
+		mc = &metricCardinality{values: make(map[string]map[string]struct{})}
+		c.metrics[name] = mc
+	}
+	mc.series++
+	lset.Range(func(l labels.Label) {
+		if l.Name == labels.MetricName {
+			return
+		}
+		vals := mc.values[l.Name]
+		if vals == nil {
+			vals = make(map[string]struct{})
+			mc.values[l.Name] = vals
+		}
+		vals[l.Value] = struct{}{}
+	})
+}
+
+func (c *cardinality) outliers(opts VerifyOptions) []CardinalityOutlier {
+	out := []CardinalityOutlier{}
+	for name, mc := range c.metrics {
+		base := name
+		if IsRollupMetric(name) {
+			base = name[strings.IndexByte(name, ':')+1:]
+		}
+		_, registered := metrics.Lookup(base)
+		if opts.MaxSeries > 0 && mc.series > opts.MaxSeries {
+			out = append(out, CardinalityOutlier{Metric: name, Series: mc.series, Registered: registered})
+		}
+		for label, vals := range mc.values {
+			if opts.MaxLabelValues > 0 && len(vals) > opts.MaxLabelValues {
+				out = append(out, CardinalityOutlier{Metric: name, Label: label, Series: mc.series, Values: len(vals), Registered: registered})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Series != out[j].Series {
+			return out[i].Series > out[j].Series
+		}
+		if out[i].Metric != out[j].Metric {
+			return out[i].Metric < out[j].Metric
+		}
+		return out[i].Label < out[j].Label
+	})
+	return out
+}
+
+// SalvagedBlock is a block rewritten from the readable chunks of a corrupt one.
+type SalvagedBlock struct {
+	From    string `json:"from"`
+	ULID    string `json:"ulid"`
+	Series  int    `json:"series"`
+	Samples int    `json:"samples"`
+}
+
+type RepairResult struct {
+	Quarantined []string        `json:"quarantined"`
+	Salvaged    []SalvagedBlock `json:"salvaged"`
+	// Blocks is the number of blocks once the TSDB has been reopened and
+	// overlapping blocks compacted.
+	Blocks int `json:"blocks"`
+}
+
+// Repair moves the corrupt blocks in report to the quarantine directory after
+// rewriting whatever series they still hold into new blocks, then opens the
+// TSDB with cfg, which truncates a corrupt write-ahead log at the first bad
+// record, and compacts overlapping blocks. It holds the TSDB lock file
+// throughout and fails before touching any block if power-dash is running.
+func Repair(ctx context.Context, cfg Config, report *VerifyReport, logger *zap.Logger) (*RepairResult, error) {
+	lock, _, err := fileutil.Flock(filepath.Join(cfg.DataPath, "lock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s, is power-dash running? %w", cfg.DataPath, err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	res := &RepairResult{Quarantined: []string{}, Salvaged: []SalvagedBlock{}}
+	qdir := filepath.Join(cfg.DataPath, quarantineDir)
+
+	for _, bc := range report.CorruptBlocks() {
+		dir := filepath.Join(cfg.DataPath, bc.ULID)
+		if !bc.Superseded {
+			sb, err := salvageBlock(ctx, cfg.DataPath, dir)
+			if err != nil {
+				logger.Warn("Failed to salvage block", zap.String("block", bc.ULID), zap.Error(err))
+			} else if sb != nil {
+				res.Salvaged = append(res.Salvaged, *sb)
+				logger.Info("Salvaged block", zap.String("block", bc.ULID), zap.String("into", sb.ULID), zap.Int("series", sb.Series), zap.Int("samples", sb.Samples))
+			}
+		}
+		if err := os.MkdirAll(qdir, 0o755); err != nil {
+			return res, err
+		}
+		if err := os.Rename(dir, filepath.Join(qdir, bc.ULID)); err != nil {
+			return res, fmt.Errorf("quarantine %s: %w", bc.ULID, err)
+		}
+		res.Quarantined = append(res.Quarantined, bc.ULID)
+		logger.Info("Quarantined block", zap.String("block", bc.ULID), zap.String("path", filepath.Join(qdir, bc.ULID)))
+	}
+
+	// The lock taken above already keeps other writers out.
+	opts := tsdbOptions(cfg)
+	opts.NoLockfile = true
+	db, err := tsdb.Open(cfg.DataPath, nil, prometheus.NewRegistry(), opts, nil)
+	if err != nil {
+		return res, fmt.Errorf("failed to open tsdb: %w", err)
+	}
+	if err := db.Compact(ctx); err != nil {
+		_ = db.Close()
+		return res, fmt.Errorf("compact: %w", err)
+	}
+	res.Blocks = len(db.Blocks())
+	if err := db.Close(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// salvageBlock copies the samples of every readable chunk in the block at dir,
+// minus those deleted by its tombstones, into a new block in dataPath. It
+// returns nil if nothing could be read.
+func salvageBlock(ctx context.Context, dataPath, dir string) (*SalvagedBlock, error) {
+	b, err := tsdb.OpenBlock(nil, dir, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	meta := b.Meta()
+
+	ir, err := b.Index()
+	if err != nil {
+		return nil, err
+	}
+	defer ir.Close()
+	cr, err := b.Chunks()
+	if err != nil {
+		return nil, err
+	}
+	defer cr.Close()
+	tr, err := b.Tombstones()
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+
+	w, err := tsdb.NewBlockWriter(slog.New(slog.DiscardHandler), dataPath, meta.MaxTime-meta.MinTime)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	sb := &SalvagedBlock{From: meta.ULID.String()}
+	app := w.Appender(ctx)
+	pending := 0
+	k, v := index.AllPostingsKey()
+	p, err := ir.Postings(ctx, k, v)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		builder labels.ScratchBuilder
+		chks    []chunks.Meta
+	)
+	for p.Next() {
+		if err := ir.Series(p.At(), &builder, &chks); err != nil {
+			continue
+		}
+		lset := builder.Labels()
+		deleted, _ := tr.Get(p.At())
+		n := salvageSeries(app, cr, lset, chks, deleted)
+		if n == 0 {
+			continue
+		}
+		sb.Series++
+		sb.Samples += n
+		if pending += n; pending >= rewriteBatchSize {
+			if err := app.Commit(); err != nil {
+				return nil, err
+			}
+			app = w.Appender(ctx)
+			pending = 0
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return nil, err
+	}
+	if sb.Samples == 0 {
+		return nil, nil
+	}
+	id, err := w.Flush(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sb.ULID = id.String()
+	return sb, nil
+}
+
+func salvageSeries(app storage.Appender, cr tsdb.ChunkReader, lset labels.Labels, chks []chunks.Meta, deleted tombstones.Intervals) int {
+	n := 0
+	for _, c := range chks {
+		if _, err := verifyChunk(cr, c); err != nil {
+			continue
+		}
+		chk, iterable, err := cr.ChunkOrIterable(c)
+		if err != nil {
+			continue
+		}
+		var it chunkenc.Iterator
+		if iterable != nil {
+			it = iterable.Iterator(nil)
+		} else {
+			it = chk.Iterator(nil)
+		}
+		for it.Next() == chunkenc.ValFloat {
+			t, v := it.At()
+			if isDeleted(t, deleted) {
+				continue
+			}
+			if _, err := app.Append(0, lset, t, v); err == nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func isDeleted(t int64, deleted tombstones.Intervals) bool {
+	for _, iv := range deleted {
+		if iv.InBounds(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ygelfand/power-dash/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// corruptBlock writes power_watts for the sites load and solar into one block,
+// closes the store and damages the first chunk. It returns the block's ULID.
+func corruptBlock(t *testing.T, cfg Config) string {
+	t.Helper()
+	s, err := NewStore(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	day := testDay()
+	for _, site := range []string{"load", "solar"} {
+		for i := range 60 {
+			if err := s.Insert(metrics.PowerWatts, []Label{{Name: "site", Value: site}}, float64(i), day+int64(60*i)); err != nil {
+				t.Fatalf("Insert: %v", err)
+			}
+		}
+	}
+	if _, err := s.persistHead(context.Background()); err != nil {
+		t.Fatalf("persistHead: %v", err)
+	}
+	blocks := s.db.Blocks()
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+	id := blocks[0].Meta().ULID.String()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	path := filepath.Join(cfg.DataPath, id, "chunks", "000001")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 12; i < 24; i++ {
+		data[i] ^= 0xff
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	cfg := Config{DataPath: t.TempDir()}
+	id := corruptBlock(t, cfg)
+	ctx := context.Background()
+
+	report, err := Verify(ctx, cfg.DataPath, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	bad := report.CorruptBlocks()
+	if report.Healthy || len(bad) != 1 || bad[0].ULID != id || bad[0].BadChunks != 1 {
+		t.Fatalf("report = %+v, want block %s with one bad chunk", report, id)
+	}
+
+	res, err := Repair(ctx, cfg, report, zap.NewNop())
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if len(res.Quarantined) != 1 || res.Quarantined[0] != id {
+		t.Errorf("quarantined %v, want [%s]", res.Quarantined, id)
+	}
+	if len(res.Salvaged) != 1 || res.Salvaged[0].Series != 1 || res.Salvaged[0].Samples != 60 {
+		t.Errorf("salvaged %+v, want one series of 60 samples", res.Salvaged)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataPath, quarantineDir, id)); err != nil {
+		t.Errorf("block not in quarantine: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataPath, id)); !os.IsNotExist(err) {
+		t.Errorf("corrupt block still in the data path: %v", err)
+	}
+
+	report, err = Verify(ctx, cfg.DataPath, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !report.Healthy {
+		t.Errorf("storage unhealthy after repair: %+v", report)
+	}
+}
+
+func TestRepairRefusesOpenStorage(t *testing.T) {
+	cfg := Config{DataPath: t.TempDir()}
+	id := corruptBlock(t, cfg)
+	ctx := context.Background()
+	report, err := Verify(ctx, cfg.DataPath, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	s, err := NewStore(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+	if _, err := Repair(ctx, cfg, report, zap.NewNop()); err == nil {
+		t.Fatal("Repair succeeded while the storage was open")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataPath, id)); err != nil {
+		t.Errorf("block moved while the storage was open: %v", err)
+	}
+	entries, err := os.ReadDir(cfg.DataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.IsDir() && e.Name() != id && len(e.Name()) == len(id) {
+			t.Errorf("block %s written while the storage was open", e.Name())
+		}
+	}
+}